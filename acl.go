@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// cidrACL decides which clients may reach the service based on their IP address.
+type cidrACL struct {
+	allowed []*net.IPNet
+	blocked []*net.IPNet
+}
+
+// newCIDRACL parses the allowed and blocked CIDRs once so that requests only pay for the lookups. An empty allow
+// list allows every client that isn't blocked.
+func newCIDRACL(allowedCIDRs, blockedCIDRs []string) (*cidrACL, error) {
+	allowed, err := parseCIDRs(allowedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+
+	blocked, err := parseCIDRs(blockedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cidrACL{
+		allowed: allowed,
+		blocked: blocked,
+	}, nil
+}
+
+// CIDRParseError lists every CIDR in a list that could not be parsed.
+type CIDRParseError struct {
+	CIDRs []string
+}
+
+func (e *CIDRParseError) Error() string {
+	quoted := make([]string, 0, len(e.CIDRs))
+	for _, cidr := range e.CIDRs {
+		quoted = append(quoted, strconv.Quote(cidr))
+	}
+
+	return fmt.Sprintf("could not parse CIDRs %s", strings.Join(quoted, ", "))
+}
+
+// parseCIDRs parses every CIDR it can. If any fail, the networks that did parse are returned along with a
+// CIDRParseError listing the ones that didn't.
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	invalid := []string{}
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			invalid = append(invalid, cidr)
+			continue
+		}
+
+		networks = append(networks, network)
+	}
+
+	if len(invalid) > 0 {
+		return networks, &CIDRParseError{CIDRs: invalid}
+	}
+
+	return networks, nil
+}
+
+// permits reports whether the given client IP may use the service. Blocked networks win over allowed ones.
+func (acl *cidrACL) permits(ip net.IP) bool {
+	// Without a parseable address we can't tell whether the client is on either list, so only let it through when
+	// there are no lists at all.
+	if ip == nil {
+		return len(acl.allowed) == 0 && len(acl.blocked) == 0
+	}
+
+	for _, network := range acl.blocked {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	if len(acl.allowed) == 0 {
+		return true
+	}
+
+	for _, network := range acl.allowed {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// aclMiddleware rejects requests from clients that the current ACL doesn't permit with a 403. It relies on
+// requesterIPMiddleware having already worked out the client IP.
+func (apictx *APIContext) aclMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !apictx.acl.Load().permits(net.ParseIP(requesterIPFromContext(r.Context()))) {
+			writeProblem(w, r, problemTypeBlank, http.StatusForbidden, "Requests from this address are not allowed")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Actions recorded in the audit log.
+const (
+	AuditActionOn     = "on"
+	AuditActionOff    = "off"
+	AuditActionToggle = "toggle"
+
+	// AuditActionEraseEmeter marks a plug's energy usage history being erased. The plug's state isn't changed.
+	AuditActionEraseEmeter = "erase_emeter"
+)
+
+// Sources of plug state changes recorded in the audit log.
+const (
+	AuditSourceAPI      = "api"
+	AuditSourceKeyboard = "keyboard"
+	AuditSourceRule     = "rule"
+	AuditSourceWebhook  = "webhook"
+
+	// AuditSourceCorrection marks state changes that weren't commanded but found when checking back with a plug
+	// after a command, for example because the plug rejected it or was switched by hand in the meantime.
+	AuditSourceCorrection = "correction"
+
+	// AuditSourceRetry marks state changes made by retrying a command that failed earlier.
+	AuditSourceRetry = "retry"
+)
+
+// AuditEntry is a single line of the audit log.
+type AuditEntry struct {
+	Timestamp   time.Time `json:"timestamp"`
+	RequesterIP string    `json:"requester_ip"`
+	PlugName    string    `json:"plug_name"`
+	PlugIP      string    `json:"plug_ip"`
+	Action      string    `json:"action"`
+	Source      string    `json:"source"`
+
+	// On is the state the plug was left in.
+	On      bool `json:"on"`
+	Success bool `json:"success"`
+}
+
+// AuditLogger appends plug state changes as JSON lines to a file. The file is rotated daily; the previous day's log
+// is renamed to include its date (ex. audit-2024-04-06.log). A nil *AuditLogger discards everything so callers
+// don't need to check whether auditing is enabled.
+type AuditLogger struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+	day  string
+}
+
+// NewAuditLogger opens the audit log at path. It returns a nil logger if path is empty.
+func NewAuditLogger(path string) (*AuditLogger, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	logger := &AuditLogger{path: path}
+
+	// Pick up where we left off so that a log left over from a previous day still gets rotated.
+	day := time.Now().Format(time.DateOnly)
+	if info, err := os.Stat(path); err == nil {
+		day = info.ModTime().Format(time.DateOnly)
+	}
+
+	err := logger.open(day)
+	if err != nil {
+		return nil, err
+	}
+
+	return logger, nil
+}
+
+func (a *AuditLogger) open(day string) error {
+	file, err := os.OpenFile(a.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("could not open audit log %q; %w", a.path, err)
+	}
+
+	a.file = file
+	a.day = day
+	return nil
+}
+
+// rotate moves the current log aside under its day's name and starts a new one. The caller must hold mu.
+func (a *AuditLogger) rotate(today string) error {
+	err := a.file.Close()
+	if err != nil {
+		return err
+	}
+
+	ext := filepath.Ext(a.path)
+	rotatedPath := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(a.path, ext), a.day, ext)
+
+	err = os.Rename(a.path, rotatedPath)
+	if err != nil {
+		return err
+	}
+
+	return a.open(today)
+}
+
+// Log appends an entry to the audit log. Failures are logged rather than returned since a broken audit log
+// shouldn't stop plugs from being controlled.
+func (a *AuditLogger) Log(entry AuditEntry) {
+	if a == nil {
+		return
+	}
+
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Error().Err(err).Msg("could not encode audit log entry")
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil {
+		return
+	}
+
+	today := entry.Timestamp.Format(time.DateOnly)
+	if today != a.day {
+		err = a.rotate(today)
+		if err != nil {
+			log.Error().Err(err).Str("path", a.path).Msg("could not rotate audit log")
+			if a.file == nil {
+				return
+			}
+		}
+	}
+
+	_, err = a.file.Write(append(line, '\n'))
+	if err != nil {
+		log.Error().Err(err).Str("path", a.path).Msg("could not write audit log entry")
+	}
+}
+
+// LogToggle records a change to a plug's state, or any other action taken on a plug.
+func (a *AuditLogger) LogToggle(requesterIP string, plug *plug, action, source string, success bool) {
+	if a == nil {
+		return
+	}
+
+	plugIP, _ := plug.addresses()
+	a.Log(AuditEntry{
+		RequesterIP: requesterIP,
+		PlugName:    plug.Name,
+		PlugIP:      plugIP,
+		Action:      action,
+		Source:      source,
+		On:          plug.IsOn(),
+		Success:     success,
+	})
+}
+
+// Close closes the audit log file.
+func (a *AuditLogger) Close() error {
+	if a == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil {
+		return nil
+	}
+
+	err := a.file.Close()
+	a.file = nil
+	return err
+}
+
+type requesterIPContextKey struct{}
+
+// requesterIPFromContext returns the client IP injected by requesterIPMiddleware.
+func requesterIPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(requesterIPContextKey{}).(string)
+	return ip
+}
+
+// requesterIPMiddleware injects the IP of the client that made the request into the request context. The
+// X-Forwarded-For header is only believed when the request came through one of the configured trusted proxies.
+func (apictx *APIContext) requesterIPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+
+		if apictx.isTrustedProxy(ip) {
+			ip = apictx.forwardedClientIP(r.Header.Values("X-Forwarded-For"), ip)
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requesterIPContextKey{}, ip)))
+	})
+}
+
+// forwardedClientIP finds the client in the X-Forwarded-For headers of a request sent by a trusted proxy. Every
+// proxy appends the address it received the request from, so only the hops at the end of the list were written by
+// proxies we trust; anything before that could have been sent by the client. The list is walked from the right and
+// the first hop that isn't a trusted proxy is the client. peer is returned if that hop isn't an IP address.
+func (apictx *APIContext) forwardedClientIP(forwardedFor []string, peer string) string {
+	hops := []string{}
+	for _, header := range forwardedFor {
+		for _, hop := range strings.Split(header, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		if net.ParseIP(hops[i]) == nil {
+			return peer
+		}
+		if !apictx.isTrustedProxy(hops[i]) {
+			return hops[i]
+		}
+	}
+
+	// Every hop is one of our proxies, so the request started at the first of them.
+	if len(hops) > 0 {
+		return hops[0]
+	}
+
+	return peer
+}
+
+// isTrustedProxy reports whether the given IP matches one of the configured trusted proxies. Entries may be single
+// IPs or CIDRs.
+func (apictx *APIContext) isTrustedProxy(ip string) bool {
+	cidrs := make([]string, 0, len(apictx.config.TrustedProxies))
+	for _, proxy := range apictx.config.TrustedProxies {
+		// A single address is a network of one.
+		if net.ParseIP(proxy) != nil {
+			bits := 32
+			if strings.Contains(proxy, ":") {
+				bits = 128
+			}
+			proxy = fmt.Sprintf("%s/%d", proxy, bits)
+		}
+
+		cidrs = append(cidrs, proxy)
+	}
+
+	// Entries that don't parse are skipped; the rest still count.
+	trusted, _ := containsCIDR(cidrs, ip)
+	return trusted
+}
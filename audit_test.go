@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/clintjedwards/innerhaven/internal/config"
+)
+
+func TestRequesterIP(t *testing.T) {
+	conf := config.DefaultAPIConfig()
+	conf.TrustedProxies = []string{"192.0.2.1", "10.0.0.0/8"}
+
+	apictx, _ := newTestAPI(t, conf)
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		want         string
+	}{
+		{name: "direct", remoteAddr: "198.51.100.1:1234", want: "198.51.100.1"},
+		{
+			name:         "untrusted peer",
+			remoteAddr:   "198.51.100.1:1234",
+			forwardedFor: []string{"203.0.113.1"},
+			want:         "198.51.100.1",
+		},
+		{name: "one proxy", remoteAddr: "192.0.2.1:1234", forwardedFor: []string{"203.0.113.1"}, want: "203.0.113.1"},
+		{
+			name:         "spoofed hop before the client",
+			remoteAddr:   "192.0.2.1:1234",
+			forwardedFor: []string{"198.51.100.9, 203.0.113.1"},
+			want:         "203.0.113.1",
+		},
+		{
+			name:         "chain of trusted proxies",
+			remoteAddr:   "192.0.2.1:1234",
+			forwardedFor: []string{"198.51.100.9, 203.0.113.1, 10.1.2.3"},
+			want:         "203.0.113.1",
+		},
+		{
+			name:         "repeated headers",
+			remoteAddr:   "192.0.2.1:1234",
+			forwardedFor: []string{"198.51.100.9", "203.0.113.1, 10.1.2.3"},
+			want:         "203.0.113.1",
+		},
+		{name: "only proxies", remoteAddr: "192.0.2.1:1234", forwardedFor: []string{"10.1.2.3"}, want: "10.1.2.3"},
+		{name: "hop isn't an IP", remoteAddr: "192.0.2.1:1234", forwardedFor: []string{"unknown"}, want: "192.0.2.1"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var got string
+			handler := apictx.requesterIPMiddleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				got = requesterIPFromContext(r.Context())
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/api/plugs", nil)
+			r.RemoteAddr = tc.remoteAddr
+			for _, value := range tc.forwardedFor {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			if got != tc.want {
+				t.Errorf("requester IP = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// Role is the level of access granted to the token a request was made with.
+type Role string
+
+const (
+	// RoleAdmin can call every endpoint.
+	RoleAdmin Role = "admin"
+
+	// RoleReader can only call endpoints that don't change anything.
+	RoleReader Role = "reader"
+)
+
+// bearerAuth is the OpenAPI security requirement attached to every API operation so that the docs prompt for a token.
+// It refers to the "bearer" scheme declared in InitRouter.
+var bearerAuth = []map[string][]string{{"bearer": {}}}
+
+type (
+	roleContextKey  struct{}
+	tokenContextKey struct{}
+)
+
+// roleFromContext returns the role injected by roleMiddleware.
+func roleFromContext(ctx context.Context) (Role, bool) {
+	role, ok := ctx.Value(roleContextKey{}).(Role)
+	return role, ok
+}
+
+// requireAdmin returns a 403 unless the request was made with the admin token. Write endpoints call this before
+// doing anything.
+func requireAdmin(ctx context.Context) error {
+	role, ok := roleFromContext(ctx)
+	if !ok || role != RoleAdmin {
+		return huma.Error403Forbidden("This action requires the admin token")
+	}
+
+	return nil
+}
+
+// tokenMatches compares tokens in constant time so the comparison doesn't leak how much of a token was correct.
+func tokenMatches(given, want string) bool {
+	return want != "" && subtle.ConstantTimeCompare([]byte(given), []byte(want)) == 1
+}
+
+// authEnabled reports whether any API tokens are configured.
+func (apictx *APIContext) authEnabled() bool {
+	return apictx.config.AdminToken != "" || apictx.config.ReadToken != ""
+}
+
+// warnIfAuthDisabled logs a warning at startup when no tokens are configured, since then anyone who can reach the
+// service may control every plug.
+func (apictx *APIContext) warnIfAuthDisabled() {
+	if apictx.authEnabled() {
+		return
+	}
+
+	log.Warn().Msg("AUTHENTICATION IS DISABLED: neither admin_token nor read_token is configured, so every " +
+		"request is treated as an admin; set a token unless the service is only reachable by trusted clients")
+}
+
+// authenticate returns the token and role for the given Authorization header value. It returns false if the header
+// doesn't carry a known bearer token.
+func (apictx *APIContext) authenticate(authorization string) (token string, role Role, ok bool) {
+	token, found := strings.CutPrefix(authorization, "Bearer ")
+	if !found {
+		return "", "", false
+	}
+
+	switch {
+	case tokenMatches(token, apictx.config.AdminToken):
+		return token, RoleAdmin, true
+	case tokenMatches(token, apictx.config.ReadToken):
+		return token, RoleReader, true
+	default:
+		return "", "", false
+	}
+}
+
+// withAuth returns a copy of the context carrying the caller's role and token.
+func withAuth(ctx context.Context, role Role, token string) context.Context {
+	ctx = context.WithValue(ctx, roleContextKey{}, role)
+	return context.WithValue(ctx, tokenContextKey{}, token)
+}
+
+// roleMiddleware verifies the bearer token on API requests and injects the matching Role into the request context.
+// The docs, health checks and the frontend are served without a token. If no tokens are configured every request is an admin.
+func (apictx *APIContext) roleMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !apictx.authEnabled() {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), roleContextKey{}, RoleAdmin)))
+			return
+		}
+
+		if !strings.HasPrefix(r.URL.Path, "/api/") || strings.HasPrefix(r.URL.Path, "/api/docs") ||
+			strings.HasPrefix(r.URL.Path, "/api/health/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, role, ok := apictx.authenticate(r.Header.Get("Authorization"))
+		if !ok {
+			writeProblem(w, r, problemTypeBlank, http.StatusUnauthorized, "A valid bearer token is required")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(withAuth(r.Context(), role, token)))
+	})
+}
+
+// plugAllowed reports whether the token the request was made with may use the given plug. It is checked wherever a
+// handler resolves a plug, whether the plug is named in the path or the body, and filters whatever reports on
+// several plugs at once.
+func (apictx *APIContext) plugAllowed(ctx context.Context, plug *plug) bool {
+	if !apictx.authEnabled() {
+		return true
+	}
+
+	token, _ := ctx.Value(tokenContextKey{}).(string)
+	return plug.tokenAllowed(token)
+}
+
+// tokenAllowed reports whether the given token may use the plug's endpoints.
+func (p *plug) tokenAllowed(token string) bool {
+	if len(p.AllowedTokens) == 0 {
+		return true
+	}
+
+	for _, allowed := range p.AllowedTokens {
+		if tokenMatches(token, allowed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lookupPlug returns the plug a handler was asked about. It returns a 404 if there is no such plug and a 403 if the
+// request's token may not use it, rather than a 404, so callers with a valid token know the plug exists.
+func (apictx *APIContext) lookupPlug(ctx context.Context, ip string) (*plug, error) {
+	plug, exists := apictx.getPlug(ip)
+	if !exists {
+		return nil, plugNotFoundError("Plug not found")
+	}
+
+	if !apictx.plugAllowed(ctx, plug) {
+		return nil, huma.Error403Forbidden("This token may not use this plug")
+	}
+
+	return plug, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/clintjedwards/innerhaven/internal/config"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+func TestWarnIfAuthDisabled(t *testing.T) {
+	tests := []struct {
+		name       string
+		adminToken string
+		readToken  string
+		wantWarn   bool
+	}{
+		{name: "no tokens", wantWarn: true},
+		{name: "admin token", adminToken: "admin"},
+		{name: "read token", readToken: "read"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			conf := config.DefaultAPIConfig()
+			conf.AdminToken = tc.adminToken
+			conf.ReadToken = tc.readToken
+			apictx, _ := newTestAPI(t, conf)
+
+			var buf bytes.Buffer
+			logger := log.Logger
+			log.Logger = zerolog.New(&buf)
+			t.Cleanup(func() { log.Logger = logger })
+
+			apictx.warnIfAuthDisabled()
+
+			warned := strings.Contains(buf.String(), "AUTHENTICATION IS DISABLED")
+			if warned != tc.wantWarn {
+				t.Errorf("warned = %v, want %v; log: %s", warned, tc.wantWarn, buf.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// deadLetterQueueSize is how many failed state changes can be waiting for a retry at once.
+const deadLetterQueueSize = 100
+
+// deadLetterHistorySize is how many permanently failed state changes are kept to be listed.
+const deadLetterHistorySize = 10
+
+// FailedCommand is a plug state change that failed and is waiting in the dead letter queue to be tried again.
+type FailedCommand struct {
+	plug *plug
+	// generation is the plug's state generation when the command failed. The retry is skipped if the plug has been
+	// changed since, so that an old command never undoes a newer one.
+	generation uint64
+
+	PlugName string
+	PlugIP   string
+	Action   string
+	Error    string
+	FailedAt time.Time
+}
+
+// DeadLetterQueue holds plug state changes that failed. Each is retried once after config.DLQRetryInterval; if
+// the retry fails too the command is given up on and kept in a short list of permanent failures.
+type DeadLetterQueue struct {
+	queue chan FailedCommand
+
+	mu   sync.Mutex
+	dead []FailedCommand
+}
+
+func newDeadLetterQueue() *DeadLetterQueue {
+	return &DeadLetterQueue{
+		queue: make(chan FailedCommand, deadLetterQueueSize),
+		dead:  []FailedCommand{},
+	}
+}
+
+// bury records a command that failed its retry, forgetting the oldest once there are too many.
+func (q *DeadLetterQueue) bury(command FailedCommand) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.dead = append(q.dead, command)
+	if len(q.dead) > deadLetterHistorySize {
+		q.dead = q.dead[len(q.dead)-deadLetterHistorySize:]
+	}
+}
+
+// recent returns the permanently failed commands, newest first.
+func (q *DeadLetterQueue) recent() []FailedCommand {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	commands := make([]FailedCommand, 0, len(q.dead))
+	for i := len(q.dead) - 1; i >= 0; i-- {
+		commands = append(commands, q.dead[i])
+	}
+
+	return commands
+}
+
+// runDeadLetterQueue retries failed commands one at a time, each once its retry interval has passed, until ctx is
+// done.
+func (apictx *APIContext) runDeadLetterQueue(ctx context.Context) {
+	for {
+		var command FailedCommand
+		select {
+		case <-ctx.Done():
+			return
+		case command = <-apictx.deadLetters.queue:
+		}
+
+		timer := time.NewTimer(time.Until(command.FailedAt.Add(apictx.config.DLQRetryInterval)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		apictx.retryFailedCommand(ctx, command)
+	}
+}
+
+func (apictx *APIContext) retryFailedCommand(ctx context.Context, command FailedCommand) {
+	on := command.Action == "on"
+
+	stale, err := command.plug.retryState(ctx, on, command.generation)
+	if stale {
+		log.Debug().Str("plug", command.PlugName).Str("action", command.Action).
+			Msg("plug changed since command failed; dropping retry")
+		return
+	}
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+
+	apictx.recordStateChange("", command.plug, command.Action, AuditSourceRetry, err == nil)
+	if err != nil {
+		log.Error().Err(err).Str("plug", command.PlugName).Str("action", command.Action).
+			Time("failed_at", command.FailedAt).Msg("plug command failed again on retry; giving up")
+
+		command.Error = err.Error()
+		apictx.deadLetters.bury(command)
+		return
+	}
+
+	log.Info().Str("plug", command.PlugName).Str("action", command.Action).Msg("failed plug command succeeded on retry")
+}
+
+type (
+	DescribeDeadLetterQueueRequest struct{}
+	DeadLetter                     struct {
+		PlugName string `json:"plug_name" example:"Office Lamp" doc:"The name of the plug"`
+		PlugIP   string `json:"plug_ip" example:"192.168.1.20" doc:"The address of the plug"`
+		Action   string `json:"action" example:"on" enum:"on,off" doc:"The state the plug was being switched to"`
+		Error    string `json:"error" example:"i/o timeout" doc:"The error from the last attempt"`
+		FailedAt int64  `json:"failed_at" example:"1712433802634" doc:"Time the command first failed in epoch milliseconds"`
+	}
+	DescribeDeadLetterQueueResponse struct {
+		Body struct {
+			Depth    int          `json:"depth" example:"2" doc:"Amount of failed commands waiting to be retried"`
+			Capacity int          `json:"capacity" example:"100" doc:"The most failed commands that can wait at once; more are dropped"`
+			Failed   []DeadLetter `json:"failed" doc:"The most recent commands that failed their retry too, newest first"`
+		}
+	}
+)
+
+func (apictx *APIContext) registerDescribeDeadLetterQueue(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "DescribeDeadLetterQueue",
+		Method:      http.MethodGet,
+		Path:        "/api/dlq",
+		Summary:     "Describe the dead letter queue",
+		Description: "Return how many failed plug state changes are waiting to be retried along with the most " +
+			"recent ones that were given up on after failing their retry. Only plugs the token may use are listed.",
+		Tags:     []string{"System"},
+		Security: bearerAuth,
+		// Handler //
+	}, func(ctx context.Context, _ *DescribeDeadLetterQueueRequest) (*DescribeDeadLetterQueueResponse, error) {
+		resp := &DescribeDeadLetterQueueResponse{}
+		resp.Body.Depth = len(apictx.deadLetters.queue)
+		resp.Body.Capacity = cap(apictx.deadLetters.queue)
+		resp.Body.Failed = []DeadLetter{}
+		for _, command := range apictx.deadLetters.recent() {
+			if !apictx.plugAllowed(ctx, command.plug) {
+				continue
+			}
+
+			resp.Body.Failed = append(resp.Body.Failed, DeadLetter{
+				PlugName: command.PlugName,
+				PlugIP:   command.PlugIP,
+				Action:   command.Action,
+				Error:    command.Error,
+				FailedAt: command.FailedAt.UnixMilli(),
+			})
+		}
+
+		return resp, nil
+	})
+}
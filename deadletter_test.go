@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/clintjedwards/innerhaven/internal/config"
+)
+
+func TestDescribeDeadLetterQueueFiltersPlugs(t *testing.T) {
+	conf := config.DefaultAPIConfig()
+	conf.AdminToken = "admin"
+	conf.ReadToken = "reader"
+
+	restricted := newPlug("192.0.2.1", 0)
+	restricted.Name = "restricted"
+	restricted.AllowedTokens = []string{"admin"}
+	open := newPlug("192.0.2.2", 1)
+	open.Name = "open"
+
+	apictx, handler := newTestAPI(t, conf, restricted, open)
+	for _, p := range []*plug{restricted, open} {
+		apictx.deadLetters.bury(FailedCommand{plug: p, PlugName: p.Name, PlugIP: p.Address, Action: "on"})
+	}
+
+	tests := []struct {
+		token string
+		want  []string
+	}{
+		{token: "admin", want: []string{"open", "restricted"}},
+		{token: "reader", want: []string{"open"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.token, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/dlq", nil)
+			r.Header.Set("Authorization", "Bearer "+tc.token)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+
+			var body struct {
+				Failed []DeadLetter `json:"failed"`
+			}
+			err := json.NewDecoder(w.Body).Decode(&body)
+			if err != nil {
+				t.Fatalf("could not decode response: %v", err)
+			}
+
+			var got []string
+			for _, command := range body.Failed {
+				got = append(got, command.PlugName)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("failed commands for %s = %q, want %q", tc.token, got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Errorf("failed commands for %s = %q, want %q", tc.token, got, tc.want)
+					break
+				}
+			}
+		})
+	}
+}
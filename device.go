@@ -0,0 +1,111 @@
+package main
+
+import "strings"
+
+// DeviceType is the general category of a Kasa device, which determines the commands it understands.
+type DeviceType int
+
+const (
+	DeviceTypeUnknown DeviceType = iota
+	DeviceTypeOutlet
+	DeviceTypeDimmer
+	DeviceTypeStrip
+	DeviceTypeBulb
+)
+
+func (t DeviceType) String() string {
+	switch t {
+	case DeviceTypeOutlet:
+		return "outlet"
+	case DeviceTypeDimmer:
+		return "dimmer"
+	case DeviceTypeStrip:
+		return "strip"
+	case DeviceTypeBulb:
+		return "bulb"
+	default:
+		return "unknown"
+	}
+}
+
+// Models that share a prefix with another device type but are actually something else. These are checked before
+// the general prefixes below.
+var (
+	stripModels  = []string{"HS107", "HS300", "KP200", "KP303", "KP400"}
+	dimmerModels = []string{"HS220"}
+)
+
+// DetectDeviceType determines the type of a device from its model string (ex: "HS105(US)").
+func DetectDeviceType(model string) DeviceType {
+	for _, prefix := range stripModels {
+		if strings.HasPrefix(model, prefix) {
+			return DeviceTypeStrip
+		}
+	}
+
+	for _, prefix := range dimmerModels {
+		if strings.HasPrefix(model, prefix) {
+			return DeviceTypeDimmer
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(model, "KS"):
+		return DeviceTypeDimmer
+	case strings.HasPrefix(model, "KL"), strings.HasPrefix(model, "LB"):
+		return DeviceTypeBulb
+	case strings.HasPrefix(model, "HS"), strings.HasPrefix(model, "KP"), strings.HasPrefix(model, "EP"):
+		return DeviceTypeOutlet
+	default:
+		return DeviceTypeUnknown
+	}
+}
+
+// Capabilities advertise which controls a device supports so that clients don't have to interpret model strings.
+const (
+	CapabilityToggle     = "toggle"
+	CapabilityEmeter     = "emeter"
+	CapabilityBrightness = "brightness"
+	CapabilityColor      = "color"
+	CapabilityColorTemp  = "color_temp"
+)
+
+// emeterModels are the model prefixes of devices with built in energy monitoring.
+var emeterModels = []string{"HS110", "HS300", "KP115", "KP125"}
+
+// hasEmeter reports whether the given model supports energy monitoring.
+func hasEmeter(model string) bool {
+	for _, prefix := range emeterModels {
+		if strings.HasPrefix(model, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// capabilities returns the controls supported by the plug, derived from its device type and model.
+func (p *plug) capabilities() []string {
+	capabilities := []string{}
+
+	switch p.DeviceType {
+	case DeviceTypeOutlet, DeviceTypeStrip:
+		capabilities = append(capabilities, CapabilityToggle)
+		if hasEmeter(p.Model) {
+			capabilities = append(capabilities, CapabilityEmeter)
+		}
+	case DeviceTypeDimmer:
+		capabilities = append(capabilities, CapabilityToggle, CapabilityBrightness)
+	case DeviceTypeBulb:
+		capabilities = append(capabilities, CapabilityToggle, CapabilityBrightness)
+		if isColorBulb(p.Model) {
+			capabilities = append(capabilities, CapabilityColor)
+		}
+		if p.ColorTempRange[1] != 0 {
+			capabilities = append(capabilities, CapabilityColorTemp)
+		}
+	default:
+		capabilities = append(capabilities, CapabilityToggle)
+	}
+
+	return capabilities
+}
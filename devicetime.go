@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// deviceTime mirrors the time fields used by the plug's get_time and set_time commands.
+type deviceTime struct {
+	Year  int `json:"year"`
+	Month int `json:"month"`
+	MDay  int `json:"mday"`
+	Hour  int `json:"hour"`
+	Min   int `json:"min"`
+	Sec   int `json:"sec"`
+}
+
+// GetDeviceTime returns the plug's current clock. Plugs have no notion of timezones so the result is
+// interpreted as UTC, matching what SyncTime sets.
+func (p *plug) GetDeviceTime() (time.Time, error) {
+	payload := `{"time":{"get_time":{}}}`
+	results, err := p.sendCmd(payload)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var response struct {
+		Time struct {
+			GetTime deviceTime `json:"get_time"`
+		} `json:"time"`
+	}
+	err = json.Unmarshal(results, &response)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	t := response.Time.GetTime
+	return time.Date(t.Year, time.Month(t.Month), t.MDay, t.Hour, t.Min, t.Sec, 0, time.UTC), nil
+}
+
+// SyncTime sets the plug's clock to the server's current time in UTC.
+func (p *plug) SyncTime() error {
+	deviceNow, err := p.GetDeviceTime()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	log.Info().Str("plug", p.Name).Float64("drift_secs", deviceNow.Sub(now).Seconds()).
+		Msg("syncing plug clock")
+
+	payload, err := json.Marshal(map[string]any{
+		"time": map[string]any{
+			"set_time": deviceTime{
+				Year:  now.Year(),
+				Month: int(now.Month()),
+				MDay:  now.Day(),
+				Hour:  now.Hour(),
+				Min:   now.Minute(),
+				Sec:   now.Second(),
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("encoding set_time payload: %w", err)
+	}
+
+	_, err = p.sendCmd(string(payload))
+	return err
+}
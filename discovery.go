@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// discoveryProbeTimeout is how long each address in a subnet scan gets to accept a connection.
+	discoveryProbeTimeout = 500 * time.Millisecond
+
+	// discoveryWorkers is the amount of addresses probed in parallel during a subnet scan.
+	discoveryWorkers = 64
+
+	// discoveryMinPrefix is the smallest IPv4 prefix we're willing to scan; anything larger is almost certainly
+	// a misconfiguration and would take far too long.
+	discoveryMinPrefix = 16
+)
+
+// discoveredDevice is a Kasa device found during a subnet scan.
+type discoveredDevice struct {
+	Address string
+	Info    info
+}
+
+// ScanSubnet probes every host address within the given CIDR on the given port and returns the Kasa devices that
+// responded to a system info request.
+func ScanSubnet(cidr string, port int) ([]discoveredDevice, error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("parsing discovery CIDR: %w", err)
+	}
+
+	if !prefix.Addr().Is4() || prefix.Bits() < discoveryMinPrefix {
+		return nil, fmt.Errorf("discovery CIDR %q must be an IPv4 range no larger than a /%d", cidr, discoveryMinPrefix)
+	}
+
+	addresses := make(chan string)
+	go func() {
+		defer close(addresses)
+		for addr := prefix.Masked().Addr(); prefix.Contains(addr); addr = addr.Next() {
+			addresses <- addr.String()
+		}
+	}()
+
+	var mtx sync.Mutex
+	var wg sync.WaitGroup
+	devices := []discoveredDevice{}
+
+	for i := 0; i < discoveryWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for address := range addresses {
+				probe := newPlug(address, 0)
+				probe.Port = port
+				probe.DialTimeout = discoveryProbeTimeout
+
+				info, err := probe.systemInfo()
+				if err != nil {
+					continue
+				}
+
+				mtx.Lock()
+				devices = append(devices, discoveredDevice{Address: address, Info: info.info})
+				mtx.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return devices, nil
+}
+
+// startRediscovery launches a background search for the plug by its device ID. It is a no-op if discovery isn't
+// configured, the device ID isn't known yet or a search is already running.
+func (p *plug) startRediscovery() {
+	if p.discoveryCIDR == "" || p.discoveryInterval <= 0 || p.DeviceID == "" {
+		return
+	}
+
+	if !atomic.CompareAndSwapInt32(&p.discovering, 0, 1) {
+		return
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&p.discovering, 0)
+
+		ticker := time.NewTicker(p.discoveryInterval)
+		defer ticker.Stop()
+
+		for {
+			if p.isOnline() {
+				return
+			}
+
+			if p.rediscover() {
+				return
+			}
+
+			<-ticker.C
+		}
+	}()
+}
+
+// rediscover scans the discovery subnet once for the plug's device ID and adopts the new address if the plug has
+// moved. It returns true once the plug has been found.
+func (p *plug) rediscover() bool {
+	devices, err := ScanSubnet(p.discoveryCIDR, p.Port)
+	if err != nil {
+		log.Error().Err(err).Str("plug", p.Name).Msg("could not scan subnet for plug")
+		return false
+	}
+
+	for _, device := range devices {
+		if device.Info.DeviceID != p.DeviceID {
+			continue
+		}
+
+		oldAddress, _ := p.addresses()
+		if device.Address == oldAddress {
+			atomic.StoreInt32(&p.online, 1)
+			return true
+		}
+
+		p.addrMtx.Lock()
+		p.Address = device.Address
+		p.addrMtx.Unlock()
+		atomic.StoreInt32(&p.online, 1)
+
+		log.Info().Str("plug", p.Name).Str("old_address", oldAddress).Str("new_address", device.Address).
+			Msg("rediscovered plug at new address")
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+// MonthStatEntry is the energy used by a plug over a single month.
+type MonthStatEntry struct {
+	Month    int
+	EnergyWH float64
+}
+
+// GetMonthlyEmeterStats returns the energy used per month for the given year on plugs with energy monitoring.
+func (p *plug) GetMonthlyEmeterStats(year int) ([]MonthStatEntry, error) {
+	payload, err := json.Marshal(map[string]any{
+		"emeter": map[string]any{
+			"get_monthstat": map[string]int{
+				"year": year,
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := p.sendCmd(string(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Emeter struct {
+			GetMonthStat struct {
+				MonthList []struct {
+					Month     int      `json:"month"`
+					EnergyWH  *float64 `json:"energy_wh"`
+					EnergyKWH float64  `json:"energy"` // Older firmware reports kWh under a different key.
+				} `json:"month_list"`
+			} `json:"get_monthstat"`
+		} `json:"emeter"`
+	}
+	err = json.Unmarshal(results, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []MonthStatEntry{}
+	for _, month := range response.Emeter.GetMonthStat.MonthList {
+		entries = append(entries, MonthStatEntry{
+			Month:    month.Month,
+			EnergyWH: deref(month.EnergyWH, month.EnergyKWH*1000),
+		})
+	}
+
+	return entries, nil
+}
+
+// EraseEmeterStats clears all energy usage history recorded by the plug.
+func (p *plug) EraseEmeterStats() error {
+	payload := `{"emeter":{"erase_emeter_stat":{}}}`
+	_, err := p.sendCmd(payload)
+	return err
+}
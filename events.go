@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// plugEventsPath is the path of the plug state change stream. Streams stay open indefinitely so the server's write
+// timeout is lifted for this path.
+const plugEventsPath = "/api/plugs/events"
+
+// PlugEvent is sent to stream clients whenever a plug changes state.
+type PlugEvent = PlugHistoryEvent
+
+func newPlugEvent(event ToggleEvent) PlugEvent {
+	return PlugEvent{
+		Time:     event.Time.UnixMilli(),
+		PlugName: event.PlugName,
+		PlugIP:   event.PlugIP,
+		On:       event.State,
+		Source:   event.Source,
+	}
+}
+
+// isLongLivedPath reports whether requests to the given path are meant to stay open indefinitely, like event streams
+// and websockets.
+func isLongLivedPath(path string) bool {
+	return path == plugEventsPath || path == plugWebSocketPath
+}
+
+// streamingMiddleware removes the write deadline for long lived streaming responses, which would otherwise be cut
+// off once the server's write timeout passes.
+func streamingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == plugEventsPath {
+			_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type StreamPlugEventsRequest struct {
+	LastEventID string `header:"Last-Event-ID" doc:"The id of the last event received; events after it that are still buffered are replayed first"`
+}
+
+// writePlugEvent writes a single server sent event and flushes it to the client.
+func writePlugEvent(w io.Writer, event ToggleEvent) error {
+	data, err := json.Marshal(newPlugEvent(event))
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: state_change\ndata: %s\n\n", event.Seq, data)
+	if err != nil {
+		return err
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return fmt.Errorf("could not flush event; %w", http.ErrNotSupported)
+	}
+	flusher.Flush()
+
+	return nil
+}
+
+func (apictx *APIContext) registerStreamPlugEvents(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "StreamPlugEvents",
+		Method:      http.MethodGet,
+		Path:        plugEventsPath,
+		Summary:     "Stream plug state changes",
+		Description: "Stream plug state changes as server sent events named state_change. Every event has an " +
+			"increasing id; clients that reconnect with the Last-Event-ID header are first sent the events they " +
+			"missed, as long as those are among the last 1000.",
+		Tags:     []string{"Plugs"},
+		Security: bearerAuth,
+		Responses: map[string]*huma.Response{
+			"200": {
+				Description: "A stream of state_change events",
+				Content: map[string]*huma.MediaType{
+					"text/event-stream": {
+						Schema: apiDesc.OpenAPI().Components.Schemas.Schema(reflect.TypeOf(PlugEvent{}), true, ""),
+					},
+				},
+			},
+		},
+		// Handler //
+	}, func(_ context.Context, request *StreamPlugEventsRequest) (*huma.StreamResponse, error) {
+		var after uint64
+		resume := false
+		if request.LastEventID != "" {
+			id, err := strconv.ParseUint(request.LastEventID, 10, 64)
+			if err == nil {
+				after, resume = id, true
+			}
+		}
+
+		return &huma.StreamResponse{
+			Body: func(ctx huma.Context) {
+				ctx.SetHeader("Content-Type", "text/event-stream")
+				ctx.SetHeader("Cache-Control", "no-cache")
+				w := ctx.BodyWriter()
+
+				replay, events, unsubscribe := apictx.history.subscribe(after, resume)
+				defer unsubscribe()
+
+				for _, event := range replay {
+					if !apictx.plugAllowed(ctx.Context(), event.plug) {
+						continue
+					}
+
+					err := writePlugEvent(w, event)
+					if err != nil {
+						return
+					}
+				}
+
+				for {
+					select {
+					case <-ctx.Context().Done():
+						return
+					case event, ok := <-events:
+						// The channel is closed when we fall too far behind or the server is shutting down. The
+						// client can reconnect with Last-Event-ID to pick up where it left off.
+						if !ok {
+							return
+						}
+
+						if !apictx.plugAllowed(ctx.Context(), event.plug) {
+							continue
+						}
+
+						err := writePlugEvent(w, event)
+						if err != nil {
+							return
+						}
+					}
+				}
+			},
+		}, nil
+	})
+}
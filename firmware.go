@@ -0,0 +1,105 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// firmwareVersionsJSON maps a model prefix to the latest firmware version known to work well on it. It is
+// maintained by hand; models that aren't listed are never flagged for upgrade.
+//
+//go:embed firmware_versions.json
+var firmwareVersionsJSON []byte
+
+var firmwareVersions = mustParseFirmwareVersions(firmwareVersionsJSON)
+
+func mustParseFirmwareVersions(data []byte) map[string]string {
+	versions := map[string]string{}
+	err := json.Unmarshal(data, &versions)
+	if err != nil {
+		panic(err)
+	}
+
+	return versions
+}
+
+// FirmwareSuggestion compares the firmware a plug is running against the latest known good version for its model.
+type FirmwareSuggestion struct {
+	Current            string
+	Latest             string
+	UpgradeRecommended bool
+}
+
+// CheckFirmware queries the plug for its current firmware version and compares it against the known good list.
+func (p *plug) CheckFirmware() (FirmwareSuggestion, error) {
+	info, err := p.systemInfo()
+	if err != nil {
+		return FirmwareSuggestion{}, err
+	}
+
+	return firmwareSuggestion(info.Model, info.SoftwareVersion), nil
+}
+
+// firmwareSuggestion compares the given firmware version against the latest known good version for the model.
+func firmwareSuggestion(model, current string) FirmwareSuggestion {
+	suggestion := FirmwareSuggestion{
+		Current: current,
+	}
+
+	for prefix, latest := range firmwareVersions {
+		if !strings.HasPrefix(model, prefix) {
+			continue
+		}
+
+		suggestion.Latest = latest
+		suggestion.UpgradeRecommended = compareFirmwareVersions(current, latest) < 0
+		break
+	}
+
+	return suggestion
+}
+
+// compareFirmwareVersions compares the dotted version number at the start of two firmware version strings
+// (ex: "1.5.6 Build 191125 Rel.135242"). It returns -1 if a is older than b, 1 if a is newer and 0 if they match.
+func compareFirmwareVersions(a, b string) int {
+	aParts := strings.Split(strings.Fields(a + " ")[0], ".")
+	bParts := strings.Split(strings.Fields(b + " ")[0], ".")
+
+	for i := 0; i < max(len(aParts), len(bParts)); i++ {
+		var aNum, bNum int
+		if i < len(aParts) {
+			aNum, _ = strconv.Atoi(aParts[i])
+		}
+		if i < len(bParts) {
+			bNum, _ = strconv.Atoi(bParts[i])
+		}
+
+		switch {
+		case aNum < bNum:
+			return -1
+		case aNum > bNum:
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// warnOutdatedFirmware logs a warning for every plug running firmware older than the known good version.
+func warnOutdatedFirmware(plugs ...*plug) {
+	for _, plug := range plugs {
+		if plug.SoftwareVersion == "" {
+			continue
+		}
+
+		suggestion := firmwareSuggestion(plug.Model, plug.SoftwareVersion)
+		if suggestion.UpgradeRecommended {
+			log.Warn().Str("plug", plug.Name).Str("model", plug.Model).Str("current", suggestion.Current).
+				Str("latest", suggestion.Latest).Msg("plug is running outdated firmware")
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// Code generated by github.com/99designs/gqlgen, DO NOT EDIT.
+
+package model
+
+type Mutation struct {
+}
+
+// A smart plug or bulb managed by the service.
+type Plug struct {
+	// The IP address or hostname the plug is currently reached at.
+	IP    string `json:"ip"`
+	Name  string `json:"name"`
+	Model string `json:"model"`
+	// The room the plug is in, as set in the config file. Empty if none was given.
+	Room string `json:"room"`
+	// Whether the plug's relay is currently on.
+	On bool `json:"on"`
+	// Whether the most recent command sent to the plug succeeded.
+	Online          bool `json:"online"`
+	TotalCommands   int  `json:"totalCommands"`
+	FailureCommands int  `json:"failureCommands"`
+	TotalToggles    int  `json:"totalToggles"`
+	// The schedule rules stored on the plug itself. Fetching these sends a command to the plug.
+	Rules []*Rule `json:"rules"`
+}
+
+type Query struct {
+}
+
+// A schedule rule stored and run on the plug itself, independent of this service.
+type Rule struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	// The days of the week the rule runs on; 0 is Sunday.
+	Weekdays []int `json:"weekdays"`
+	// The minute of the day (0-1439) the rule starts at.
+	StartMinute int `json:"startMinute"`
+	// Whether the rule turns the plug on, rather than off, at its start.
+	StartOn bool `json:"startOn"`
+	// The minute of the day the opposite action is taken at, or null if the rule has no end.
+	EndMinute *int `json:"endMinute,omitempty"`
+}
+
+// A named set of plug states from the config file that can be applied all at once.
+type Scene struct {
+	Name  string       `json:"name"`
+	Plugs []*ScenePlug `json:"plugs"`
+}
+
+// The state a scene puts a single plug in.
+type ScenePlug struct {
+	IP string `json:"ip"`
+	On bool   `json:"on"`
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/clintjedwards/innerhaven/graph"
+	"github.com/clintjedwards/innerhaven/graph/model"
+	"github.com/clintjedwards/innerhaven/internal/config"
+	"github.com/go-chi/chi/v5"
+)
+
+// registerGraphQL serves the GraphQL API at POST /api/graphql. The schema lives in graph/schema.graphqls and is
+// answered by the resolvers below, which share plugs and permissions with the REST API.
+func (apictx *APIContext) registerGraphQL(router chi.Router) {
+	server := handler.New(graph.NewExecutableSchema(graph.Config{Resolvers: &graphQLResolver{apictx: apictx}}))
+	server.AddTransport(transport.POST{})
+
+	router.Method(http.MethodPost, "/api/graphql", server)
+}
+
+// graphQLResolver implements graph.ResolverRoot.
+type graphQLResolver struct {
+	apictx *APIContext
+}
+
+func (r *graphQLResolver) Query() graph.QueryResolver {
+	return &graphQLQueryResolver{r}
+}
+
+func (r *graphQLResolver) Mutation() graph.MutationResolver {
+	return &graphQLMutationResolver{r}
+}
+
+func (r *graphQLResolver) Plug() graph.PlugResolver {
+	return &graphQLPlugResolver{r}
+}
+
+type (
+	graphQLQueryResolver    struct{ *graphQLResolver }
+	graphQLMutationResolver struct{ *graphQLResolver }
+	graphQLPlugResolver     struct{ *graphQLResolver }
+)
+
+func newGraphQLPlug(plug *plug) *model.Plug {
+	address, _ := plug.addresses()
+	stats := plug.stats()
+
+	return &model.Plug{
+		IP:              address,
+		Name:            plug.Name,
+		Model:           plug.Model,
+		Room:            plug.Room,
+		On:              plug.IsOn(),
+		Online:          plug.isOnline(),
+		TotalCommands:   int(stats.TotalCommands),
+		FailureCommands: int(stats.FailureCommands),
+		TotalToggles:    int(stats.TotalToggles),
+	}
+}
+
+func (r *graphQLQueryResolver) Plug(ctx context.Context, ip string) (*model.Plug, error) {
+	plug, exists := r.apictx.getPlug(ip)
+	if !exists {
+		return nil, nil
+	}
+
+	if !r.apictx.plugAllowed(ctx, plug) {
+		return nil, errPlugForbidden
+	}
+
+	return newGraphQLPlug(plug), nil
+}
+
+func (r *graphQLQueryResolver) Plugs(ctx context.Context, state, room *string) ([]*model.Plug, error) {
+	var wantOn *bool
+	if state != nil {
+		switch *state {
+		case "on":
+			wantOn = ptr(true)
+		case "off":
+			wantOn = ptr(false)
+		default:
+			return nil, fmt.Errorf("state must be one of \"on\" or \"off\"; got %q", *state)
+		}
+	}
+
+	plugs := []*model.Plug{}
+	for _, plug := range r.apictx.allowedPlugs(ctx) {
+		if wantOn != nil && plug.IsOn() != *wantOn {
+			continue
+		}
+
+		if room != nil && plug.Room != *room {
+			continue
+		}
+
+		plugs = append(plugs, newGraphQLPlug(plug))
+	}
+
+	return plugs, nil
+}
+
+func (r *graphQLQueryResolver) Scenes(_ context.Context) ([]*model.Scene, error) {
+	scenes := []*model.Scene{}
+	for _, scene := range r.apictx.config.Scenes {
+		plugs := []*model.ScenePlug{}
+		for _, state := range scene.Plugs {
+			plugs = append(plugs, &model.ScenePlug{IP: state.Address, On: state.On})
+		}
+
+		scenes = append(scenes, &model.Scene{Name: scene.Name, Plugs: plugs})
+	}
+
+	return scenes, nil
+}
+
+func (r *graphQLPlugResolver) Rules(ctx context.Context, obj *model.Plug) ([]*model.Rule, error) {
+	plug, exists := r.apictx.getPlug(obj.IP)
+	if !exists {
+		return nil, errPlugNotFound
+	}
+
+	if !r.apictx.plugAllowed(ctx, plug) {
+		return nil, errPlugForbidden
+	}
+
+	deviceRules, err := plug.ListDeviceScheduleRules()
+	if err != nil {
+		return nil, err
+	}
+
+	rules := []*model.Rule{}
+	for _, deviceRule := range deviceRules {
+		weekdays := []int{}
+		for day, runs := range deviceRule.WDay {
+			if runs == 1 {
+				weekdays = append(weekdays, day)
+			}
+		}
+
+		rule := &model.Rule{
+			ID:          deviceRule.ID,
+			Name:        deviceRule.Name,
+			Enabled:     deviceRule.Enable == 1,
+			Weekdays:    weekdays,
+			StartMinute: deviceRule.StartMin,
+			StartOn:     deviceRule.StartAction == 1,
+		}
+		if deviceRule.EndOpt != -1 {
+			rule.EndMinute = ptr(deviceRule.EndMin)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+func (r *graphQLMutationResolver) TogglePlug(ctx context.Context, ip string) (*model.Plug, error) {
+	return r.changePlugState(ctx, ip, false, true)
+}
+
+func (r *graphQLMutationResolver) TurnOn(ctx context.Context, ip string) (*model.Plug, error) {
+	return r.changePlugState(ctx, ip, true, false)
+}
+
+func (r *graphQLMutationResolver) TurnOff(ctx context.Context, ip string) (*model.Plug, error) {
+	return r.changePlugState(ctx, ip, false, false)
+}
+
+func (r *graphQLMutationResolver) changePlugState(ctx context.Context, ip string, on, toggle bool) (*model.Plug,
+	error,
+) {
+	err := requireAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	plug, exists := r.apictx.getPlug(ip)
+	if !exists {
+		return nil, errPlugNotFound
+	}
+
+	if !r.apictx.plugAllowed(ctx, plug) {
+		return nil, errPlugForbidden
+	}
+
+	_, err = r.apictx.changePlugState(ctx, plug, on, toggle, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return newGraphQLPlug(plug), nil
+}
+
+// ApplyScene switches every plug in the named scene at once. Every plug is looked up and checked against the token
+// before any is switched so that a scene is never left half applied because of a typo or a missing permission.
+func (r *graphQLMutationResolver) ApplyScene(ctx context.Context, name string) ([]*model.Plug, error) {
+	err := requireAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var scene *config.Scene
+	for i := range r.apictx.config.Scenes {
+		if r.apictx.config.Scenes[i].Name == name {
+			scene = &r.apictx.config.Scenes[i]
+			break
+		}
+	}
+	if scene == nil {
+		return nil, fmt.Errorf("scene %q not found", name)
+	}
+
+	plugs := make([]*plug, len(scene.Plugs))
+	for i, state := range scene.Plugs {
+		plug, exists := r.apictx.getPlug(state.Address)
+		if !exists {
+			return nil, fmt.Errorf("scene %q: %w: %s", name, errPlugNotFound, state.Address)
+		}
+
+		if !r.apictx.plugAllowed(ctx, plug) {
+			return nil, fmt.Errorf("scene %q: %w: %s", name, errPlugForbidden, state.Address)
+		}
+
+		plugs[i] = plug
+	}
+
+	errs := make([]error, len(plugs))
+	var wg sync.WaitGroup
+	for i, plug := range plugs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if !r.apictx.acquirePlugCommandSlot(ctx) {
+				errs[i] = ctx.Err()
+				return
+			}
+			defer r.apictx.releasePlugCommandSlot()
+
+			_, errs[i] = r.apictx.changePlugState(ctx, plug, scene.Plugs[i].On, false, false)
+		}()
+	}
+	wg.Wait()
+
+	err = errors.Join(errs...)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*model.Plug{}
+	for _, plug := range plugs {
+		results = append(results, newGraphQLPlug(plug))
+	}
+
+	return results, nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"net"
+
+	"github.com/clintjedwards/innerhaven/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// grpcService implements the gRPC KasaService on top of the same plugs the REST API serves.
+type grpcService struct {
+	proto.UnimplementedKasaServiceServer
+	apictx *APIContext
+}
+
+// newGRPCServer creates a gRPC server with KasaService registered. Clients are held to the same ACL and rate limits
+// as with the REST API, and authenticate the same way, by sending "authorization: Bearer <token>" metadata.
+func (apictx *APIContext) newGRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(apictx.grpcUnaryGuardInterceptor, apictx.grpcUnaryAuthInterceptor),
+		grpc.ChainStreamInterceptor(apictx.grpcStreamGuardInterceptor, apictx.grpcStreamAuthInterceptor),
+	)
+
+	server := grpc.NewServer(opts...)
+	proto.RegisterKasaServiceServer(server, &grpcService{apictx: apictx})
+
+	return server
+}
+
+// grpcGuard does for gRPC calls what requesterIPMiddleware, aclMiddleware and rateLimitMiddleware do for HTTP
+// requests. It returns a context carrying the requester IP, or an error if the client is refused. gRPC clients are
+// not expected to come through a proxy, so the requester IP is always the peer address.
+func (apictx *APIContext) grpcGuard(ctx context.Context) (context.Context, error) {
+	ip := ""
+	if client, ok := peer.FromContext(ctx); ok {
+		ip = client.Addr.String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+	}
+
+	if !apictx.acl.Load().permits(net.ParseIP(ip)) {
+		return nil, status.Error(codes.PermissionDenied, "requests from this address are not allowed")
+	}
+
+	if ok, retryAfter := apictx.limiter.Load().allow(ip); !ok {
+		return nil, status.Errorf(codes.ResourceExhausted, "too many requests; retry after %s", retryAfter)
+	}
+
+	return context.WithValue(ctx, requesterIPContextKey{}, ip), nil
+}
+
+func (apictx *APIContext) grpcUnaryGuardInterceptor(ctx context.Context, req any, _ *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	ctx, err := apictx.grpcGuard(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+func (apictx *APIContext) grpcStreamGuardInterceptor(srv any, stream grpc.ServerStream, _ *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	ctx, err := apictx.grpcGuard(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	return handler(srv, &contextStream{ServerStream: stream, ctx: ctx})
+}
+
+// grpcAuthenticate returns a context carrying the caller's role and token, mirroring roleMiddleware.
+func (apictx *APIContext) grpcAuthenticate(ctx context.Context) (context.Context, error) {
+	if !apictx.authEnabled() {
+		return withAuth(ctx, RoleAdmin, ""), nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	authorization := md.Get("authorization")
+	if len(authorization) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "missing bearer token")
+	}
+
+	token, role, ok := apictx.authenticate(authorization[0])
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid bearer token")
+	}
+
+	return withAuth(ctx, role, token), nil
+}
+
+func (apictx *APIContext) grpcUnaryAuthInterceptor(ctx context.Context, req any, _ *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	ctx, err := apictx.grpcAuthenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+// contextStream overrides the stream's context with one derived from it by an interceptor.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+func (apictx *APIContext) grpcStreamAuthInterceptor(srv any, stream grpc.ServerStream, _ *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	ctx, err := apictx.grpcAuthenticate(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	return handler(srv, &contextStream{ServerStream: stream, ctx: ctx})
+}
+
+func (s *grpcService) ListPlugs(ctx context.Context, _ *proto.ListPlugsRequest) (*proto.ListPlugsResponse, error) {
+	resp := &proto.ListPlugsResponse{}
+
+	for _, plug := range s.apictx.allowedPlugs(ctx) {
+		address, _ := plug.addresses()
+		resp.Plugs = append(resp.Plugs, &proto.Plug{
+			Name:    plug.Name,
+			Address: address,
+			Model:   plug.Model,
+			On:      plug.IsOn(),
+			Online:  plug.isOnline(),
+		})
+	}
+
+	return resp, nil
+}
+
+func (s *grpcService) TogglePlug(ctx context.Context, request *proto.TogglePlugRequest) (*proto.TogglePlugResponse,
+	error,
+) {
+	err := requireAdmin(ctx)
+	if err != nil {
+		return nil, status.Error(codes.PermissionDenied, "this action requires the admin token")
+	}
+
+	plug, exists := s.apictx.getPlug(request.Ip)
+	if !exists {
+		return nil, status.Error(codes.NotFound, "plug not found")
+	}
+
+	if !s.apictx.plugAllowed(ctx, plug) {
+		return nil, status.Error(codes.PermissionDenied, errPlugForbidden.Error())
+	}
+
+	body, err := s.apictx.changePlugState(ctx, plug, false, true, false)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, "could not change plug state")
+	}
+
+	return &proto.TogglePlugResponse{On: body.On}, nil
+}
+
+func (s *grpcService) StreamState(_ *proto.StreamStateRequest, stream proto.KasaService_StreamStateServer) error {
+	_, events, unsubscribe := s.apictx.history.subscribe(0, false)
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event, ok := <-events:
+			// The channel is closed when we fall too far behind or the server is shutting down.
+			if !ok {
+				return status.Error(codes.Unavailable, "event stream closed; reconnect to continue")
+			}
+
+			if !s.apictx.plugAllowed(stream.Context(), event.plug) {
+				continue
+			}
+
+			err := stream.Send(&proto.PlugStateEvent{
+				Seq:      event.Seq,
+				Time:     event.Time.UnixMilli(),
+				PlugName: event.PlugName,
+				PlugIp:   event.PlugIP,
+				On:       event.State,
+				Source:   event.Source,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
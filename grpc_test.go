@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/clintjedwards/innerhaven/internal/config"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPCGuard(t *testing.T) {
+	conf := config.DefaultAPIConfig()
+	conf.RateLimit.PerClientRPS = 0.001
+	conf.RateLimit.PerClientBurst = 1
+
+	apictx, _ := newTestAPI(t, conf)
+	apictx.reloadMu.Lock()
+	apictx.setRateLimiter(conf.RateLimit)
+	apictx.reloadMu.Unlock()
+
+	acl, err := newCIDRACL(nil, []string{"203.0.113.0/24"})
+	if err != nil {
+		t.Fatalf("could not create ACL: %v", err)
+	}
+	apictx.acl.Store(acl)
+
+	tests := []struct {
+		client string
+		want   codes.Code
+	}{
+		{client: "198.51.100.1", want: codes.OK},
+		{client: "198.51.100.1", want: codes.ResourceExhausted},
+		{client: "198.51.100.2", want: codes.OK},
+		{client: "203.0.113.1", want: codes.PermissionDenied},
+	}
+
+	for _, tc := range tests {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{
+			Addr: &net.TCPAddr{IP: net.ParseIP(tc.client), Port: 50000},
+		})
+
+		ctx, err := apictx.grpcGuard(ctx)
+		if status.Code(err) != tc.want {
+			t.Errorf("call from %s: code = %v, want %v", tc.client, status.Code(err), tc.want)
+			continue
+		}
+		if err == nil && requesterIPFromContext(ctx) != tc.client {
+			t.Errorf("call from %s: requester IP = %q", tc.client, requesterIPFromContext(ctx))
+		}
+	}
+}
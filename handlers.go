@@ -2,21 +2,40 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"net"
 	"net/http"
+	"runtime"
 	"strings"
+	"time"
 
 	"github.com/danielgtaylor/huma/v2"
 )
 
 var appVersion = "0.0.dev_000000"
 
-func parseVersion(versionString string) (version, commit string) {
-	version, commit, err := strings.Cut(versionString, "_")
-	if !err {
-		return "", ""
+// buildTime is set at link time with -ldflags "-X main.buildTime=$(date -u +%FT%TZ)".
+var buildTime string
+
+// Version is the build version of the binary, parsed from appVersion.
+type Version struct {
+	Semver string
+	Commit string
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("v%s+%s", v.Semver, v.Commit)
+}
+
+// parseVersion splits a version string of the form "<semver>_<commit>". It returns false if the string doesn't
+// contain exactly those two parts.
+func parseVersion(versionString string) (Version, bool) {
+	semver, commit, found := strings.Cut(versionString, "_")
+	if !found || semver == "" || commit == "" || strings.Contains(commit, "_") {
+		return Version{}, false
 	}
 
-	return
+	return Version{Semver: semver, Commit: commit}, true
 }
 
 func contains(s []string, e string) bool {
@@ -28,12 +47,34 @@ func contains(s []string, e string) bool {
 	return false
 }
 
+// containsCIDR reports whether ipStr falls within any of the given CIDRs. CIDRs that can't be parsed are skipped
+// and returned in a CIDRParseError alongside the result from the rest.
+func containsCIDR(cidrs []string, ipStr string) (bool, error) {
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return false, fmt.Errorf("%q is not a valid IP address", ipStr)
+	}
+
+	networks, err := parseCIDRs(cidrs)
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true, err
+		}
+	}
+
+	return false, err
+}
+
 type (
 	DescribeSystemInfoRequest  struct{}
 	DescribeSystemInfoResponse struct {
 		Body struct {
-			Commit string `json:"commit" example:"e83adcd" doc:"The commit of the current build"`
-			Semver string `json:"semver" example:"1.0.0" doc:"The semver version of the current build"`
+			Commit       string  `json:"commit" example:"e83adcd" doc:"The commit of the current build"`
+			Semver       string  `json:"semver" example:"1.0.0" doc:"The semver version of the current build"`
+			ManagedPlugs int     `json:"managed_plugs" example:"4" doc:"The amount of plugs managed by the service"`
+			OnlinePlugs  int     `json:"online_plugs" example:"3" doc:"The amount of managed plugs whose last command succeeded"`
+			UptimeSecs   float64 `json:"uptime_secs" example:"86400" doc:"How long the service has been running in seconds"`
+			GoVersion    string  `json:"go_version" example:"go1.22.2" doc:"The Go version the binary was built with"`
 		}
 	}
 )
@@ -47,12 +88,62 @@ func (apictx *APIContext) registerDescribeSystemInfo(apiDesc huma.API) {
 		Summary:     "Describe current system information",
 		Description: "Return a number of internal meta information about the Gofer server.",
 		Tags:        []string{"System"},
+		Security:    bearerAuth,
 		// Handler //
-	}, func(_ context.Context, _ *DescribeSystemInfoRequest) (*DescribeSystemInfoResponse, error) {
-		version, commit := parseVersion(appVersion)
+	}, func(ctx context.Context, _ *DescribeSystemInfoRequest) (*DescribeSystemInfoResponse, error) {
+		version, _ := parseVersion(appVersion)
 		resp := &DescribeSystemInfoResponse{}
-		resp.Body.Commit = commit
-		resp.Body.Semver = version
+		resp.Body.Commit = version.Commit
+		resp.Body.Semver = version.Semver
+
+		plugs := apictx.allowedPlugs(ctx)
+		resp.Body.ManagedPlugs = len(plugs)
+		for _, plug := range plugs {
+			if plug.isOnline() {
+				resp.Body.OnlinePlugs++
+			}
+		}
+		resp.Body.UptimeSecs = time.Since(apictx.startedAt).Seconds()
+		resp.Body.GoVersion = runtime.Version()
+
+		return resp, nil
+	})
+}
+
+type (
+	DescribeVersionRequest  struct{}
+	DescribeVersionResponse struct {
+		Body struct {
+			Semver    string `json:"semver" example:"1.0.0" doc:"The semver version of the current build"`
+			Commit    string `json:"commit" example:"e83adcd" doc:"The commit of the current build"`
+			BuildTime string `json:"build_time" example:"2024-04-06T20:03:22Z" doc:"When the binary was built; empty if not set at build time"`
+			GoVersion string `json:"go_version" example:"go1.22.2" doc:"The Go version the binary was built with"`
+			GOOS      string `json:"goos" example:"linux" doc:"The operating system the binary was built for"`
+			GOARCH    string `json:"goarch" example:"arm64" doc:"The architecture the binary was built for"`
+		}
+	}
+)
+
+func (apictx *APIContext) registerDescribeVersion(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "DescribeVersion",
+		Method:      http.MethodGet,
+		Path:        "/api/version",
+		Summary:     "Describe the build version",
+		Description: "Return build metadata for the running binary.",
+		Tags:        []string{"System"},
+		Security:    bearerAuth,
+		// Handler //
+	}, func(_ context.Context, _ *DescribeVersionRequest) (*DescribeVersionResponse, error) {
+		version, _ := parseVersion(appVersion)
+		resp := &DescribeVersionResponse{}
+		resp.Body.Semver = version.Semver
+		resp.Body.Commit = version.Commit
+		resp.Body.BuildTime = buildTime
+		resp.Body.GoVersion = runtime.Version()
+		resp.Body.GOOS = runtime.GOOS
+		resp.Body.GOARCH = runtime.GOARCH
 
 		return resp, nil
 	})
@@ -61,7 +152,14 @@ func (apictx *APIContext) registerDescribeSystemInfo(apiDesc huma.API) {
 type (
 	DescribeSystemSummaryRequest  struct{}
 	DescribeSystemSummaryResponse struct {
-		Body struct{}
+		Body struct {
+			PlugCount                int    `json:"plug_count" example:"5" doc:"Amount of plugs managed by the service"`
+			OnlineCount              int    `json:"online_count" example:"4" doc:"Amount of plugs whose last command succeeded"`
+			OfflineCount             int    `json:"offline_count" example:"1" doc:"Amount of plugs whose last command failed or have not been contacted yet"`
+			TotalToggleEventsToday   uint64 `json:"total_toggle_events_today" example:"12" doc:"Toggles across all plugs since local midnight"`
+			TotalToggleEventsAllTime uint64 `json:"total_toggle_events_all_time" example:"340" doc:"Toggles across all plugs since the service started"`
+			MostToggledPlugName      string `json:"most_toggled_plug_name" example:"Office Lamp" doc:"Name of the plug that has been sent the most commands"`
+		}
 	}
 )
 
@@ -71,13 +169,98 @@ func (apictx *APIContext) registerDescribeSystemSummary(apiDesc huma.API) {
 		OperationID: "DescribeSystemSummary",
 		Method:      http.MethodGet,
 		Path:        "/api/system/summary",
-		Summary:     "Describe various aspects about Gofer's current workloads",
-		Description: "A general endpoint to retrieve various metrics about the Gofer service.",
+		Summary:     "Describe a summary of all managed plugs",
+		Description: "Return plug availability and toggle counts across all managed plugs.",
 		Tags:        []string{"System"},
+		Security:    bearerAuth,
 		// Handler //
-	}, func(_ context.Context, _ *DescribeSystemSummaryRequest) (*DescribeSystemSummaryResponse, error) {
+	}, func(ctx context.Context, _ *DescribeSystemSummaryRequest) (*DescribeSystemSummaryResponse, error) {
 		resp := &DescribeSystemSummaryResponse{}
 
+		var mostCommands uint64
+
+		plugs := apictx.allowedPlugs(ctx)
+		resp.Body.PlugCount = len(plugs)
+
+		for _, plug := range plugs {
+			stats := plug.stats()
+
+			resp.Body.TotalToggleEventsAllTime += stats.TotalToggles
+			resp.Body.TotalToggleEventsToday += plug.toggleCountToday()
+
+			if stats.TotalCommands > mostCommands {
+				mostCommands = stats.TotalCommands
+				resp.Body.MostToggledPlugName = plug.Name
+			}
+
+			if plug.isOnline() {
+				resp.Body.OnlineCount++
+			} else {
+				resp.Body.OfflineCount++
+			}
+		}
+
+		return resp, nil
+	})
+}
+
+type (
+	DescribeStatsRequest  struct{}
+	DescribeStatsResponse struct {
+		Body struct {
+			TotalCommands   uint64  `json:"total_commands" example:"1200" doc:"Total amount of commands sent across all plugs"`
+			FailureCommands uint64  `json:"failure_commands" example:"36" doc:"Total amount of failed commands across all plugs"`
+			SuccessRate     float64 `json:"success_rate" example:"0.97" doc:"Ratio of commands that completed successfully across all plugs"`
+			MostToggledPlug string  `json:"most_toggled_plug" example:"Office Lamp" doc:"Name of the plug that has been toggled the most"`
+			OnlineCount     int     `json:"online_count" example:"4" doc:"Amount of plugs whose last command succeeded"`
+			OfflineCount    int     `json:"offline_count" example:"1" doc:"Amount of plugs whose last command failed or have not been contacted yet"`
+			UptimeSecs      float64 `json:"uptime_secs" example:"86400" doc:"Seconds since the service started"`
+		}
+	}
+)
+
+func (apictx *APIContext) registerDescribeStats(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "DescribeStats",
+		Method:      http.MethodGet,
+		Path:        "/api/stats",
+		Summary:     "Describe aggregate statistics for all plugs",
+		Description: "Return command and availability statistics summed across all managed plugs. All data is served " +
+			"from memory so this endpoint is suitable for frequent polling by dashboards and status pages.",
+		Tags:     []string{"System"},
+		Security: bearerAuth,
+		// Handler //
+	}, func(ctx context.Context, _ *DescribeStatsRequest) (*DescribeStatsResponse, error) {
+		resp := &DescribeStatsResponse{}
+
+		var success uint64
+		var mostToggles uint64
+
+		for _, plug := range apictx.allowedPlugs(ctx) {
+			stats := plug.stats()
+
+			resp.Body.TotalCommands += stats.TotalCommands
+			resp.Body.FailureCommands += stats.FailureCommands
+			success += stats.SuccessCommands
+
+			if stats.TotalToggles > mostToggles {
+				mostToggles = stats.TotalToggles
+				resp.Body.MostToggledPlug = plug.Name
+			}
+
+			if plug.isOnline() {
+				resp.Body.OnlineCount++
+			} else {
+				resp.Body.OfflineCount++
+			}
+		}
+
+		if resp.Body.TotalCommands > 0 {
+			resp.Body.SuccessRate = float64(success) / float64(resp.Body.TotalCommands)
+		}
+		resp.Body.UptimeSecs = time.Since(apictx.startedAt).Seconds()
+
 		return resp, nil
 	})
 }
@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		want    Version
+		wantOK  bool
+	}{
+		{name: "well formed", version: "1.2.3_abc1234", want: Version{Semver: "1.2.3", Commit: "abc1234"}, wantOK: true},
+		{name: "missing underscore", version: "1.2.3"},
+		{name: "empty", version: ""},
+		{name: "double underscore", version: "1.2.3__abc1234"},
+		{name: "missing semver", version: "_abc1234"},
+		{name: "missing commit", version: "1.2.3_"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := parseVersion(tc.version)
+			if got != tc.want || ok != tc.wantOK {
+				t.Errorf("parseVersion(%q) = %+v, %v; want %+v, %v", tc.version, got, ok, tc.want, tc.wantOK)
+			}
+		})
+	}
+}
+
+func TestVersionString(t *testing.T) {
+	version := Version{Semver: "1.2.3", Commit: "abc1234"}
+	if got := version.String(); got != "v1.2.3+abc1234" {
+		t.Errorf("String() = %q, want v1.2.3+abc1234", got)
+	}
+}
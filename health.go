@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+type (
+	DescribeLivenessRequest  struct{}
+	DescribeLivenessResponse struct {
+		Body struct {
+			Status string `json:"status" example:"alive" doc:"Always alive; a response at all means the process is running"`
+		}
+	}
+)
+
+func (apictx *APIContext) registerDescribeLiveness(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "DescribeLiveness",
+		Method:      http.MethodGet,
+		Path:        "/api/health/live",
+		Summary:     "Check that the service is running",
+		Description: "Always returns 200 while the process is running. Plugs are not checked, so a service whose plugs " +
+			"are all offline is still alive. Use this for liveness probes. No token is required.",
+		Tags: []string{"System"},
+		// Handler //
+	}, func(_ context.Context, _ *DescribeLivenessRequest) (*DescribeLivenessResponse, error) {
+		resp := &DescribeLivenessResponse{}
+		resp.Body.Status = "alive"
+
+		return resp, nil
+	})
+}
+
+type (
+	DescribeReadinessRequest  struct{}
+	DescribeReadinessResponse struct {
+		Status int
+		Body   struct {
+			Status string `json:"status" example:"ready" enum:"ready,not_ready" doc:"Whether the service can reach any of its plugs"`
+		}
+	}
+)
+
+const (
+	// readinessProbeTimeout bounds probing the plugs for a readiness check.
+	readinessProbeTimeout = 2 * time.Second
+
+	// readinessProbeReuse is how long the result of a probe answers later readiness checks.
+	readinessProbeReuse = 10 * time.Second
+)
+
+// readinessProbe is a probe of every plug made on behalf of readiness checks. done is closed once the probe has
+// finished and its result is set.
+type readinessProbe struct {
+	done       chan struct{}
+	reachable  bool
+	finishedAt time.Time
+}
+
+// anyPlugReachable reports whether at least one plug answered a command within the readiness window. If none did,
+// the plugs are probed so that a service that simply hasn't had any traffic isn't reported as not ready.
+func (apictx *APIContext) anyPlugReachable(ctx context.Context) bool {
+	plugs := apictx.listPlugs()
+
+	for _, plug := range plugs {
+		if plug.respondedWithin(apictx.config.ReadinessPlugProbeTimeout) {
+			return true
+		}
+	}
+
+	probe := apictx.sharedReadinessProbe(plugs)
+	select {
+	case <-probe.done:
+		return probe.reachable
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// sharedReadinessProbe returns the probe that is running or that finished within readinessProbeReuse, starting a
+// new one if there is neither. Readiness checks need no token, so every check probing the plugs itself would let
+// anyone flood them with commands.
+func (apictx *APIContext) sharedReadinessProbe(plugs []*plug) *readinessProbe {
+	apictx.readinessMu.Lock()
+	defer apictx.readinessMu.Unlock()
+
+	if probe := apictx.readinessProbe; probe != nil {
+		select {
+		case <-probe.done:
+			if time.Since(probe.finishedAt) < readinessProbeReuse {
+				return probe
+			}
+		default:
+			return probe
+		}
+	}
+
+	probe := &readinessProbe{done: make(chan struct{})}
+	apictx.readinessProbe = probe
+
+	go func() {
+		ctx, cancel := context.WithTimeout(apictx.tasks.ctx, readinessProbeTimeout)
+		defer cancel()
+
+		probe.reachable = apictx.probePlugs(ctx, plugs)
+		probe.finishedAt = time.Now()
+		close(probe.done)
+	}()
+
+	return probe
+}
+
+// probePlugs asks every plug for its system info and reports whether any of them answered.
+func (apictx *APIContext) probePlugs(ctx context.Context, plugs []*plug) bool {
+	var found atomic.Bool
+	var wg sync.WaitGroup
+	for _, plug := range plugs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if !apictx.acquirePlugCommandSlot(ctx) {
+				return
+			}
+			defer apictx.releasePlugCommandSlot()
+
+			if _, err := plug.systemInfoAt(ctx, PriorityLow); err == nil {
+				found.Store(true)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return found.Load()
+}
+
+func (apictx *APIContext) registerDescribeReadiness(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "DescribeReadiness",
+		Method:      http.MethodGet,
+		Path:        "/api/health/ready",
+		Summary:     "Check that the service can reach its plugs",
+		Description: "Returns 200 when at least one plug has answered a command within readiness_plug_probe_timeout " +
+			"and 503 otherwise. If no plug has been used recently the plugs are probed first for up to two seconds, and " +
+			"the result of that probe is reused for ten seconds. Use this for readiness probes. No token is required.",
+		Tags: []string{"System"},
+		// Handler //
+	}, func(ctx context.Context, _ *DescribeReadinessRequest) (*DescribeReadinessResponse, error) {
+		resp := &DescribeReadinessResponse{}
+		resp.Status = http.StatusOK
+		resp.Body.Status = "ready"
+
+		if !apictx.anyPlugReachable(ctx) {
+			resp.Status = http.StatusServiceUnavailable
+			resp.Body.Status = "not_ready"
+		}
+
+		return resp, nil
+	})
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestReadinessProbeShared(t *testing.T) {
+	fake := newFakePlug(t)
+	p := fake.plug("127.0.0.1")
+	// With nothing listening the probe fails, so it isn't skipped on the strength of a recent answer.
+	fake.listener.Close()
+
+	apictx, _ := newTestAPI(t, nil, p)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if apictx.anyPlugReachable(context.Background()) {
+				t.Errorf("plug reported reachable with nothing listening")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if apictx.anyPlugReachable(context.Background()) {
+		t.Errorf("plug reported reachable with nothing listening")
+	}
+
+	if commands := atomic.LoadUint64(&p.TotalCommands); commands != 1 {
+		t.Errorf("plug was sent %d commands for six readiness checks, want 1", commands)
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// toggleHistorySize is the amount of state changes kept in memory.
+const toggleHistorySize = 1000
+
+// PostToggleHook is called with every successful plug state change once it has been recorded. Hooks run on the
+// goroutine that changed the plug, so anything slow should hand off to its own goroutine.
+type PostToggleHook func(event ToggleEvent)
+
+// ToggleEvent is a successful change to a plug's state.
+type ToggleEvent struct {
+	// Seq increases by one with every event so that stream clients can tell which events they've missed.
+	Seq      uint64
+	Time     time.Time
+	PlugName string
+	PlugIP   string
+	State    bool
+	Source   string
+
+	// plug is the plug that changed, used to check which tokens may see the event.
+	plug *plug
+}
+
+// plugStateConfirmDelay is how long after a state change the plug is asked for its state to confirm the change
+// took.
+const plugStateConfirmDelay = 2 * time.Second
+
+// toggleSubscriberBuffer is how many events a subscriber can fall behind before it is disconnected.
+const toggleSubscriberBuffer = 64
+
+// toggleHistory is a fixed size ring buffer of the most recent toggle events. Once full the oldest event is
+// overwritten. Subscribers are sent every event as it is added.
+type toggleHistory struct {
+	mu     sync.Mutex
+	events [toggleHistorySize]ToggleEvent
+	next   int
+	count  int
+
+	// The sequence number given to the last event added.
+	seq uint64
+
+	subscribers map[chan ToggleEvent]struct{}
+}
+
+// add assigns the event the next sequence number, stores it and sends it to all subscribers. Subscribers that have
+// fallen too far behind are disconnected by closing their channel; they can catch up by subscribing again from
+// the last sequence number they saw.
+func (h *toggleHistory) add(event ToggleEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	event.Seq = h.seq
+
+	h.events[h.next] = event
+	h.next = (h.next + 1) % toggleHistorySize
+	if h.count < toggleHistorySize {
+		h.count++
+	}
+
+	for subscriber := range h.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			delete(h.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+}
+
+// subscribe returns a channel that receives every event added from now on. If resume is set, the buffered events
+// with a sequence number after the given one are returned so the subscriber can replay what it missed. The
+// returned function must be called once the subscriber is done.
+func (h *toggleHistory) subscribe(after uint64, resume bool) ([]ToggleEvent, <-chan ToggleEvent, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var replay []ToggleEvent
+	if resume {
+		start := (h.next - h.count + toggleHistorySize) % toggleHistorySize
+		for i := 0; i < h.count; i++ {
+			event := h.events[(start+i)%toggleHistorySize]
+			if event.Seq > after {
+				replay = append(replay, event)
+			}
+		}
+	}
+
+	if h.subscribers == nil {
+		h.subscribers = map[chan ToggleEvent]struct{}{}
+	}
+
+	subscriber := make(chan ToggleEvent, toggleSubscriberBuffer)
+	h.subscribers[subscriber] = struct{}{}
+
+	return replay, subscriber, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		if _, exists := h.subscribers[subscriber]; exists {
+			delete(h.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+}
+
+// closeSubscribers disconnects all subscribers.
+func (h *toggleHistory) closeSubscribers() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for subscriber := range h.subscribers {
+		delete(h.subscribers, subscriber)
+		close(subscriber)
+	}
+}
+
+// list returns the events in the buffer from oldest to newest.
+func (h *toggleHistory) list() []ToggleEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	events := make([]ToggleEvent, 0, h.count)
+	start := (h.next - h.count + toggleHistorySize) % toggleHistorySize
+	for i := 0; i < h.count; i++ {
+		events = append(events, h.events[(start+i)%toggleHistorySize])
+	}
+
+	return events
+}
+
+// recordStateChange writes a plug state change to the audit log and, if it succeeded, the toggle history. The new
+// state is reported straight away and confirmed with the plug in the background shortly after.
+func (apictx *APIContext) recordStateChange(requesterIP string, plug *plug, action, source string, success bool) {
+	apictx.audit.Load().LogToggle(requesterIP, plug, action, source, success)
+
+	if !success {
+		return
+	}
+
+	now := time.Now()
+
+	apictx.lastModifiedMu.Lock()
+	apictx.lastModified = now
+	apictx.lastModifiedMu.Unlock()
+
+	plugIP, _ := plug.addresses()
+	event := ToggleEvent{
+		Time:     now,
+		PlugName: plug.Name,
+		PlugIP:   plugIP,
+		State:    plug.IsOn(),
+		Source:   source,
+		plug:     plug,
+	}
+	apictx.history.add(event)
+
+	for _, hook := range apictx.postToggleHooks {
+		hook(event)
+	}
+
+	if source != AuditSourceCorrection {
+		apictx.scheduleStateConfirmation(plug)
+	}
+}
+
+// scheduleStateConfirmation confirms the plug's state after plugStateConfirmDelay. A plug only ever has one
+// confirmation pending; it checks the state left by every change made before it runs.
+func (apictx *APIContext) scheduleStateConfirmation(plug *plug) {
+	apictx.confirmationsMu.Lock()
+	defer apictx.confirmationsMu.Unlock()
+
+	if apictx.confirmations == nil {
+		return
+	}
+	if _, pending := apictx.confirmations[plug]; pending {
+		return
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(plugStateConfirmDelay, func() {
+		apictx.confirmationsMu.Lock()
+		if apictx.confirmations[plug] != timer {
+			apictx.confirmationsMu.Unlock()
+			return
+		}
+		delete(apictx.confirmations, plug)
+		apictx.confirmationsMu.Unlock()
+
+		apictx.confirmPlugState(plug)
+	})
+	apictx.confirmations[plug] = timer
+}
+
+// stopStateConfirmations cancels every pending state confirmation and stops new ones from being scheduled.
+func (apictx *APIContext) stopStateConfirmations() {
+	apictx.confirmationsMu.Lock()
+	defer apictx.confirmationsMu.Unlock()
+
+	for _, timer := range apictx.confirmations {
+		timer.Stop()
+	}
+	apictx.confirmations = nil
+}
+
+// confirmPlugState checks that the plug is really in the state we think it is. If it isn't, our state is
+// corrected and the correction recorded like any other state change so that subscribers find out. The check counts
+// against max_concurrent_plug_commands like the commands of fan-out operations do.
+func (apictx *APIContext) confirmPlugState(plug *plug) {
+	if apictx.tasks.ctx.Err() != nil || !apictx.acquirePlugCommandSlot(apictx.tasks.ctx) {
+		return
+	}
+	defer apictx.releasePlugCommandSlot()
+
+	corrected, err := plug.confirmState(apictx.tasks.ctx)
+	if err != nil {
+		log.Debug().Err(err).Str("plug", plug.Name).Msg("could not confirm plug state")
+		return
+	}
+	if !corrected {
+		return
+	}
+
+	log.Warn().Str("plug", plug.Name).Str("state", stateName(plug.IsOn())).
+		Msg("plug is not in the state it was last set to; corrected to the state it reported")
+	apictx.recordStateChange("", plug, stateName(plug.IsOn()), AuditSourceCorrection, true)
+}
+
+type PlugHistoryEvent struct {
+	Time     int64  `json:"time" example:"1712433802634" doc:"Time of the state change in epoch milliseconds"`
+	PlugName string `json:"plug_name" example:"Office Lamp" doc:"The name of the plug"`
+	PlugIP   string `json:"plug_ip" example:"192.168.1.20" doc:"The address of the plug"`
+	On       bool   `json:"on" example:"true" doc:"The state the plug was left in"`
+	Source   string `json:"source" example:"api" enum:"api,keyboard,rule,webhook,correction,retry" doc:"What caused the state change"`
+}
+
+type (
+	ListPlugHistoryRequest  struct{}
+	ListPlugHistoryResponse struct {
+		Body struct {
+			Events []PlugHistoryEvent `json:"events" doc:"The most recent plug state changes, oldest first"`
+		}
+	}
+)
+
+func (apictx *APIContext) registerListPlugHistory(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "ListPlugHistory",
+		Method:      http.MethodGet,
+		Path:        "/api/plugs/history",
+		Summary:     "List recent plug state changes",
+		Description: "Return the last 1000 plug state changes across all plugs, oldest first.",
+		Tags:        []string{"Plugs"},
+		Security:    bearerAuth,
+		// Handler //
+	}, func(ctx context.Context, _ *ListPlugHistoryRequest) (*ListPlugHistoryResponse, error) {
+		resp := &ListPlugHistoryResponse{}
+		resp.Body.Events = []PlugHistoryEvent{}
+
+		for _, event := range apictx.history.list() {
+			if !apictx.plugAllowed(ctx, event.plug) {
+				continue
+			}
+
+			resp.Body.Events = append(resp.Body.Events, PlugHistoryEvent{
+				Time:     event.Time.UnixMilli(),
+				PlugName: event.PlugName,
+				PlugIP:   event.PlugIP,
+				On:       event.State,
+				Source:   event.Source,
+			})
+		}
+
+		return resp, nil
+	})
+}
+
+type (
+	ExportPlugHistoryRequest  struct{}
+	ExportPlugHistoryResponse struct {
+		ContentType        string `header:"Content-Type"`
+		ContentDisposition string `header:"Content-Disposition"`
+		Body               []byte
+	}
+)
+
+func (apictx *APIContext) registerExportPlugHistory(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "ExportPlugHistory",
+		Method:      http.MethodGet,
+		Path:        "/api/plugs/history.csv",
+		Summary:     "Export recent plug state changes as CSV",
+		Description: "Download the last 1000 plug state changes across all plugs as a CSV file, oldest first.",
+		Tags:        []string{"Plugs"},
+		Security:    bearerAuth,
+		// Handler //
+	}, func(ctx context.Context, _ *ExportPlugHistoryRequest) (*ExportPlugHistoryResponse, error) {
+		var buf bytes.Buffer
+		writer := csv.NewWriter(&buf)
+
+		_ = writer.Write([]string{"time", "plug_name", "plug_ip", "on", "source"})
+		for _, event := range apictx.history.list() {
+			if !apictx.plugAllowed(ctx, event.plug) {
+				continue
+			}
+
+			_ = writer.Write([]string{
+				event.Time.UTC().Format(time.RFC3339),
+				event.PlugName,
+				event.PlugIP,
+				strconv.FormatBool(event.State),
+				event.Source,
+			})
+		}
+
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return nil, huma.Error500InternalServerError("Could not write history CSV", err)
+		}
+
+		return &ExportPlugHistoryResponse{
+			ContentType:        "text/csv",
+			ContentDisposition: "attachment; filename=history.csv",
+			Body:               buf.Bytes(),
+		}, nil
+	})
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestStateConfirmationsCoalesce(t *testing.T) {
+	p := newPlug("192.0.2.1", 0)
+	apictx, _ := newTestAPI(t, nil, p)
+
+	for i := 0; i < 3; i++ {
+		apictx.scheduleStateConfirmation(p)
+	}
+
+	apictx.confirmationsMu.Lock()
+	pending := len(apictx.confirmations)
+	apictx.confirmationsMu.Unlock()
+	if pending != 1 {
+		t.Fatalf("%d confirmations pending after three state changes, want 1", pending)
+	}
+
+	// Once stopped, nothing is left pending and nothing new is scheduled.
+	apictx.stopStateConfirmations()
+	apictx.scheduleStateConfirmation(p)
+
+	apictx.confirmationsMu.Lock()
+	pending = len(apictx.confirmations)
+	apictx.confirmationsMu.Unlock()
+	if pending != 0 {
+		t.Errorf("%d confirmations pending after stopping them, want 0", pending)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// influxTagEscaper escapes tag keys and values for InfluxDB line protocol.
+var influxTagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+type plugInfluxPoint struct {
+	name    string
+	address string
+	model   string
+	on      bool
+	onTime  time.Duration
+	rssi    int
+	hasRSSI bool
+}
+
+// writeInfluxLine writes a single kasa_plug point in InfluxDB line protocol. Tags with empty values are left out
+// since line protocol doesn't allow them.
+func writeInfluxLine(buf *bytes.Buffer, point plugInfluxPoint, timestamp time.Time) {
+	buf.WriteString("kasa_plug")
+	for _, tag := range []struct{ key, value string }{
+		{"plug_name", point.name},
+		{"ip", point.address},
+		{"model", point.model},
+	} {
+		if tag.value == "" {
+			continue
+		}
+		fmt.Fprintf(buf, ",%s=%s", tag.key, influxTagEscaper.Replace(tag.value))
+	}
+
+	state := 0
+	if point.on {
+		state = 1
+	}
+	fmt.Fprintf(buf, " state=%di,on_time=%di", state, int64(point.onTime.Seconds()))
+	if point.hasRSSI {
+		fmt.Fprintf(buf, ",rssi=%di", point.rssi)
+	}
+
+	fmt.Fprintf(buf, " %d\n", timestamp.UnixNano())
+}
+
+type (
+	ExportInfluxMetricsRequest struct {
+		Download bool `query:"download" example:"false" doc:"Send the metrics as a kasa_metrics.lp file attachment"`
+	}
+	ExportInfluxMetricsResponse struct {
+		ContentType        string `header:"Content-Type"`
+		ContentDisposition string `header:"Content-Disposition"`
+		Body               []byte
+	}
+)
+
+func (apictx *APIContext) registerExportInfluxMetrics(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "ExportInfluxMetrics",
+		Method:      http.MethodGet,
+		Path:        "/api/metrics/influx",
+		Summary:     "Export plug metrics in InfluxDB line protocol",
+		Description: "Return one kasa_plug point per plug with its state, total on time in seconds and signal " +
+			"strength, ready to import with `influx write`. Each plug is asked for its signal strength, which is " +
+			"left out for plugs that don't answer.",
+		Tags:     []string{"System"},
+		Security: bearerAuth,
+		// Handler //
+	}, func(ctx context.Context, request *ExportInfluxMetricsRequest) (*ExportInfluxMetricsResponse, error) {
+		plugs := apictx.allowedPlugs(ctx)
+		points := make([]plugInfluxPoint, len(plugs))
+
+		var wg sync.WaitGroup
+		for i, plug := range plugs {
+			address, _ := plug.addresses()
+			points[i] = plugInfluxPoint{
+				name:    plug.Name,
+				address: address,
+				model:   plug.Model,
+				on:      plug.IsOn(),
+				onTime:  plug.stats().TotalOnTime,
+			}
+
+			wg.Add(1)
+			go func(point *plugInfluxPoint) {
+				defer wg.Done()
+
+				if !apictx.acquirePlugCommandSlot(ctx) {
+					return
+				}
+				defer apictx.releasePlugCommandSlot()
+
+				info, err := plug.GetNetworkInfo()
+				if err != nil {
+					return
+				}
+				point.rssi = info.RSSI
+				point.hasRSSI = true
+			}(&points[i])
+		}
+		wg.Wait()
+
+		var buf bytes.Buffer
+		now := time.Now()
+		for _, point := range points {
+			writeInfluxLine(&buf, point, now)
+		}
+
+		resp := &ExportInfluxMetricsResponse{
+			ContentType: "text/plain; charset=utf-8",
+			Body:        buf.Bytes(),
+		}
+		if request.Download {
+			resp.ContentDisposition = "attachment; filename=kasa_metrics.lp"
+		}
+
+		return resp, nil
+	})
+}
@@ -1,6 +1,9 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"net"
 	"os"
 	"sort"
 	"strings"
@@ -17,12 +20,68 @@ import (
 type API struct {
 	Development *Development `koanf:"development"`
 	Server      *Server      `koanf:"server"`
+	Plugs       *Plugs       `koanf:"plugs"`
+	RateLimit   *RateLimit   `koanf:"rate_limit"`
+	Logging     *Logging     `koanf:"logging"`
+	Sentry      *Sentry      `koanf:"sentry"`
+	StatsD      *StatsD      `koanf:"statsd"`
+	StateExport *StateExport `koanf:"state_export"`
+	Response    *Response    `koanf:"response"`
+
+	// Bearer tokens used to authenticate API requests. The admin token can use every endpoint while the read
+	// token can only use endpoints that don't change anything. If both are empty authentication is disabled and
+	// every request is treated as an admin; a warning is logged at startup.
+	AdminToken string `koanf:"admin_token"`
+	ReadToken  string `koanf:"read_token"`
+
+	// Path of the file every plug state change is recorded to. The file is rotated daily. Leave empty to disable
+	// the audit log.
+	AuditLogPath string `koanf:"audit_log_path"`
+
+	// Path of a state file shared with other instances managing the same plugs. The plug state is saved to it on
+	// every change and read from it at startup. Leave empty to disable sharing.
+	SharedStatePath string `koanf:"shared_state_path"`
+
+	// The service reports itself ready when at least one plug has answered a command within this long. If none
+	// have, the readiness check probes the plugs itself.
+	ReadinessPlugProbeTimeout time.Duration `koanf:"readiness_plug_probe_timeout"`
+
+	// A request through the API that would switch a plug to the same state as an API command still being sent, or
+	// one that finished within this window, is answered with the plug's state instead of sending the command again.
+	// Set to 0 to send every command.
+	CommandDebounceWindow time.Duration `koanf:"command_debounce_window"`
+
+	// The most plug commands that operations acting on several plugs at once, like vacation mode, send at the same
+	// time. Keeps a large fan-out from flooding the home network.
+	MaxConcurrentPlugCommands int `koanf:"max_concurrent_plug_commands"`
+
+	// How long after a plug state change fails it is tried once more before being given up on.
+	DLQRetryInterval time.Duration `koanf:"dlq_retry_interval"`
+
+	// IPs or CIDRs of reverse proxies in front of the service. The client IP is only read from X-Forwarded-For
+	// when the request comes from one of these.
+	TrustedProxies []string `koanf:"trusted_proxies"`
+
+	// Named sets of plug states that can be applied all at once through the GraphQL applyScene mutation.
+	Scenes []Scene `koanf:"scenes"`
 }
 
 func DefaultAPIConfig() *API {
 	return &API{
 		Development: DefaultDevelopmentConfig(),
 		Server:      DefaultServerConfig(),
+		Plugs:       DefaultPlugsConfig(),
+		RateLimit:   DefaultRateLimitConfig(),
+		Logging:     DefaultLoggingConfig(),
+		Sentry:      &Sentry{},
+		StatsD:      &StatsD{},
+		StateExport: DefaultStateExportConfig(),
+		Response:    &Response{},
+
+		ReadinessPlugProbeTimeout: mustParseDuration("1m"),
+		CommandDebounceWindow:     mustParseDuration("200ms"),
+		MaxConcurrentPlugCommands: 5,
+		DLQRetryInterval:          mustParseDuration("30s"),
 	}
 }
 
@@ -35,8 +94,11 @@ type Development struct {
 	LoadFrontendFilesFromDisk bool `koanf:"load_frontend_files_from_disk"`
 
 	// The OpenAPI files located in the root and sdk folders for the project are generated by huma(https://huma.rocks).
-	// The root openapi.yaml file will autogenerate on application start if this is set to true.
+	// The spec files in openapi/v{semver}/ will autogenerate on application start if this is set to true.
 	GenerateOpenAPISpecFiles bool `koanf:"generate_open_api_spec_files"`
+
+	// Which spec files GenerateOpenAPISpecFiles writes: "yaml" for openapi.yaml, "json" for openapi.json or "both".
+	OpenAPISpecFormat string `koanf:"open_api_spec_format"`
 }
 
 func DefaultDevelopmentConfig() *Development {
@@ -45,6 +107,7 @@ func DefaultDevelopmentConfig() *Development {
 		UseLocalhostTLS:           false,
 		LoadFrontendFilesFromDisk: false,
 		GenerateOpenAPISpecFiles:  false,
+		OpenAPISpecFormat:         "yaml",
 	}
 }
 
@@ -54,6 +117,7 @@ func FullDevelopmentConfig() *Development {
 		UseLocalhostTLS:           true,
 		LoadFrontendFilesFromDisk: false,
 		GenerateOpenAPISpecFiles:  false,
+		OpenAPISpecFormat:         "yaml",
 	}
 }
 
@@ -78,23 +142,366 @@ type Server struct {
 	// How long the GRPC service should wait on in-progress connections before hard closing everything out.
 	ShutdownTimeout time.Duration `koanf:"shutdown_timeout"`
 
+	// How long shutdown waits for commands that are already being sent to plugs to finish before giving up on them.
+	CommandDrainTimeout time.Duration `koanf:"command_drain_timeout"`
+
+	// How long a handler may work on a request before its context is cancelled. Event streams and websockets are
+	// exempt. Keep it below WriteTimeout so that the handler gives up while there is still time to tell the client.
+	RequestTimeout time.Duration `koanf:"request_timeout"`
+
+	// Requests that take longer than this to serve are logged as a warning and counted in the
+	// kasa_sla_violations_total metric. Set to 0 to disable.
+	SLAThreshold time.Duration `koanf:"sla_threshold"`
+
 	TLSCertPath string `koanf:"tls_cert_path"`
 	TLSKeyPath  string `koanf:"tls_key_path"`
+
+	// When set, TLS certificates for this domain are obtained and renewed automatically from Let's Encrypt and the
+	// cert and key paths are ignored. The ACME HTTP-01 challenge is answered by the redirect listener, which must
+	// be enabled and reachable from the internet on port 80.
+	ACMEDomain string `koanf:"acme_domain"`
+
+	// The directory certificates obtained through ACME are cached in so they survive restarts.
+	ACMECacheDir string `koanf:"acme_cache_dir"`
+
+	// The bind address of a plain HTTP listener that redirects every request to HTTPS. It is disabled by default and
+	// is not started when using the development localhost certs. Ex: 0.0.0.0:80
+	RedirectListenAddress string `koanf:"redirect_listen_address"`
+
+	// The bind address of the gRPC service. It shares TLS settings and plugs with the HTTP API. It is disabled by
+	// default. Ex: 0.0.0.0:8081
+	GRPCListenAddress string `koanf:"grpc_listen_address"`
+
+	// Path to a PEM encoded CA certificate. When set, clients must present a certificate signed by this CA to
+	// connect (mutual TLS).
+	ClientCACertPath string `koanf:"client_ca_cert_path"`
+
+	// CIDRs of the clients allowed to use the service. Leave empty to allow every client that isn't blocked.
+	// Ex: ["192.168.1.0/24"]
+	AllowedCIDRs []string `koanf:"allowed_cidrs"`
+
+	// CIDRs of the clients refused service, even if they also fall in AllowedCIDRs.
+	BlockedCIDRs []string `koanf:"blocked_cidrs"`
+
+	// The largest request body in bytes the server will accept. Larger requests are rejected with a 413.
+	MaxBodyBytes int64 `koanf:"max_body_bytes"`
+
+	// The Content-Security-Policy header sent with every response. Leave empty to not send the header; note that
+	// the API docs page loads its scripts from a CDN so a strict policy will break it.
+	CSPHeader string `koanf:"csp_header"`
 }
 
 // DefaultServerConfig returns a pre-populated configuration struct that is used as the base for super imposing user configuration
 // settings.
 func DefaultServerConfig() *Server {
 	return &Server{
-		LogLevel:        "info",
-		ListenAddress:   "0.0.0.0:8080",
-		ReadTimeout:     10 * time.Second,
-		WriteTimeout:    10 * time.Second,
-		IdleTimeout:     15 * time.Second,
-		ShutdownTimeout: mustParseDuration("15s"),
+		LogLevel:            "info",
+		ListenAddress:       "0.0.0.0:8080",
+		ReadTimeout:         10 * time.Second,
+		WriteTimeout:        10 * time.Second,
+		IdleTimeout:         15 * time.Second,
+		ShutdownTimeout:     mustParseDuration("15s"),
+		CommandDrainTimeout: mustParseDuration("10s"),
+		RequestTimeout:      mustParseDuration("8s"),
+		SLAThreshold:        mustParseDuration("500ms"),
+		ACMECacheDir:        "/var/lib/innerhaven/acme",
+		MaxBodyBytes:        1 << 20, // 1MB
+	}
+}
+
+// Plugs represents settings for the smart plugs managed by the service.
+type Plugs struct {
+	// The plugs to manage. These are ignored if a plug mapping is passed on the command line.
+	Devices []Plug `koanf:"devices"`
+
+	// When a plug stops responding the service scans this CIDR (ex: 192.168.1.0/24) for a device with the same
+	// device ID in case the plug has been assigned a new address. Leave empty to disable rediscovery.
+	DiscoveryCIDR string `koanf:"discovery_cidr"`
+
+	// How often to rescan the discovery CIDR while a plug remains offline.
+	DiscoveryIntervalSecs int `koanf:"discovery_interval_secs"`
+
+	// How long to wait at startup for plugs to report their details. Plugs that haven't answered by then are
+	// marked offline and startup carries on without them.
+	StartupProbeTimeout time.Duration `koanf:"startup_probe_timeout"`
+}
+
+// DefaultPlugsConfig returns a pre-populated configuration struct that is used as the base for super imposing user
+// configuration settings.
+func DefaultPlugsConfig() *Plugs {
+	return &Plugs{
+		Devices:               []Plug{},
+		DiscoveryCIDR:         "",
+		DiscoveryIntervalSecs: 60,
+		StartupProbeTimeout:   mustParseDuration("10s"),
 	}
 }
 
+// Plug represents the settings for a single smart plug.
+type Plug struct {
+	// The IP address or hostname of the plug.
+	Address string `koanf:"address"`
+
+	// An optional alternate address to try when the plug can't be reached at its address, for example to cover
+	// a DHCP reservation changing. When the backup works it becomes the primary address until the next restart.
+	BackupAddress string `koanf:"backup_address"`
+
+	// The TCP port the plug accepts commands on. Defaults to 9999 when unset; useful when the plug sits behind
+	// a port forward.
+	Port int `koanf:"port"`
+
+	// The termbox key code that toggles this plug from the terminal.
+	TriggerKey int `koanf:"trigger_key"`
+
+	// How long to wait for a TCP connection to the plug to be established. Defaults to 3000 when unset.
+	DialTimeoutMS int `koanf:"dial_timeout_ms"`
+
+	// How long the plug has to receive a command and respond once connected. Defaults to 5000 when unset.
+	RWTimeoutMS int `koanf:"rw_timeout_ms"`
+
+	// How long a command may wait for earlier commands to the plug to finish before it is dropped as stale.
+	// Defaults to 5000 when unset.
+	CommandTTLMS int `koanf:"command_ttl_ms"`
+
+	// The API tokens allowed to use this plug's endpoints. Leave empty to allow any valid token.
+	AllowedTokens []string `koanf:"allowed_tokens"`
+
+	// A free form note about the plug shown to API consumers. Ex: "controls the 3D printer (do not turn off
+	// during print)"
+	Description string `koanf:"description"`
+
+	// The room the plug is in. Plugs can be filtered by room through the GraphQL API. Ex: "living room"
+	Room string `koanf:"room"`
+}
+
+// Scene is a named set of plug states, ex: a "movie night" scene that turns the lamps off and the TV on.
+type Scene struct {
+	Name string `koanf:"name"`
+
+	// The plugs the scene switches and the state each is switched to. Plugs not listed are left alone.
+	Plugs []ScenePlug `koanf:"plugs"`
+}
+
+// ScenePlug is the state a scene puts a single plug in.
+type ScenePlug struct {
+	// The address of the plug as given in the plug's config.
+	Address string `koanf:"address"`
+	On      bool   `koanf:"on"`
+}
+
+// RateLimit represents settings for limiting how many requests the API will serve. Requests are checked against both
+// a global limit and a limit for the client's remote IP; going over either returns a 429.
+type RateLimit struct {
+	// The requests per second allowed across all clients. Set to 0 to disable the global limit.
+	GlobalRPS float64 `koanf:"global_rps"`
+
+	// The amount of requests allowed to arrive at once across all clients above the global rate.
+	GlobalBurst int `koanf:"global_burst"`
+
+	// The requests per second allowed for a single remote IP. Set to 0 to disable the per client limit.
+	PerClientRPS float64 `koanf:"per_client_rps"`
+
+	// The amount of requests a single remote IP is allowed to send at once above the per client rate.
+	PerClientBurst int `koanf:"per_client_burst"`
+
+	// How long a client can go without making a request before its limiter is forgotten.
+	ClientIdleTimeout time.Duration `koanf:"client_idle_timeout"`
+}
+
+// DefaultRateLimitConfig returns a pre-populated configuration struct that is used as the base for super imposing
+// user configuration settings.
+func DefaultRateLimitConfig() *RateLimit {
+	return &RateLimit{
+		GlobalRPS:         50,
+		GlobalBurst:       100,
+		PerClientRPS:      10,
+		PerClientBurst:    20,
+		ClientIdleTimeout: mustParseDuration("10m"),
+	}
+}
+
+// Logging represents settings for the application's logs.
+type Logging struct {
+	// How log lines are written to stderr: "json" for one JSON object per line, as log aggregators expect, or
+	// "console" for human readable, colored output.
+	Format string `koanf:"format"`
+
+	// Where plug state changes are also sent as syslog messages: "local" for the local system logger (journald or
+	// rsyslog) or a host:port to send to over UDP. Leave empty to disable.
+	SyslogAddress string `koanf:"syslog_address"`
+}
+
+// DefaultLoggingConfig returns a pre-populated configuration struct that is used as the base for super imposing
+// user configuration settings. Logs default to JSON when KASA_ENV=production and to the console format otherwise.
+func DefaultLoggingConfig() *Logging {
+	format := "console"
+	if os.Getenv("KASA_ENV") == "production" {
+		format = "json"
+	}
+
+	return &Logging{
+		Format: format,
+	}
+}
+
+// Sentry represents settings for reporting errors to Sentry.
+type Sentry struct {
+	// The Sentry project DSN errors are reported to. Leave empty to disable error reporting.
+	DSN string `koanf:"dsn"`
+
+	// The environment errors are filed under in Sentry. Ex: production
+	Environment string `koanf:"environment"`
+}
+
+// StatsD represents settings for sending plug metrics to a StatsD server.
+type StatsD struct {
+	// The host:port of the StatsD server metrics are sent to over UDP. Leave empty to disable. Ex: 127.0.0.1:8125
+	Address string `koanf:"address"`
+
+	// Prepended to every metric name. Ex: "home." turns kasa.plug.toggle into home.kasa.plug.toggle
+	Prefix string `koanf:"prefix"`
+}
+
+// Response represents settings for how API responses are written.
+type Response struct {
+	// Indent JSON response bodies so they can be read without a formatter, ex: when calling the API with curl
+	// during development. A single request can ask for this with ?pretty=true instead.
+	PrettyPrint bool `koanf:"pretty_print"`
+}
+
+// StateExport represents settings for periodically saving a snapshot of plug state to disk.
+type StateExport struct {
+	// The directory snapshots are written to as state_<timestamp>.json. Leave empty to disable state export.
+	Dir string `koanf:"dir"`
+
+	// How often a snapshot is written.
+	Interval time.Duration `koanf:"interval"`
+
+	// How many snapshots to keep; the oldest are deleted once there are more than this.
+	KeepLast int `koanf:"keep_last"`
+}
+
+// DefaultStateExportConfig returns a pre-populated configuration struct that is used as the base for super
+// imposing user configuration settings.
+func DefaultStateExportConfig() *StateExport {
+	return &StateExport{
+		Interval: mustParseDuration("1h"),
+		KeepLast: 10,
+	}
+}
+
+// Validate checks the configuration for values the server can't start with. Every problem found is returned rather
+// than just the first so they can all be fixed in one go.
+func (c *API) Validate() error {
+	var errs []error
+
+	_, err := net.ResolveTCPAddr("tcp", c.Server.ListenAddress)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("server.listen_address %q is not a valid host:port; %w", c.Server.ListenAddress, err))
+	}
+
+	// Certificates don't come from disk when using the development certs or ACME.
+	usingDevCerts := c.Development.UseLocalhostTLS && c.Server.TLSCertPath == ""
+	if !usingDevCerts && c.Server.ACMEDomain == "" {
+		for _, file := range []struct{ name, path string }{
+			{"server.tls_cert_path", c.Server.TLSCertPath},
+			{"server.tls_key_path", c.Server.TLSKeyPath},
+		} {
+			name, path := file.name, file.path
+			if path == "" {
+				errs = append(errs, fmt.Errorf("%s must be set", name))
+				continue
+			}
+
+			_, err := os.Stat(path)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s %q could not be read; %w", name, path, err))
+			}
+		}
+	}
+
+	if c.Server.ACMEDomain != "" && c.Server.RedirectListenAddress == "" {
+		errs = append(errs, errors.New("server.redirect_listen_address must be set to answer the ACME challenge for "+
+			"server.acme_domain"))
+	}
+
+	for _, timeout := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"server.read_timeout", c.Server.ReadTimeout},
+		{"server.write_timeout", c.Server.WriteTimeout},
+		{"server.idle_timeout", c.Server.IdleTimeout},
+		{"server.request_timeout", c.Server.RequestTimeout},
+		{"server.command_drain_timeout", c.Server.CommandDrainTimeout},
+		{"readiness_plug_probe_timeout", c.ReadinessPlugProbeTimeout},
+		{"plugs.startup_probe_timeout", c.Plugs.StartupProbeTimeout},
+		{"dlq_retry_interval", c.DLQRetryInterval},
+	} {
+		if timeout.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive; got %s", timeout.name, timeout.value))
+		}
+	}
+
+	for _, cidr := range append(append([]string{}, c.Server.AllowedCIDRs...), c.Server.BlockedCIDRs...) {
+		_, _, err := net.ParseCIDR(cidr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("server CIDR %q is not valid; %w", cidr, err))
+		}
+	}
+
+	switch c.Logging.Format {
+	case "json", "console":
+	default:
+		errs = append(errs, fmt.Errorf("logging.format must be one of json or console; got %q", c.Logging.Format))
+	}
+
+	switch c.Development.OpenAPISpecFormat {
+	case "yaml", "json", "both":
+	default:
+		errs = append(errs, fmt.Errorf("development.open_api_spec_format must be one of yaml, json or both; got %q",
+			c.Development.OpenAPISpecFormat))
+	}
+
+	if c.CommandDebounceWindow < 0 {
+		errs = append(errs, fmt.Errorf("command_debounce_window must not be negative; got %s", c.CommandDebounceWindow))
+	}
+
+	if c.MaxConcurrentPlugCommands <= 0 {
+		errs = append(errs, fmt.Errorf("max_concurrent_plug_commands must be positive; got %d",
+			c.MaxConcurrentPlugCommands))
+	}
+
+	if c.Server.MaxBodyBytes <= 0 {
+		errs = append(errs, fmt.Errorf("server.max_body_bytes must be positive; got %d", c.Server.MaxBodyBytes))
+	}
+
+	sceneNames := map[string]bool{}
+	for _, scene := range c.Scenes {
+		if scene.Name == "" {
+			errs = append(errs, errors.New("scenes must each have a name"))
+			continue
+		}
+
+		if sceneNames[scene.Name] {
+			errs = append(errs, fmt.Errorf("scene %q is defined more than once", scene.Name))
+		}
+		sceneNames[scene.Name] = true
+	}
+
+	if c.StateExport.Dir != "" {
+		if c.StateExport.Interval <= 0 {
+			errs = append(errs, fmt.Errorf("state_export.interval must be positive; got %s", c.StateExport.Interval))
+		}
+
+		if c.StateExport.KeepLast <= 0 {
+			errs = append(errs, fmt.Errorf("state_export.keep_last must be positive; got %d", c.StateExport.KeepLast))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
 // Get the final configuration for the server.
 // This involves correctly finding and ordering different possible paths for the configuration file:
 //
@@ -120,16 +527,7 @@ func InitAPIConfig(userDefinedPath string, loadDefaults bool, devMode bool) (*AP
 		config.Development = FullDevelopmentConfig()
 	}
 
-	possibleConfigPaths := []string{userDefinedPath, "/etc/innerhaven/innerhaven.hcl"}
-
-	path := searchFilePaths(possibleConfigPaths...)
-
-	// envVars top all other entries so if its not empty we just insert it over the current path
-	// regardless of if we found one.
-	envPath := os.Getenv("INNERHAVEN_CONFIG_PATH")
-	if envPath != "" {
-		path = envPath
-	}
+	path := APIConfigPath(userDefinedPath)
 
 	configParser := koanf.New(".")
 
@@ -157,10 +555,33 @@ func InitAPIConfig(userDefinedPath string, loadDefaults bool, devMode bool) (*AP
 	return config, nil
 }
 
+// APIConfigPath returns the path of the config file InitAPIConfig would load, or an empty string if there isn't one.
+func APIConfigPath(userDefinedPath string) string {
+	possibleConfigPaths := []string{userDefinedPath, "/etc/innerhaven/innerhaven.hcl"}
+
+	path := searchFilePaths(possibleConfigPaths...)
+
+	// envVars top all other entries so if its not empty we just insert it over the current path
+	// regardless of if we found one.
+	envPath := os.Getenv("INNERHAVEN_CONFIG_PATH")
+	if envPath != "" {
+		path = envPath
+	}
+
+	return path
+}
+
 func GetAPIEnvVars() []string {
 	api := API{
 		Server:      &Server{},
 		Development: &Development{},
+		Plugs:       &Plugs{},
+		RateLimit:   &RateLimit{},
+		Logging:     &Logging{},
+		Sentry:      &Sentry{},
+		StatsD:      &StatsD{},
+		StateExport: &StateExport{},
+		Response:    &Response{},
 	}
 	fields := structs.Fields(api)
 
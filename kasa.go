@@ -0,0 +1,1291 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/clintjedwards/innerhaven/internal/config"
+	term "github.com/nsf/termbox-go"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// KasaTCPPort is the port Kasa devices listen for commands on.
+	KasaTCPPort = 9999
+
+	// DefaultDialTimeout is how long to wait for a TCP connection to a plug when the config does not specify one.
+	DefaultDialTimeout = 3 * time.Second
+
+	// DefaultReadWriteTimeout is how long to wait on a plug to accept a command and respond once connected
+	// when the config does not specify one.
+	DefaultReadWriteTimeout = 5 * time.Second
+
+	// DefaultCommandTTL is how long a command can wait behind others for the same plug before it is dropped as stale
+	// when the config does not specify one.
+	DefaultCommandTTL = 5 * time.Second
+)
+
+// ErrCommandExpired is returned for a command that waited longer than the plug's CommandTTL for its turn and so was
+// never sent.
+var ErrCommandExpired = errors.New("command expired waiting for earlier commands to the plug")
+
+// ErrShuttingDown is returned for a command that was never sent because the API service is shutting down.
+var ErrShuttingDown = errors.New("command not sent; shutting down")
+
+// plug is the representation of the keybinding and plug pairing
+type plug struct {
+	// The counters below are updated with sync/atomic and must stay at the top of the struct so
+	// they remain 64-bit aligned on 32-bit platforms.
+	TotalCommands   uint64
+	SuccessCommands uint64
+	FailureCommands uint64
+	TotalToggles    uint64
+	TotalOnTime     time.Duration
+
+	// DryRunCount is the amount of commands that were requested with dry run set and so were never sent.
+	DryRunCount uint64
+
+	// lastSuccess is the time (in unix nanoseconds) the plug last answered a command, or zero if it never has.
+	// Accessed atomically.
+	lastSuccess int64
+
+	// onSince is the time (in unix nanoseconds) the plug was last turned on or zero if the plug is off. It is
+	// accessed atomically and is used to account for TotalOnTime.
+	onSince int64
+
+	// stateGeneration goes up by one every time the recorded relay state is changed. It lets a delayed retry tell
+	// whether the plug has been changed since the command it is retrying failed. Accessed atomically.
+	stateGeneration uint64
+
+	// Address is the IP address or hostname of the plug. BackupAddress is an optional alternate address that is
+	// tried when the plug can't be reached at Address; if it works the two are swapped. Both are guarded by
+	// addrMtx since they can change at runtime.
+	addrMtx       *sync.RWMutex
+	Address       string
+	BackupAddress string
+	Port          int
+	TriggerKey    int
+
+	// DialTimeout bounds establishing the connection while ReadWriteTimeout bounds the command exchange that
+	// follows, so a slow connect does not eat into the time the plug has to respond.
+	DialTimeout      time.Duration
+	ReadWriteTimeout time.Duration
+
+	// CommandTTL is how long a command may wait for earlier commands to the plug to finish. Commands still waiting
+	// after that are dropped with ErrCommandExpired so that a backlog built up while the plug was unreachable
+	// doesn't play out late and out of order. Zero lets commands wait indefinitely.
+	CommandTTL time.Duration
+
+	// AllowedTokens limits which API tokens can use this plug's endpoints. Empty means any valid token can.
+	AllowedTokens []string
+
+	// Description is an operator supplied note about the plug from the config file and Room the room it is in.
+	// Both are read-only.
+	Description string
+	Room        string
+
+	// Model, Name, DeviceID, SoftwareVersion, MAC and SSID are populated once by getSystemInfo before the plug is
+	// shared with other goroutines and are read-only afterwards. DeviceType is detected from Model at the same time
+	// so that capability checks don't have to parse the model string on every request.
+	Model           string
+	DeviceType      DeviceType
+	Name            string
+	DeviceID        string
+	SoftwareVersion string
+	MAC             string
+	SSID            string
+
+	// ColorTempRange is the minimum and maximum color temperature in kelvin the bulb supports. It is zero for
+	// devices without adjustable color temperature. Populated by getSystemInfo alongside Model.
+	ColorTempRange [2]int
+
+	// When the plug goes offline we scan discoveryCIDR every discoveryInterval for a device with a matching
+	// DeviceID in case it has been given a new address. discovering is set to 1 while a search is running and
+	// is accessed atomically.
+	discoveryCIDR     string
+	discoveryInterval time.Duration
+	discovering       int32
+
+	// cmdLock serializes commands sent to the plug, letting higher priority commands go first, and guards lastCmd.
+	// A command sent less than cmdInterval after the previous one waits cmdInterval first so the plug can keep up.
+	cmdLock     *commandLock
+	lastCmd     time.Time
+	cmdInterval time.Duration
+
+	// The latest state change the API asked for: a sequence number telling it apart from the ones before it, the
+	// state it switches the plug to, whether it is still being sent and when it finished. They are used to drop
+	// rapid duplicate API requests and are guarded by apiCmdMtx.
+	apiCmdMtx        *sync.Mutex
+	apiCmdSeq        uint64
+	apiCmdTarget     bool
+	apiCmdInFlight   bool
+	apiCmdFinishedAt time.Time
+
+	// on is 1 while the plug is switched on and 0 otherwise. It is read atomically through IsOn so readers never
+	// wait on a command in flight, but is only written while holding stateMtx so state changes stay serialized.
+	on int32
+
+	// stateMtx serializes state changes and guards the bulb light state and the daily toggle count. When both
+	// locks are needed stateMtx must always be acquired before cmdLock (which sendCmd takes); never call into a
+	// method that takes stateMtx while holding cmdLock.
+	stateMtx   *sync.Mutex
+	Hue        int
+	Saturation int
+	ColorTemp  int
+
+	// togglesToday counts toggles made on toggleDay (formatted as YYYY-MM-DD in local time). It starts over on
+	// the first toggle of a new day.
+	togglesToday uint64
+	toggleDay    string
+
+	// online is set to 1 when the most recent command to the plug succeeded and 0 otherwise. Accessed atomically.
+	online int32
+
+	// deadLetters receives state changes that failed so they can be retried later. It is nil when nothing retries
+	// them, like in the terminal UI.
+	deadLetters chan<- FailedCommand
+
+	// inflight counts the plug's commands so that shutdown can wait for them. It is nil when nothing waits for them,
+	// like in the terminal UI.
+	inflight *commandTracker
+
+	// Running latency statistics for successful commands, maintained with Welford's online algorithm.
+	// Guarded by statsMtx rather than cmdLock so that readers don't wait on in-flight commands.
+	statsMtx    *sync.Mutex
+	latencyMean float64
+	latencyM2   float64
+
+	// latencyWindow holds the round trip times of the most recent successful commands, oldest overwritten first.
+	// latencyWindowNext is the slot the next sample is written to and latencySamples the amount of slots filled.
+	// All are guarded by statsMtx.
+	latencyWindow     [latencyWindowSize]time.Duration
+	latencyWindowNext int
+	latencySamples    int
+
+	// errorLog holds the most recent failed commands, oldest overwritten first. errorLogNext is the slot the
+	// next failure is written to. Both are guarded by statsMtx.
+	errorLog     [plugErrorLogSize]PlugErrorEntry
+	errorLogNext int
+}
+
+// latencyWindowSize is the amount of command latencies kept per plug for percentile reporting.
+const latencyWindowSize = 1000
+
+// plugErrorLogSize is the amount of failed commands remembered per plug.
+const plugErrorLogSize = 10
+
+// PlugErrorEntry describes a single command to a plug that failed.
+type PlugErrorEntry struct {
+	Timestamp time.Time
+	// Command is the JSON payload that was being sent.
+	Command string
+	Error   string
+	// Temporary is true if the failure looked transient, such as a timeout.
+	Temporary bool
+}
+
+// plugStats is a point in time copy of a plug's command statistics.
+type plugStats struct {
+	TotalCommands   uint64
+	SuccessCommands uint64
+	FailureCommands uint64
+	TotalToggles    uint64
+	TotalOnTime     time.Duration
+	DryRunCount     uint64
+	LatencyMean     time.Duration
+	LatencyStdDev   time.Duration
+}
+
+// all of the structs below are just to conform to the sysinfo json result
+type system struct {
+	command `json:"system"`
+}
+
+type command struct {
+	info `json:"get_sysinfo"`
+}
+
+type info struct {
+	Alias           string  `json:"alias,omitempty"`
+	SoftwareVersion string  `json:"sw_ver,omitempty"`
+	HardwareVersion string  `json:"hw_ver,omitempty"`
+	Model           string  `json:"model,omitempty"`
+	DeviceID        string  `json:"deviceId,omitempty"`
+	OemID           string  `json:"oemId,omitempty"`
+	HardwareID      string  `json:"hwId,omitempty"`
+	MAC             string  `json:"mac,omitempty"`
+	Rssi            float64 `json:"rssi,omitempty"`
+	Longitude       float64 `json:"longitude,omitempty"`
+	Latitude        float64 `json:"latitude,omitempty"`
+	Updating        int     `json:"updating,omitempty"`
+	LEDOff          int     `json:"led_off,omitempty"`
+	RelayState      int     `json:"relay_state,omitempty"`
+	OnTime          int     `json:"on_time,omitempty"`
+	ActiveMode      string  `json:"active_mode,omitempty"`
+	IconHash        string  `json:"icon_hash,omitempty"`
+	ErrorCode       int     `json:"err_code,omitempty"`
+
+	IsVariableColorTemp int `json:"is_variable_color_temp,omitempty"`
+}
+
+const usage = "Usage: kasa-internal [--version] [--log-level <level>] [--dry-run] [--serve [--watch-config]] " +
+	"[<ip>:<key>,<ip>:<key>]\n       kasa-internal --migrate-config <ip>:<key>,<ip>:<key> <config path>"
+
+// dryRunProbeTimeout bounds how long --dry-run waits on each plug so that a config full of offline plugs still
+// finishes quickly.
+const dryRunProbeTimeout = 2 * time.Second
+
+func main() {
+	logLevel := flag.String("log-level", "", "one of trace, debug, info, warn, error or fatal; overrides KASA_LOG_LEVEL")
+	dryRun := flag.Bool("dry-run", false, "validate the config, check that every plug is reachable and exit")
+	printVersion := flag.Bool("version", false, "print the version and exit")
+	serve := flag.Bool("serve", false, "serve the HTTP, gRPC and GraphQL APIs instead of the terminal UI")
+	flag.BoolVar(&watchConfigFile, "watch-config", false, "reload the config whenever the config file changes")
+	migrate := flag.Bool("migrate-config", false, "write the plugs in a <ip>:<key>,... mapping to a config file and exit")
+	flag.Usage = func() {
+		fmt.Println(usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	// Handled before anything else so that it works without a config file or plugs.
+	if *printVersion {
+		versionString := appVersion
+		if version, ok := parseVersion(appVersion); ok {
+			versionString = version.String()
+		}
+
+		fmt.Println("kasa-internal " + versionString)
+		os.Exit(0)
+	}
+
+	if *migrate {
+		if flag.NArg() != 2 {
+			fmt.Println(usage)
+			os.Exit(1)
+		}
+
+		err := migrateConfig(flag.Arg(0), flag.Arg(1))
+		if err != nil {
+			fmt.Printf("could not migrate config; %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if flag.NArg() > 1 {
+		fmt.Println(usage)
+		os.Exit(1)
+	}
+
+	// Only the API service reloads its config; the terminal UI has nothing that could pick up the changes.
+	if watchConfigFile && !*serve {
+		fmt.Println("--watch-config can only be used with --serve")
+		os.Exit(1)
+	}
+
+	conf, err := config.InitAPIConfig("", true, false)
+	if err != nil {
+		fmt.Printf("could not load config; %v\n", err)
+		os.Exit(1)
+	}
+
+	err = initLogging(*logLevel, conf)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// mapping should be in the form: <ip addr>:<key>,<ip addr>:<key>
+	// If no mapping is given we fall back to the plugs listed in the config file.
+	devices := conf.Plugs.Devices
+	if flag.NArg() == 1 {
+		devices, err = parseMapping(flag.Arg(0))
+		if err != nil {
+			fmt.Println(err)
+			fmt.Println(usage)
+			os.Exit(1)
+		}
+	}
+	plugs := processPlugConfig(devices)
+
+	if len(plugs) == 0 {
+		fmt.Println(usage)
+		os.Exit(1)
+	}
+
+	if *dryRun {
+		os.Exit(checkSetup(conf, plugs))
+	}
+
+	for _, plug := range plugs {
+		plug.discoveryCIDR = conf.Plugs.DiscoveryCIDR
+		plug.discoveryInterval = time.Duration(conf.Plugs.DiscoveryIntervalSecs) * time.Second
+	}
+
+	probeCtx, cancelProbe := context.WithTimeout(context.Background(), conf.Plugs.StartupProbeTimeout)
+	unresponsive := getSystemInfo(probeCtx, plugs...)
+	cancelProbe()
+	if len(unresponsive) > 0 {
+		log.Warn().Strs("plugs", unresponsive).Dur("timeout", conf.Plugs.StartupProbeTimeout).
+			Msg("some plugs did not respond at startup; continuing with them marked offline")
+	}
+	warnOutdatedFirmware(plugs...)
+
+	if conf.StateExport.Dir != "" {
+		persisted, err := loadLatestState(conf.StateExport.Dir)
+		if err != nil {
+			log.Warn().Err(err).Str("dir", conf.StateExport.Dir).Msg("could not load persisted plug state")
+		}
+		reconcilePersistedState(persisted, plugs...)
+	}
+
+	if conf.SharedStatePath != "" {
+		shared, err := LoadState(conf.SharedStatePath)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Warn().Err(err).Str("path", conf.SharedStatePath).Msg("could not load shared plug state")
+		}
+		reconcilePersistedState(shared, plugs...)
+	}
+
+	if *serve {
+		apictx, err := NewAPI(conf, plugs)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		err = apictx.StartAPIService()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	audit, err := NewAuditLogger(conf.AuditLogPath)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer audit.Close()
+
+	err = term.Init()
+	if err != nil {
+		panic(err)
+	}
+	defer term.Close()
+
+	term.SetInputMode(term.InputEsc | term.InputMouse)
+
+	redrawUI(plugs)
+
+	for {
+		fmt.Println("Listening for input")
+		event := term.PollEvent()
+
+		switch event.Type {
+		case term.EventResize:
+			// Sync forces a full repaint which clears anything left over from the old dimensions.
+			_ = term.Sync()
+			redrawUI(plugs)
+			continue
+		case term.EventMouse:
+			if event.Key != term.MouseLeft {
+				continue
+			}
+
+			clicked, exists := plugAtRow(plugs, event.MouseY)
+			if !exists {
+				continue
+			}
+
+			flashRow(event.MouseY, clicked)
+			toggleFromTerminal(plugs, []*plug{clicked}, audit)
+			continue
+		case term.EventKey:
+		default:
+			continue
+		}
+
+		if event.Key == term.KeyCtrlC {
+			return
+		}
+
+		// Several plugs can share a trigger key so that one key press switches the whole group.
+		group := []*plug{}
+		for _, plug := range plugs {
+			if term.Key(plug.TriggerKey) == event.Key {
+				group = append(group, plug)
+			}
+		}
+		if len(group) > 0 {
+			toggleFromTerminal(plugs, group, audit)
+		}
+	}
+}
+
+// checkSetup validates the config and probes every plug, printing a summary of what it found. It returns the exit
+// code: 0 if everything checked out and 1 otherwise.
+func checkSetup(conf *config.API, plugs []*plug) int {
+	exitCode := 0
+
+	err := conf.Validate()
+	if err != nil {
+		fmt.Printf("config: invalid\n%v\n", err)
+		exitCode = 1
+	} else {
+		fmt.Println("config: ok")
+	}
+
+	errs := make([]error, len(plugs))
+	var wg sync.WaitGroup
+	for i, plug := range plugs {
+		plug.DialTimeout = min(plug.DialTimeout, dryRunProbeTimeout)
+		plug.ReadWriteTimeout = min(plug.ReadWriteTimeout, dryRunProbeTimeout)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = plug.systemInfo()
+		}()
+	}
+	wg.Wait()
+
+	reachable := 0
+	for i, plug := range plugs {
+		address, _ := plug.addresses()
+		if errs[i] != nil {
+			fmt.Printf("%s: offline; %v\n", address, errs[i])
+			continue
+		}
+
+		reachable++
+		fmt.Printf("%s: reachable\n", address)
+	}
+
+	fmt.Printf("%d/%d plugs reachable\n", reachable, len(plugs))
+	if reachable != len(plugs) {
+		exitCode = 1
+	}
+
+	return exitCode
+}
+
+// toggleFromTerminal toggles a group of plugs in parallel in response to terminal input and redraws the status
+// table once they have all finished. A plug that fails to toggle is reported without holding up the others.
+func toggleFromTerminal(plugs, group []*plug, audit *AuditLogger) {
+	_ = term.Sync()
+
+	var wg sync.WaitGroup
+	for _, plug := range group {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := plug.toggle(context.Background(), PriorityHigh)
+			if err != nil {
+				fmt.Printf("could not toggle switch %s; %v\n", plug.Name, err)
+			}
+			audit.LogToggle("", plug, AuditActionToggle, AuditSourceKeyboard, err == nil)
+		}()
+	}
+	wg.Wait()
+
+	redrawUI(plugs)
+}
+
+// getSystemInfo probes every plug in parallel and fills in the details they report about themselves. Plugs that
+// fail or haven't answered by the time ctx is done are left marked offline so that one unreachable plug can't hold
+// up startup; their names are returned. Answers that arrive late are discarded since the plug fields populated here
+// must not change once the plugs are shared.
+func getSystemInfo(ctx context.Context, plugs ...*plug) (unresponsive []string) {
+	type probeResult struct {
+		plug    *plug
+		info    system
+		network NetworkInfo
+		err     error
+	}
+
+	results := make(chan probeResult, len(plugs))
+	for _, plug := range plugs {
+		go func() {
+			info, err := plug.systemInfo()
+			if err != nil {
+				results <- probeResult{plug: plug, err: err}
+				return
+			}
+
+			networkInfo, _ := plug.GetNetworkInfo()
+			results <- probeResult{plug: plug, info: info, network: networkInfo}
+		}()
+	}
+
+	found := map[*plug]bool{}
+	for range plugs {
+		var result probeResult
+		select {
+		case result = <-results:
+		case <-ctx.Done():
+		}
+		if result.plug == nil {
+			break
+		}
+
+		if result.err != nil {
+			fmt.Println(result.err)
+			continue
+		}
+		found[result.plug] = true
+
+		plug, info := result.plug, result.info
+		plug.Name = info.Alias
+		plug.Model = info.Model
+		plug.DeviceType = DetectDeviceType(info.Model)
+		plug.DeviceID = info.DeviceID
+		plug.SoftwareVersion = info.SoftwareVersion
+		plug.MAC = info.MAC
+		plug.SSID = result.network.SSID
+		if info.IsVariableColorTemp == 1 {
+			plug.ColorTempRange = colorTempRange(info.Model)
+		}
+		plug.stateMtx.Lock()
+		if int2bool(info.RelayState) {
+			atomic.StoreInt32(&plug.on, 1)
+			atomic.StoreInt64(&plug.onSince, time.Now().Add(-time.Duration(info.OnTime)*time.Second).UnixNano())
+		}
+		plug.stateMtx.Unlock()
+
+		fmt.Printf("Found plug: %s\n", plug.Name)
+	}
+
+	for _, plug := range plugs {
+		if found[plug] {
+			continue
+		}
+
+		atomic.StoreInt32(&plug.online, 0)
+		address, _ := plug.addresses()
+		unresponsive = append(unresponsive, address)
+	}
+
+	return unresponsive
+}
+
+func int2bool(r int) bool {
+	return r == 1
+}
+
+// parseMapping parses a mapping in the form <address>:<key>,<address>:<key> into plug config entries. The address
+// can be an IP literal (IPv6 literals should be wrapped in brackets) or a hostname.
+func parseMapping(m string) ([]config.Plug, error) {
+	devices := []config.Plug{}
+
+	for _, mapping := range strings.Split(m, ",") {
+		separator := strings.LastIndex(mapping, ":")
+		if separator == -1 {
+			return nil, fmt.Errorf("malformed mapping %q; must be in the form <address>:<key>", mapping)
+		}
+
+		address := strings.TrimSuffix(strings.TrimPrefix(mapping[:separator], "["), "]")
+		triggerKey, err := strconv.Atoi(mapping[separator+1:])
+		if err != nil {
+			return nil, fmt.Errorf("malformed trigger key in mapping %q; %w", mapping, err)
+		}
+
+		devices = append(devices, config.Plug{
+			Address:    address,
+			TriggerKey: triggerKey,
+		})
+	}
+
+	return devices, nil
+}
+
+// processPlugConfig creates plugs from their config file entries, using the defaults for any unset settings.
+func processPlugConfig(devices []config.Plug) []*plug {
+	plugs := []*plug{}
+
+	for _, device := range devices {
+		plug := newPlug(device.Address, device.TriggerKey)
+		plug.BackupAddress = device.BackupAddress
+		plug.AllowedTokens = device.AllowedTokens
+		plug.Description = device.Description
+		plug.Room = device.Room
+		if device.Port > 0 {
+			plug.Port = device.Port
+		}
+		if device.DialTimeoutMS > 0 {
+			plug.DialTimeout = time.Duration(device.DialTimeoutMS) * time.Millisecond
+		}
+		if device.RWTimeoutMS > 0 {
+			plug.ReadWriteTimeout = time.Duration(device.RWTimeoutMS) * time.Millisecond
+		}
+		if device.CommandTTLMS > 0 {
+			plug.CommandTTL = time.Duration(device.CommandTTLMS) * time.Millisecond
+		}
+		plugs = append(plugs, plug)
+	}
+
+	return plugs
+}
+
+func newPlug(address string, triggerKey int) *plug {
+	return &plug{
+		addrMtx:          &sync.RWMutex{},
+		Address:          address,
+		Port:             KasaTCPPort,
+		TriggerKey:       triggerKey,
+		DialTimeout:      DefaultDialTimeout,
+		ReadWriteTimeout: DefaultReadWriteTimeout,
+		CommandTTL:       DefaultCommandTTL,
+		cmdLock:          &commandLock{},
+		cmdInterval:      500 * time.Millisecond,
+		apiCmdMtx:        &sync.Mutex{},
+		stateMtx:         &sync.Mutex{},
+		statsMtx:         &sync.Mutex{},
+	}
+}
+
+func (p *plug) systemInfo() (system, error) {
+	return p.systemInfoAt(context.Background(), PriorityNormal)
+}
+
+// systemInfoAt fetches the plug's system info, waiting for its turn behind other commands at the given priority.
+func (p *plug) systemInfoAt(ctx context.Context, priority commandPriority) (system, error) {
+	payload := `{"system":{"get_sysinfo":{}}}`
+	results, err := p.sendCmdAt(ctx, priority, time.Now(), payload)
+	if err != nil {
+		return system{}, err
+	}
+
+	var info system
+	err = json.Unmarshal(results, &info)
+	if err != nil {
+		return system{}, err
+	}
+
+	return info, nil
+}
+
+func (p *plug) turnOn(ctx context.Context, priority commandPriority, queuedAt time.Time) (err error) {
+	payload := `{"system":{"set_relay_state":{"state":1}}}`
+	_, err = p.sendCmdAt(ctx, priority, queuedAt, payload)
+	return
+}
+
+func (p *plug) turnOff(ctx context.Context, priority commandPriority, queuedAt time.Time) (err error) {
+	payload := `{"system":{"set_relay_state":{"state":0}}}`
+	_, err = p.sendCmdAt(ctx, priority, queuedAt, payload)
+	return
+}
+
+// toggle flips the plug's relay state. The state lock is held for the entire read, command and write so that
+// concurrent toggles are serialized and never act on a stale view of On.
+func (p *plug) toggle(ctx context.Context, priority commandPriority) (err error) {
+	// Waiting for the state lock counts towards the command's TTL just as waiting for the command lock does.
+	queuedAt := time.Now()
+
+	p.stateMtx.Lock()
+	defer p.stateMtx.Unlock()
+
+	if p.expired(queuedAt) {
+		return ErrCommandExpired
+	}
+
+	atomic.AddUint64(&p.TotalToggles, 1)
+
+	today := time.Now().Format(time.DateOnly)
+	if p.toggleDay != today {
+		p.toggleDay = today
+		p.togglesToday = 0
+	}
+	p.togglesToday++
+
+	on, generation := !p.IsOn(), atomic.LoadUint64(&p.stateGeneration)
+	err = p.setStateLocked(ctx, priority, queuedAt, on)
+	if err != nil {
+		p.deadLetter(ctx, on, generation, err)
+		return
+	}
+
+	address, _ := p.addresses()
+	metricsSink.Increment("kasa.plug.toggle", map[string]string{"plug": p.Name, "ip": address})
+
+	fmt.Printf("Toggled: %s %s\n", p.Name, time.Now().Format("01-02 15:04:05"))
+	return
+}
+
+// setState turns the plug on or off and records the new state.
+func (p *plug) setState(ctx context.Context, priority commandPriority, on bool) error {
+	queuedAt := time.Now()
+
+	p.stateMtx.Lock()
+	defer p.stateMtx.Unlock()
+
+	if p.expired(queuedAt) {
+		return ErrCommandExpired
+	}
+
+	generation := atomic.LoadUint64(&p.stateGeneration)
+	err := p.setStateLocked(ctx, priority, queuedAt, on)
+	if err != nil {
+		p.deadLetter(ctx, on, generation, err)
+	}
+
+	return err
+}
+
+// retryState sets the plug's state on behalf of the dead letter queue. The retry is skipped as stale if the plug's
+// state has been changed since generation.
+//
+// The command waits for its turn at low priority, so it is sent without holding stateMtx; otherwise a toggle would
+// have to wait for the retry instead of going ahead of it. The new state is only recorded if nothing else changed
+// the plug in the meantime.
+func (p *plug) retryState(ctx context.Context, on bool, generation uint64) (stale bool, err error) {
+	if atomic.LoadUint64(&p.stateGeneration) != generation {
+		return true, nil
+	}
+
+	err = p.sendState(ctx, PriorityLow, time.Now(), on)
+	if err != nil {
+		return false, err
+	}
+
+	p.stateMtx.Lock()
+	defer p.stateMtx.Unlock()
+
+	if atomic.LoadUint64(&p.stateGeneration) == generation {
+		p.recordStateLocked(on)
+		return false, nil
+	}
+
+	// A change that went ahead of the retry may have been sent before it and so been undone by it. The recorded
+	// state is the newer one, so the plug is put back to it.
+	if on != p.IsOn() {
+		err = p.sendState(ctx, PriorityHigh, time.Now(), p.IsOn())
+		if err != nil {
+			log.Warn().Err(err).Str("plug", p.Name).Str("state", stateName(p.IsOn())).
+				Msg("could not restore plug state after a stale retry")
+		}
+	}
+
+	return true, nil
+}
+
+// deadLetter hands a failed state change to the dead letter queue to be retried later. The change is dropped if
+// the queue is full. Expired commands are already stale so they are never retried, and neither are commands refused
+// during shutdown or commands whose caller gave up on them.
+func (p *plug) deadLetter(ctx context.Context, on bool, generation uint64, err error) {
+	if p.deadLetters == nil || errors.Is(err, ErrCommandExpired) || errors.Is(err, ErrShuttingDown) || ctx.Err() != nil {
+		return
+	}
+
+	address, _ := p.addresses()
+	select {
+	case p.deadLetters <- FailedCommand{
+		plug:       p,
+		generation: generation,
+		PlugName:   p.Name,
+		PlugIP:     address,
+		Action:     stateName(on),
+		Error:      err.Error(),
+		FailedAt:   time.Now(),
+	}:
+	default:
+		log.Warn().Str("plug", p.Name).Str("action", stateName(on)).
+			Msg("dead letter queue is full; failed command will not be retried")
+	}
+}
+
+// setStateLocked turns the plug on or off and records the new state. The caller must hold stateMtx.
+func (p *plug) setStateLocked(ctx context.Context, priority commandPriority, queuedAt time.Time, on bool) error {
+	err := p.sendState(ctx, priority, queuedAt, on)
+	if err != nil {
+		return err
+	}
+
+	p.recordStateLocked(on)
+	return nil
+}
+
+// sendState turns the plug on or off without recording the new state.
+func (p *plug) sendState(ctx context.Context, priority commandPriority, queuedAt time.Time, on bool) error {
+	if on {
+		return p.turnOn(ctx, priority, queuedAt)
+	}
+
+	return p.turnOff(ctx, priority, queuedAt)
+}
+
+// recordStateLocked records that the plug was turned on or off. The caller must hold stateMtx.
+func (p *plug) recordStateLocked(on bool) {
+	if on {
+		if atomic.SwapInt32(&p.on, 1) == 0 {
+			atomic.StoreInt64(&p.onSince, time.Now().UnixNano())
+		}
+		atomic.AddUint64(&p.stateGeneration, 1)
+		return
+	}
+
+	if onSince := atomic.SwapInt64(&p.onSince, 0); onSince != 0 {
+		atomic.AddInt64((*int64)(&p.TotalOnTime), int64(time.Since(time.Unix(0, onSince))))
+	}
+	atomic.StoreInt32(&p.on, 0)
+	atomic.AddUint64(&p.stateGeneration, 1)
+}
+
+// confirmState asks the plug for its relay state and, if it differs from the state recorded, records the state the
+// plug reported instead. It returns whether a correction was made.
+//
+// The plug is asked without holding stateMtx so that a toggle doesn't have to wait behind a confirmation queued at
+// low priority. If the state was changed while the confirmation waited, the plug's answer may predate the change,
+// so no correction is made.
+func (p *plug) confirmState(ctx context.Context) (corrected bool, err error) {
+	generation := atomic.LoadUint64(&p.stateGeneration)
+
+	info, err := p.systemInfoAt(ctx, PriorityLow)
+	if err != nil {
+		return false, err
+	}
+
+	p.stateMtx.Lock()
+	defer p.stateMtx.Unlock()
+
+	on := int2bool(info.RelayState)
+	if atomic.LoadUint64(&p.stateGeneration) != generation || on == p.IsOn() {
+		return false, nil
+	}
+
+	if on {
+		atomic.StoreInt64(&p.onSince, time.Now().Add(-time.Duration(info.OnTime)*time.Second).UnixNano())
+		atomic.StoreInt32(&p.on, 1)
+		atomic.AddUint64(&p.stateGeneration, 1)
+		return true, nil
+	}
+
+	if onSince := atomic.SwapInt64(&p.onSince, 0); onSince != 0 {
+		atomic.AddInt64((*int64)(&p.TotalOnTime), int64(time.Since(time.Unix(0, onSince))))
+	}
+	atomic.StoreInt32(&p.on, 0)
+	atomic.AddUint64(&p.stateGeneration, 1)
+	return true, nil
+}
+
+// stats returns a snapshot of the plug's command statistics.
+func (p *plug) stats() plugStats {
+	stats := plugStats{
+		TotalCommands:   atomic.LoadUint64(&p.TotalCommands),
+		SuccessCommands: atomic.LoadUint64(&p.SuccessCommands),
+		FailureCommands: atomic.LoadUint64(&p.FailureCommands),
+		TotalToggles:    atomic.LoadUint64(&p.TotalToggles),
+		TotalOnTime:     time.Duration(atomic.LoadInt64((*int64)(&p.TotalOnTime))),
+		DryRunCount:     atomic.LoadUint64(&p.DryRunCount),
+	}
+
+	// Include the current stretch of on time if the plug is still on.
+	if onSince := atomic.LoadInt64(&p.onSince); onSince != 0 {
+		stats.TotalOnTime += time.Since(time.Unix(0, onSince))
+	}
+
+	p.statsMtx.Lock()
+	defer p.statsMtx.Unlock()
+
+	stats.LatencyMean = time.Duration(p.latencyMean)
+	if stats.SuccessCommands > 1 {
+		stats.LatencyStdDev = time.Duration(math.Sqrt(p.latencyM2 / float64(stats.SuccessCommands-1)))
+	}
+
+	return stats
+}
+
+// toggleCountToday returns the amount of times the plug has been toggled since local midnight.
+func (p *plug) toggleCountToday() uint64 {
+	p.stateMtx.Lock()
+	defer p.stateMtx.Unlock()
+
+	if p.toggleDay != time.Now().Format(time.DateOnly) {
+		return 0
+	}
+
+	return p.togglesToday
+}
+
+// beginAPICommand reports whether an API request to switch the plug to on duplicates the latest API command, which
+// it does if that command switches the plug to the same state and is either still being sent or finished less than
+// window ago. A zero window turns this off.
+//
+// If the request is not a duplicate it becomes the latest API command, and the caller must call finish once the
+// command has been sent. A command that failed is forgotten so that a retry is not mistaken for a duplicate.
+func (p *plug) beginAPICommand(on bool, window time.Duration) (finish func(ok bool), duplicate bool) {
+	if window <= 0 {
+		return func(bool) {}, false
+	}
+
+	p.apiCmdMtx.Lock()
+	defer p.apiCmdMtx.Unlock()
+
+	if p.apiCmdSeq != 0 && p.apiCmdTarget == on && (p.apiCmdInFlight || time.Since(p.apiCmdFinishedAt) < window) {
+		return nil, true
+	}
+
+	p.apiCmdSeq++
+	seq := p.apiCmdSeq
+	p.apiCmdTarget = on
+	p.apiCmdInFlight = true
+
+	return func(ok bool) {
+		p.apiCmdMtx.Lock()
+		defer p.apiCmdMtx.Unlock()
+
+		// A newer command has taken over.
+		if p.apiCmdSeq != seq {
+			return
+		}
+
+		p.apiCmdInFlight = false
+		p.apiCmdFinishedAt = time.Now()
+		if !ok {
+			p.apiCmdSeq = 0
+		}
+	}, false
+}
+
+// IsOn reports whether the plug's relay is currently on.
+func (p *plug) IsOn() bool {
+	return atomic.LoadInt32(&p.on) == 1
+}
+
+// settledState waits for any state change in progress to finish and then reports whether the plug is on.
+func (p *plug) settledState() bool {
+	p.stateMtx.Lock()
+	defer p.stateMtx.Unlock()
+
+	return p.IsOn()
+}
+
+// respondedWithin reports whether the plug answered a command within the given duration.
+func (p *plug) respondedWithin(d time.Duration) bool {
+	lastSuccess := atomic.LoadInt64(&p.lastSuccess)
+	return lastSuccess != 0 && time.Since(time.Unix(0, lastSuccess)) <= d
+}
+
+// isOnline reports whether the most recent command sent to the plug succeeded.
+func (p *plug) isOnline() bool {
+	return atomic.LoadInt32(&p.online) == 1
+}
+
+// recordLatency folds a successful command's latency into the running mean and variance using
+// Welford's online algorithm. count is the number of successful commands including this one.
+func (p *plug) recordLatency(count uint64, latency time.Duration) {
+	p.statsMtx.Lock()
+	defer p.statsMtx.Unlock()
+
+	sample := float64(latency)
+	delta := sample - p.latencyMean
+	p.latencyMean += delta / float64(count)
+	p.latencyM2 += delta * (sample - p.latencyMean)
+
+	p.latencyWindow[p.latencyWindowNext] = latency
+	p.latencyWindowNext = (p.latencyWindowNext + 1) % latencyWindowSize
+	if p.latencySamples < latencyWindowSize {
+		p.latencySamples++
+	}
+}
+
+// latencyPercentiles returns the 50th, 95th and 99th percentile of the plug's recent command latencies along with
+// the amount of samples they were computed from. The window is copied so commands aren't held up by the sort.
+func (p *plug) latencyPercentiles() (p50, p95, p99 time.Duration, samples int) {
+	p.statsMtx.Lock()
+	window := make([]time.Duration, p.latencySamples)
+	copy(window, p.latencyWindow[:p.latencySamples])
+	p.statsMtx.Unlock()
+
+	if len(window) == 0 {
+		return 0, 0, 0, 0
+	}
+
+	sort.Slice(window, func(i, j int) bool { return window[i] < window[j] })
+	percentile := func(pct int) time.Duration {
+		return window[(len(window)-1)*pct/100]
+	}
+
+	return percentile(50), percentile(95), percentile(99), len(window)
+}
+
+// recordError stores a failed command in the plug's error log, replacing the oldest entry once it is full.
+func (p *plug) recordError(command string, err error) {
+	p.statsMtx.Lock()
+	defer p.statsMtx.Unlock()
+
+	p.errorLog[p.errorLogNext] = PlugErrorEntry{
+		Timestamp: time.Now(),
+		Command:   command,
+		Error:     err.Error(),
+		Temporary: isTransientError(err),
+	}
+	p.errorLogNext = (p.errorLogNext + 1) % plugErrorLogSize
+}
+
+// recentErrors returns the plug's logged command failures, newest first.
+func (p *plug) recentErrors() []PlugErrorEntry {
+	p.statsMtx.Lock()
+	defer p.statsMtx.Unlock()
+
+	entries := []PlugErrorEntry{}
+	for i := 1; i <= plugErrorLogSize; i++ {
+		entry := p.errorLog[(p.errorLogNext-i+plugErrorLogSize)%plugErrorLogSize]
+		if entry.Timestamp.IsZero() {
+			break
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+// addresses returns the plug's current primary and backup addresses.
+func (p *plug) addresses() (address, backupAddress string) {
+	p.addrMtx.RLock()
+	defer p.addrMtx.RUnlock()
+
+	return p.Address, p.BackupAddress
+}
+
+// swapAddresses promotes the backup address to primary and demotes the primary address to backup.
+func (p *plug) swapAddresses() {
+	p.addrMtx.Lock()
+	defer p.addrMtx.Unlock()
+
+	p.Address, p.BackupAddress = p.BackupAddress, p.Address
+}
+
+// dial resolves the given plug address and opens a connection to it, giving up early if ctx is done.
+func (p *plug) dial(ctx context.Context, address string) (net.Conn, error) {
+	addr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(address, strconv.Itoa(p.Port)))
+	if err != nil {
+		return nil, fmt.Errorf("resolving plug address: %w", err)
+	}
+
+	dialer := net.Dialer{Timeout: p.DialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr.String())
+	if err != nil {
+		return nil, fmt.Errorf("connecting to plug: %w", err)
+	}
+
+	return conn, nil
+}
+
+// commandTracker counts the commands currently being sent to plugs so that shutdown can wait for them to finish
+// instead of cutting one off halfway. Unlike a sync.WaitGroup, commands may try to start while shutdown is waiting;
+// they are refused.
+type commandTracker struct {
+	mu       sync.Mutex
+	idle     *sync.Cond
+	inflight int
+	draining bool
+}
+
+func newCommandTracker() *commandTracker {
+	tracker := &commandTracker{}
+	tracker.idle = sync.NewCond(&tracker.mu)
+	return tracker
+}
+
+// start counts a command in. It returns false if shutdown has started, in which case the command must not be sent.
+// A nil tracker lets every command through.
+func (t *commandTracker) start() bool {
+	if t == nil {
+		return true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.draining {
+		return false
+	}
+	t.inflight++
+	return true
+}
+
+// done counts out a command that start let through.
+func (t *commandTracker) done() {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.inflight--
+	if t.inflight == 0 {
+		t.idle.Broadcast()
+	}
+}
+
+// drain refuses any new commands and blocks until every command in flight is done.
+func (t *commandTracker) drain() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.draining = true
+	for t.inflight > 0 {
+		t.idle.Wait()
+	}
+}
+
+// sendCmd handles the communication with the plug.
+func (p *plug) sendCmd(data string) (res []byte, err error) {
+	return p.sendCmdAt(context.Background(), PriorityNormal, time.Now(), data)
+}
+
+// expired reports whether a command that started waiting at queuedAt has waited longer than the plug's CommandTTL.
+func (p *plug) expired(queuedAt time.Time) bool {
+	return p.CommandTTL > 0 && time.Since(queuedAt) > p.CommandTTL
+}
+
+// sendCmdAt sends a command to the plug once every command ahead of it has been sent. Commands waiting with a higher
+// priority go ahead of it. queuedAt is when the caller started waiting to send the command; the command is dropped
+// with ErrCommandExpired if it is still waiting a CommandTTL after that.
+//
+// ctx bounds waiting for the plug and connecting to it. Once connected, the exchange is bounded by ReadWriteTimeout
+// alone so that a command the plug has received is not cut off halfway.
+func (p *plug) sendCmdAt(ctx context.Context, priority commandPriority, queuedAt time.Time, data string) (res []byte,
+	err error,
+) {
+	if !p.inflight.start() {
+		return nil, ErrShuttingDown
+	}
+	defer p.inflight.done()
+
+	// protect against sending too many commands at once
+	if err := p.cmdLock.lock(ctx, priority); err != nil {
+		return nil, err
+	}
+	if p.expired(queuedAt) {
+		p.cmdLock.unlock()
+		return nil, ErrCommandExpired
+	}
+	defer func() {
+		p.lastCmd = time.Now()
+		p.cmdLock.unlock()
+	}()
+	if time.Since(p.lastCmd) < p.cmdInterval {
+		time.Sleep(p.cmdInterval)
+	}
+
+	atomic.AddUint64(&p.TotalCommands, 1)
+	start := time.Now()
+	address, backupAddress := p.addresses()
+	defer func() {
+		tags := map[string]string{"plug": p.Name, "ip": address}
+
+		if err != nil {
+			// A caller giving up on the command says nothing about the plug.
+			if ctx.Err() != nil {
+				atomic.AddUint64(&p.FailureCommands, 1)
+				return
+			}
+
+			reportPlugError(p, address, err)
+			p.recordError(data, err)
+			metricsSink.Gauge("kasa.plug.online", 0, tags)
+			atomic.AddUint64(&p.FailureCommands, 1)
+			if atomic.SwapInt32(&p.online, 0) == 1 {
+				p.startRediscovery()
+			}
+			return
+		}
+
+		latency := time.Since(start)
+		atomic.StoreInt64(&p.lastSuccess, time.Now().UnixNano())
+		metricsSink.Gauge("kasa.plug.online", 1, tags)
+		metricsSink.Timing("kasa.plug.command.latency", latency, tags)
+		atomic.StoreInt32(&p.online, 1)
+		count := atomic.AddUint64(&p.SuccessCommands, 1)
+		p.recordLatency(count, latency)
+	}()
+
+	res = make([]byte, 2048)
+
+	// connect to plug
+	conn, err := p.dial(ctx, address)
+	if err != nil {
+		var netErr net.Error
+		if backupAddress == "" || !errors.As(err, &netErr) {
+			return res, err
+		}
+
+		var backupErr error
+		conn, backupErr = p.dial(ctx, backupAddress)
+		if backupErr != nil {
+			return res, err
+		}
+
+		p.swapAddresses()
+		log.Warn().Str("plug", p.Name).Str("old_address", address).Str("new_address", backupAddress).
+			Msg("plug unreachable at primary address; switched to backup address")
+	}
+	defer conn.Close()
+
+	// set timeout; this only starts once we're connected so the full budget is available for the exchange.
+	if err := conn.SetDeadline(time.Now().Add(p.ReadWriteTimeout)); err != nil {
+		return res, fmt.Errorf("setting timeout: %w", err)
+	}
+
+	payload := encrypt([]byte(data))
+
+	if _, err := conn.Write(payload); err != nil {
+		return res, fmt.Errorf("writing payload: %w", err)
+	}
+
+	// receive, decrypt response
+	i, err := conn.Read(res)
+	if err != nil {
+		return res, err
+	}
+	decrypted := decrypt(res[:i]) // only include the bytes that were read
+	return decrypted, nil
+}
+
+// encrypt follows the autokey cipher used by the HS1xx to encrypt commands.
+func encrypt(bx []byte) []byte {
+	key := 171
+	res := make([]byte, 4)
+	binary.BigEndian.PutUint32(res, uint32(len(bx))) // equivalent in python: struct.pack('>I', len(cmd))
+
+	for i := range bx {
+		b := key ^ int(bx[i])
+		key = b
+		res = append(res, byte(b))
+	}
+	return res
+}
+
+// decrypt follows the autokey cipher used by the HS1xx to decrypt commands.
+func decrypt(bx []byte) []byte {
+	key := 171
+	var res []byte
+
+	for i := 4; i < len(bx); i++ { // first 4 bytes are padding
+		b := key ^ int(bx[i])
+		key = int(bx[i])
+		res = append(res, byte(b))
+	}
+	return res
+}
@@ -0,0 +1,666 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/clintjedwards/innerhaven/internal/config"
+)
+
+// fakePlug is a TCP server speaking the Kasa protocol on a random local port. It keeps a relay state, records every
+// command it receives and answers them the way an HS1xx would.
+type fakePlug struct {
+	listener net.Listener
+
+	// failing makes the server close connections without answering. Accessed atomically.
+	failing int32
+
+	mu         sync.Mutex
+	received   []string
+	relayState int
+
+	// connections counts accepted connections and clientClosed those the client closed after its answer.
+	connections  int
+	clientClosed int
+}
+
+func newFakePlug(t *testing.T) *fakePlug {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not start fake plug: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	fake := &fakePlug{listener: listener}
+	go fake.serve()
+
+	return fake
+}
+
+// plug returns a plug pointed at the fake plug under the given address, which must resolve to 127.0.0.1.
+func (f *fakePlug) plug(address string) *plug {
+	p := processPlugConfig([]config.Plug{{
+		Address: address,
+		Port:    f.listener.Addr().(*net.TCPAddr).Port,
+	}})[0]
+	p.cmdInterval = 0
+
+	return p
+}
+
+func (f *fakePlug) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go f.handle(conn)
+	}
+}
+
+func (f *fakePlug) handle(conn net.Conn) {
+	defer conn.Close()
+
+	f.mu.Lock()
+	f.connections++
+	f.mu.Unlock()
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	body := make([]byte, binary.BigEndian.Uint32(header))
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return
+	}
+	cmd := string(decrypt(append(header, body...)))
+
+	f.mu.Lock()
+	f.received = append(f.received, cmd)
+
+	if atomic.LoadInt32(&f.failing) == 1 {
+		f.mu.Unlock()
+		return
+	}
+
+	var request struct {
+		System struct {
+			SetRelayState *struct {
+				State int `json:"state"`
+			} `json:"set_relay_state"`
+		} `json:"system"`
+	}
+	_ = json.Unmarshal([]byte(cmd), &request)
+
+	response := `{"system":{"get_sysinfo":{"alias":"fake","model":"HS103(US)","relay_state":` +
+		strconv.Itoa(f.relayState) + `,"err_code":0}}}`
+	if request.System.SetRelayState != nil {
+		f.relayState = request.System.SetRelayState.State
+		response = `{"system":{"set_relay_state":{"err_code":0}}}`
+	}
+	f.mu.Unlock()
+
+	_, _ = conn.Write(encrypt([]byte(response)))
+
+	// Anything but EOF means the client kept the connection open after its answer.
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == io.EOF {
+		f.mu.Lock()
+		f.clientClosed++
+		f.mu.Unlock()
+	}
+}
+
+// setFailing controls whether the fake plug answers commands.
+func (f *fakePlug) setFailing(failing bool) {
+	var value int32
+	if failing {
+		value = 1
+	}
+	atomic.StoreInt32(&f.failing, value)
+}
+
+// commands returns every command received so far and forgets them.
+func (f *fakePlug) commands() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	received := f.received
+	f.received = nil
+	return received
+}
+
+func (f *fakePlug) isOn() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.relayState == 1
+}
+
+// TestConcurrentToggles is meant to be run with -race. toggle reads and writes the plug's state under stateMtx and
+// the state itself is atomic, so concurrent toggles must each see the state the one before them left.
+func TestConcurrentToggles(t *testing.T) {
+	fake := newFakePlug(t)
+	p := fake.plug("127.0.0.1")
+
+	const toggles = 100
+
+	var wg sync.WaitGroup
+	for i := 0; i < toggles; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if err := p.toggle(context.Background(), PriorityNormal); err != nil {
+				t.Errorf("toggle failed: %v", err)
+			}
+
+			// Handlers read the state while toggles are in flight.
+			p.IsOn()
+		}()
+	}
+	wg.Wait()
+
+	if t.Failed() {
+		return
+	}
+
+	// An even number of toggles leaves the plug off, and every toggle must have flipped the relay rather than
+	// repeated the previous command.
+	if p.IsOn() || fake.isOn() {
+		t.Errorf("after %d toggles plug on = %v, fake plug on = %v; want both off", toggles, p.IsOn(), fake.isOn())
+	}
+
+	commands := fake.commands()
+	if len(commands) != toggles {
+		t.Fatalf("fake plug received %d commands, want %d", len(commands), toggles)
+	}
+	for i, cmd := range commands {
+		want := `"state":1`
+		if i%2 == 1 {
+			want = `"state":0`
+		}
+		if !strings.Contains(cmd, want) {
+			t.Errorf("command %d = %s, want it to contain %s", i, cmd, want)
+		}
+	}
+}
+
+func TestHostnameAddress(t *testing.T) {
+	devices, err := parseMapping("localhost:1")
+	if err != nil {
+		t.Fatalf("could not parse mapping: %v", err)
+	}
+	if devices[0].Address != "localhost" {
+		t.Fatalf("address = %q, want localhost", devices[0].Address)
+	}
+
+	// The plug only knows the fake plug by name, so the command has to go through the resolver to reach it.
+	fake := newFakePlug(t)
+	p := fake.plug(devices[0].Address)
+
+	info, err := p.systemInfo()
+	if err != nil {
+		t.Fatalf("could not reach plug at localhost: %v", err)
+	}
+	if info.Alias != "fake" {
+		t.Errorf("alias = %q, want fake", info.Alias)
+	}
+}
+
+func TestParseMapping(t *testing.T) {
+	tests := []struct {
+		name    string
+		mapping string
+		want    []config.Plug
+		wantErr bool
+	}{
+		{name: "one plug", mapping: "192.0.2.1:1", want: []config.Plug{{Address: "192.0.2.1", TriggerKey: 1}}},
+		{
+			name:    "several plugs",
+			mapping: "192.0.2.1:1,[2001:db8::1]:2",
+			want:    []config.Plug{{Address: "192.0.2.1", TriggerKey: 1}, {Address: "2001:db8::1", TriggerKey: 2}},
+		},
+		{name: "no key", mapping: "192.0.2.1", wantErr: true},
+		{name: "key isn't a number", mapping: "192.0.2.1:a", wantErr: true},
+		{name: "trailing comma", mapping: "192.0.2.1:1,", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseMapping(tc.mapping)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseMapping(%q) error = %v, want error %v", tc.mapping, err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("parseMapping(%q) = %+v, want %+v", tc.mapping, got, tc.want)
+			}
+		})
+	}
+}
+
+// buildBinary builds the program into a temporary directory with the given version and returns its path.
+func buildBinary(t *testing.T, version string) string {
+	t.Helper()
+
+	if testing.Short() {
+		t.Skip("builds the binary")
+	}
+
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go toolchain not found")
+	}
+
+	binary := filepath.Join(t.TempDir(), "kasa-internal")
+	build := exec.Command(goBin, "build", "-o", binary, "-ldflags", "-X main.appVersion="+version, ".")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("could not build binary: %v\n%s", err, out)
+	}
+
+	return binary
+}
+
+func TestVersionFlag(t *testing.T) {
+	// No config file or plugs are given; --version has to work without them.
+	binary := buildBinary(t, "1.2.3_abc1234")
+
+	cmd := exec.Command(binary, "--version")
+	cmd.Dir = t.TempDir()
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("--version failed: %v", err)
+	}
+
+	format := regexp.MustCompile(`^kasa-internal v\d+\.\d+\.\d+\+[0-9a-f]+\n$`)
+	if !format.Match(out) {
+		t.Errorf("--version printed %q, want it to match %s", out, format)
+	}
+	if string(out) != "kasa-internal v1.2.3+abc1234\n" {
+		t.Errorf("--version printed %q, want the injected version v1.2.3+abc1234", out)
+	}
+}
+
+// Plugs have no circuit breaker, so a failed command is the only way a toggle can be refused.
+func TestToggleStateTransitions(t *testing.T) {
+	tests := []struct {
+		name        string
+		on          bool
+		failing     bool
+		wantErr     bool
+		wantOn      bool
+		wantCommand string
+	}{
+		{
+			name:        "off to on",
+			on:          false,
+			wantOn:      true,
+			wantCommand: `{"system":{"set_relay_state":{"state":1}}}`,
+		},
+		{
+			name:        "on to off",
+			on:          true,
+			wantOn:      false,
+			wantCommand: `{"system":{"set_relay_state":{"state":0}}}`,
+		},
+		{
+			name:        "command fails",
+			on:          false,
+			failing:     true,
+			wantErr:     true,
+			wantOn:      false,
+			wantCommand: `{"system":{"set_relay_state":{"state":1}}}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := newFakePlug(t)
+			fake.setFailing(tc.failing)
+
+			p := fake.plug("127.0.0.1")
+			if tc.on {
+				atomic.StoreInt32(&p.on, 1)
+			}
+
+			err := p.toggle(context.Background(), PriorityNormal)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("toggle() error = %v, want error %v", err, tc.wantErr)
+			}
+
+			if p.IsOn() != tc.wantOn {
+				t.Errorf("on = %v, want %v", p.IsOn(), tc.wantOn)
+			}
+
+			commands := fake.commands()
+			if len(commands) != 1 || commands[0] != tc.wantCommand {
+				t.Errorf("fake plug received %q, want [%q]", commands, tc.wantCommand)
+			}
+		})
+	}
+}
+
+// Plug connections aren't pooled, so there are no idle connections that could go stale and need keepalive probes.
+// This checks that stays true: every command gets a connection of its own that is closed once answered.
+func TestCommandConnectionsNotReused(t *testing.T) {
+	fake := newFakePlug(t)
+	p := fake.plug("127.0.0.1")
+
+	const commands = 3
+	for i := 0; i < commands; i++ {
+		if _, err := p.systemInfo(); err != nil {
+			t.Fatalf("command %d failed: %v", i, err)
+		}
+	}
+
+	// The fake plug notices the close asynchronously.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		fake.mu.Lock()
+		connections, closed := fake.connections, fake.clientClosed
+		fake.mu.Unlock()
+
+		if closed == commands || time.Now().After(deadline) {
+			if connections != commands || closed != commands {
+				t.Errorf("%d commands used %d connections and closed %d; want a closed connection per command",
+					commands, connections, closed)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitForQueuedCommands waits until n commands are waiting for their turn at the plug.
+func waitForQueuedCommands(t *testing.T, p *plug, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		p.cmdLock.mu.Lock()
+		queued := p.cmdLock.waiting.Len()
+		p.cmdLock.mu.Unlock()
+
+		if queued == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d commands queued, want %d", queued, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHighPriorityToggleGoesFirst(t *testing.T) {
+	fake := newFakePlug(t)
+	p := fake.plug("127.0.0.1")
+
+	// Hold the plug's command lock so the commands below queue up behind it.
+	p.cmdLock.lock(context.Background(), PriorityHigh)
+
+	var wg sync.WaitGroup
+	run := func(command func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if err := command(); err != nil {
+				t.Errorf("command failed: %v", err)
+			}
+		}()
+	}
+
+	// The state confirmation mustn't keep the toggle from queueing by holding on to the plug's state while it waits.
+	run(func() error {
+		_, err := p.confirmState(context.Background())
+		return err
+	})
+	waitForQueuedCommands(t, p, 1)
+	run(func() error {
+		_, err := p.systemInfoAt(context.Background(), PriorityNormal)
+		return err
+	})
+	waitForQueuedCommands(t, p, 2)
+	run(func() error { return p.toggle(context.Background(), PriorityHigh) })
+	waitForQueuedCommands(t, p, 3)
+
+	p.cmdLock.unlock()
+	wg.Wait()
+
+	commands := fake.commands()
+	if len(commands) != 3 || commands[0] != `{"system":{"set_relay_state":{"state":1}}}` {
+		t.Fatalf("commands = %q, want the toggle first", commands)
+	}
+
+	// The confirmation was answered after the toggle, but it was asked for before it, so it must not undo it.
+	if !p.IsOn() {
+		t.Errorf("plug is off after toggling it on")
+	}
+}
+
+func TestCommandExpiry(t *testing.T) {
+	tests := []struct {
+		name string
+		// hold takes the lock the command has to wait for and returns a function to release it.
+		hold func(p *plug) func()
+	}{
+		{
+			name: "waiting for the state lock",
+			hold: func(p *plug) func() {
+				p.stateMtx.Lock()
+				return p.stateMtx.Unlock
+			},
+		},
+		{
+			name: "waiting for the command lock",
+			hold: func(p *plug) func() {
+				p.cmdLock.lock(context.Background(), PriorityHigh)
+				return p.cmdLock.unlock
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := newFakePlug(t)
+			p := fake.plug("127.0.0.1")
+			p.CommandTTL = 50 * time.Millisecond
+
+			release := tc.hold(p)
+			errs := make(chan error, 1)
+			go func() { errs <- p.toggle(context.Background(), PriorityHigh) }()
+
+			time.Sleep(2 * p.CommandTTL)
+			release()
+
+			if err := <-errs; !errors.Is(err, ErrCommandExpired) {
+				t.Fatalf("toggle() error = %v, want %v", err, ErrCommandExpired)
+			}
+			if commands := fake.commands(); len(commands) != 0 {
+				t.Errorf("fake plug received %q, want no commands", commands)
+			}
+			if p.IsOn() {
+				t.Errorf("plug is on after its toggle expired")
+			}
+		})
+	}
+}
+
+func TestCommandTrackerDrain(t *testing.T) {
+	fake := newFakePlug(t)
+	p := fake.plug("127.0.0.1")
+	p.inflight = newCommandTracker()
+
+	// Stands in for a command that is being sent when shutdown starts.
+	if !p.inflight.start() {
+		t.Fatalf("command refused before draining")
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		p.inflight.drain()
+		close(drained)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		p.inflight.mu.Lock()
+		draining := p.inflight.draining
+		p.inflight.mu.Unlock()
+
+		if draining {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("drain never started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := p.systemInfo(); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("command during drain: error = %v, want %v", err, ErrShuttingDown)
+	}
+	if commands := fake.commands(); len(commands) != 0 {
+		t.Errorf("fake plug received %q during drain, want no commands", commands)
+	}
+
+	select {
+	case <-drained:
+		t.Fatalf("drain returned with a command still in flight")
+	default:
+	}
+
+	p.inflight.done()
+	select {
+	case <-drained:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("drain did not return once the command in flight was done")
+	}
+}
+
+// fillLatencyWindow records a full window of latencies from 1ms to latencyWindowSize ms, in a shuffled order.
+func fillLatencyWindow(p *plug) {
+	for i := 0; i < latencyWindowSize; i++ {
+		// 7 and latencyWindowSize share no factors, so this visits every value once.
+		ms := (i*7)%latencyWindowSize + 1
+		p.recordLatency(uint64(i+1), time.Duration(ms)*time.Millisecond)
+	}
+}
+
+func TestLatencyPercentiles(t *testing.T) {
+	p := newPlug("192.0.2.1", 0)
+
+	if _, _, _, samples := p.latencyPercentiles(); samples != 0 {
+		t.Fatalf("samples = %d before any commands, want 0", samples)
+	}
+
+	fillLatencyWindow(p)
+
+	p50, p95, p99, samples := p.latencyPercentiles()
+	if samples != latencyWindowSize {
+		t.Errorf("samples = %d, want %d", samples, latencyWindowSize)
+	}
+	if p50 != 500*time.Millisecond || p95 != 950*time.Millisecond || p99 != 990*time.Millisecond {
+		t.Errorf("p50, p95, p99 = %v, %v, %v; want 500ms, 950ms, 990ms", p50, p95, p99)
+	}
+
+	// Once full the oldest sample, 1ms, is overwritten rather than the window growing.
+	p.recordLatency(latencyWindowSize+1, time.Hour)
+	if p50, _, _, samples := p.latencyPercentiles(); samples != latencyWindowSize || p50 != 501*time.Millisecond {
+		t.Errorf("after one more sample: samples = %d, p50 = %v; want %d, 501ms", samples, p50, latencyWindowSize)
+	}
+}
+
+// BenchmarkLatencyPercentiles computes percentiles over a full window. The latency endpoint computes them on every
+// request, so each computation must take less than a millisecond.
+func BenchmarkLatencyPercentiles(b *testing.B) {
+	p := newPlug("192.0.2.1", 0)
+	fillLatencyWindow(p)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.latencyPercentiles()
+	}
+	b.StopTimer()
+
+	if perOp := b.Elapsed() / time.Duration(b.N); perOp > time.Millisecond {
+		b.Errorf("computing percentiles took %v, want under 1ms", perOp)
+	}
+}
+
+func TestCancelledCommand(t *testing.T) {
+	fake := newFakePlug(t)
+	p := fake.plug("127.0.0.1")
+	deadLetters := make(chan FailedCommand, 1)
+	p.deadLetters = deadLetters
+
+	p.cmdLock.lock(context.Background(), PriorityHigh)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() { errs <- p.toggle(ctx, PriorityHigh) }()
+	waitForQueuedCommands(t, p, 1)
+
+	infoErrs := make(chan error, 1)
+	go func() {
+		_, err := p.systemInfoAt(context.Background(), PriorityLow)
+		infoErrs <- err
+	}()
+	waitForQueuedCommands(t, p, 2)
+
+	cancel()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Fatalf("toggle() error = %v, want %v", err, context.Canceled)
+	}
+	waitForQueuedCommands(t, p, 1)
+
+	// The command behind the cancelled one still gets its turn.
+	p.cmdLock.unlock()
+	if err := <-infoErrs; err != nil {
+		t.Fatalf("systemInfoAt() error = %v", err)
+	}
+
+	if commands := fake.commands(); len(commands) != 1 || commands[0] != `{"system":{"get_sysinfo":{}}}` {
+		t.Errorf("fake plug received %q, want only the system info request", commands)
+	}
+	if p.IsOn() {
+		t.Errorf("plug is on after its toggle was cancelled")
+	}
+	if len(deadLetters) != 0 {
+		t.Errorf("cancelled toggle was dead lettered")
+	}
+}
+
+func TestMalformedMappingFlag(t *testing.T) {
+	binary := buildBinary(t, "0.0.dev_000000")
+
+	cmd := exec.Command(binary, "--dry-run", "192.0.2.1")
+	cmd.Dir = t.TempDir()
+	out, err := cmd.CombinedOutput()
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+		t.Fatalf("--dry-run with a malformed mapping: error = %v, want exit code 1\n%s", err, out)
+	}
+	if !strings.Contains(string(out), "malformed mapping") || !strings.Contains(string(out), "Usage") {
+		t.Errorf("output = %q, want the parse error and the usage text", out)
+	}
+}
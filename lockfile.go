@@ -0,0 +1,45 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// fileLock is an advisory flock(2) lock that coordinates access to a file between processes.
+type fileLock struct {
+	file *os.File
+}
+
+// lockFile blocks until it holds a lock on the lock file at path, creating it if needed. Any number of processes
+// can hold a shared lock at once while an exclusive lock is held by one process alone.
+func lockFile(path string, exclusive bool) (*fileLock, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("could not open lock file %q; %w", path, err)
+	}
+
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+
+	err = syscall.Flock(int(file.Fd()), how)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("could not lock %q; %w", path, err)
+	}
+
+	return &fileLock{file: file}, nil
+}
+
+func (l *fileLock) unlock() error {
+	if l == nil {
+		return nil
+	}
+
+	defer l.file.Close()
+	return syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+}
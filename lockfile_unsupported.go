@@ -0,0 +1,16 @@
+//go:build windows || plan9
+
+package main
+
+// fileLock is unavailable on platforms without flock(2); a nil lock does nothing.
+type fileLock struct{}
+
+// lockFile returns a nil lock since file locking isn't supported on this platform. Files shared between instances
+// are not protected here.
+func lockFile(_ string, _ bool) (*fileLock, error) {
+	return nil, nil
+}
+
+func (l *fileLock) unlock() error {
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/clintjedwards/innerhaven/internal/config"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// logLevels are the values accepted for the log level.
+var logLevels = []string{"trace", "debug", "info", "warn", "error", "fatal"}
+
+// initLogging sets up the global logger from the config. The log level is taken from the --log-level flag, then
+// the KASA_LOG_LEVEL environment variable, then server.log_level from the config file. If none of them are set the
+// level is info.
+func initLogging(flagLevel string, conf *config.API) error {
+	switch conf.Logging.Format {
+	case "console":
+		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	case "json":
+		log.Logger = zerolog.New(os.Stderr).With().Timestamp().Logger()
+	default:
+		return fmt.Errorf("invalid log format %q; must be one of json or console", conf.Logging.Format)
+	}
+
+	level := "info"
+	for _, candidate := range []string{conf.Server.LogLevel, os.Getenv("KASA_LOG_LEVEL"), flagLevel} {
+		if candidate != "" {
+			level = candidate
+		}
+	}
+
+	if !contains(logLevels, level) {
+		return fmt.Errorf("invalid log level %q; must be one of %v", level, logLevels)
+	}
+
+	parsedLevel, err := zerolog.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	zerolog.SetGlobalLevel(parsedLevel)
+
+	log.Info().Str("level", level).Str("format", conf.Logging.Format).Msg("logging initialized")
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/clintjedwards/innerhaven/internal/config"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+func TestLogLevel(t *testing.T) {
+	tests := []struct {
+		name      string
+		envLevel  string
+		flagLevel string
+		wantDebug bool
+	}{
+		{name: "default", wantDebug: false},
+		{name: "env debug", envLevel: "debug", wantDebug: true},
+		{name: "env trace", envLevel: "trace", wantDebug: true},
+		{name: "env warn", envLevel: "warn", wantDebug: false},
+		{name: "flag overrides env", envLevel: "debug", flagLevel: "info", wantDebug: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			logger, level := log.Logger, zerolog.GlobalLevel()
+			t.Cleanup(func() {
+				log.Logger = logger
+				zerolog.SetGlobalLevel(level)
+			})
+
+			t.Setenv("KASA_LOG_LEVEL", tc.envLevel)
+
+			err := initLogging(tc.flagLevel, config.DefaultAPIConfig())
+			if err != nil {
+				t.Fatalf("could not initialize logging: %v", err)
+			}
+
+			var buf bytes.Buffer
+			log.Logger = zerolog.New(&buf)
+			log.Debug().Msg("debug event")
+
+			if emitted := buf.Len() > 0; emitted != tc.wantDebug {
+				t.Errorf("debug event emitted = %v, want %v", emitted, tc.wantDebug)
+			}
+		})
+	}
+}
+
+func TestLogLevelInvalid(t *testing.T) {
+	logger, level := log.Logger, zerolog.GlobalLevel()
+	t.Cleanup(func() {
+		log.Logger = logger
+		zerolog.SetGlobalLevel(level)
+	})
+
+	t.Setenv("KASA_LOG_LEVEL", "loud")
+
+	if err := initLogging("", config.DefaultAPIConfig()); err == nil {
+		t.Error("initLogging accepted log level \"loud\"")
+	}
+}
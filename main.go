@@ -1,33 +1,178 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/clintjedwards/innerhaven/internal/config"
 	"github.com/clintjedwards/innerhaven/internal/frontend"
+	"github.com/coreos/go-systemd/v22/daemon"
 	"github.com/danielgtaylor/huma/v2"
-	"github.com/danielgtaylor/huma/v2/adapters/humago"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/getsentry/sentry-go"
+	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/acme/autocert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 func ptr[T any](v T) *T {
 	return &v
 }
 
+// deref returns the value p points to, or def if p is nil.
+func deref[T any](p *T, def T) T {
+	if p == nil {
+		return def
+	}
+	return *p
+}
+
 type APIContext struct {
 	config *config.API
+
+	// The plugs managed by this service. The slice is read from concurrent handler goroutines so all
+	// access must go through plugsMu.
+	plugsMu sync.RWMutex
+	plugs   []*plug
+
+	// The time at which the API context was created; used to report uptime.
+	startedAt time.Time
+
+	// The last time any plug changed state; served as Last-Modified for the plug list.
+	lastModifiedMu sync.Mutex
+	lastModified   time.Time
+
+	// Plugs currently in away (vacation) mode mapped to the function that stops them.
+	awayModesMu sync.Mutex
+	awayModes   map[*plug]context.CancelFunc
+
+	// Plugs with a state confirmation scheduled mapped to its timer; nil once cleanup has stopped them all.
+	confirmationsMu sync.Mutex
+	confirmations   map[*plug]*time.Timer
+
+	// The latest probe of the plugs made for a readiness check; see sharedReadinessProbe.
+	readinessMu    sync.Mutex
+	readinessProbe *readinessProbe
+
+	// The currently playing command sequence, if any.
+	sequenceMu     sync.Mutex
+	sequence       sequenceProgress
+	sequenceCancel context.CancelFunc
+
+	// Records plug state changes; holds nil when the audit log is disabled. Swapped out when the config is
+	// reloaded.
+	audit atomic.Pointer[AuditLogger]
+
+	// The client ACL and rate limiter currently in use. Both are replaced when the config is reloaded.
+	acl     atomic.Pointer[cidrACL]
+	limiter atomic.Pointer[rateLimiter]
+
+	// Serializes config reloads and guards the state they replace.
+	reloadMu     sync.Mutex
+	auditLogPath string
+	evictTask    *TaskHandle
+
+	// The most recent plug state changes.
+	history toggleHistory
+
+	// Called after every successful plug state change. Only appended to in NewAPI, so reads need no lock.
+	postToggleHooks []PostToggleHook
+
+	// Writes plug state changes to syslog; nil when syslog is disabled.
+	syslog *SyslogSink
+
+	// Semaphore bounding how many plug commands fan-out operations have in flight at once; see
+	// acquirePlugCommandSlot.
+	plugCommandSlots chan struct{}
+
+	// Failed plug state changes waiting to be retried.
+	deadLetters *DeadLetterQueue
+
+	// The commands being sent to the plugs, which shutdown waits for.
+	inflight *commandTracker
+
+	// Runs periodic background work; every task is stopped by cleanup.
+	tasks *TaskManager
+
+	// Set by generateTLSConfig when certificates are managed through ACME.
+	acmeManager *autocert.Manager
 }
 
 // NewAPI creates a new instance of the main Gofer API service.
-func NewAPI(config *config.API) (*APIContext, error) {
+func NewAPI(config *config.API, plugs []*plug) (*APIContext, error) {
+	err := config.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+
+	err = initSentry(config.Sentry)
+	if err != nil {
+		return nil, fmt.Errorf("could not initialize sentry: %w", err)
+	}
+
+	metricsSink, err = NewStatsDSink(config.StatsD.Address, config.StatsD.Prefix)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to statsd: %w", err)
+	}
+
+	audit, err := NewAuditLogger(config.AuditLogPath)
+	if err != nil {
+		return nil, err
+	}
+
+	syslogSink, err := NewSyslogSink(config.Logging.SyslogAddress)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to syslog: %w", err)
+	}
+
+	now := time.Now()
 	newAPI := &APIContext{
-		config: config,
+		config:       config,
+		plugs:        plugs,
+		startedAt:    now,
+		lastModified: now,
+		awayModes:    map[*plug]context.CancelFunc{},
+		tasks:        newTaskManager(),
+		auditLogPath: config.AuditLogPath,
+		syslog:       syslogSink,
+		deadLetters:  newDeadLetterQueue(),
+		inflight:     newCommandTracker(),
+
+		plugCommandSlots: make(chan struct{}, config.MaxConcurrentPlugCommands),
+		confirmations:    map[*plug]*time.Timer{},
+	}
+	newAPI.audit.Store(audit)
+
+	if syslogSink != nil {
+		newAPI.postToggleHooks = append(newAPI.postToggleHooks, syslogSink.LogToggle)
+	}
+
+	for _, plug := range plugs {
+		plug.deadLetters = newAPI.deadLetters.queue
+		plug.inflight = newAPI.inflight
+	}
+
+	if config.SharedStatePath != "" {
+		newAPI.postToggleHooks = append(newAPI.postToggleHooks, newAPI.saveSharedState)
 	}
 
 	return newAPI, nil
@@ -35,38 +180,228 @@ func NewAPI(config *config.API) (*APIContext, error) {
 
 // cleanup gracefully cleans up all goroutines to ensure a clean shutdown.
 func (apictx *APIContext) cleanup() {
+	apictx.tasks.stop()
+	apictx.stopStateConfirmations()
+	apictx.stopAwayMode()
+	apictx.stopSequence()
+	apictx.drainPlugCommands()
+	apictx.history.closeSubscribers()
+
+	err := apictx.audit.Load().Close()
+	if err != nil {
+		log.Error().Err(err).Msg("could not close audit log")
+	}
+
+	err = apictx.syslog.Close()
+	if err != nil {
+		log.Error().Err(err).Msg("could not close syslog connection")
+	}
+
+	err = metricsSink.Close()
+	if err != nil {
+		log.Error().Err(err).Msg("could not close statsd connection")
+	}
+
+	// Give events that are still queued a chance to reach Sentry before we exit.
+	sentry.Flush(5 * time.Second)
 }
 
-// StartAPIService starts the Gofer API service and blocks until a SIGINT or SIGTERM is received.
-func (apictx *APIContext) StartAPIService() {
-	tlsConfig, err := apictx.generateTLSConfig(apictx.config.Server.TLSCertPath, apictx.config.Server.TLSKeyPath)
+// drainPlugCommands refuses new plug commands and waits for those still being sent to finish, giving up after the
+// configured drain timeout.
+func (apictx *APIContext) drainPlugCommands() {
+	drained := make(chan struct{})
+	go func() {
+		apictx.inflight.drain()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(apictx.config.Server.CommandDrainTimeout):
+		log.Warn().Dur("timeout", apictx.config.Server.CommandDrainTimeout).
+			Msg("plug commands still in flight after drain timeout; shutting down anyway")
+	}
+}
+
+// StartAPIService starts the Gofer API service and blocks until a SIGINT or SIGTERM is received. It returns an error
+// if the service could not be started.
+func (apictx *APIContext) StartAPIService() error {
+	tlsConfig, err := apictx.generateTLSConfig(apictx.config.Server.TLSCertPath, apictx.config.Server.TLSKeyPath,
+		apictx.config.Server.ClientCACertPath)
 	if err != nil {
-		log.Fatal().Err(err).Msg("could not get proper TLS config")
+		return fmt.Errorf("could not get proper TLS config; %w", err)
 	}
 
 	// Assign all routes and handlers
-	router, _ := InitRouter(apictx)
+	router, _, err := InitRouter(apictx)
+	if err != nil {
+		return fmt.Errorf("could not initialize router; %w", err)
+	}
+
+	apictx.reloadMu.Lock()
+	apictx.setRateLimiter(apictx.config.RateLimit)
+	apictx.reloadMu.Unlock()
+
+	acl, err := newCIDRACL(apictx.config.Server.AllowedCIDRs, apictx.config.Server.BlockedCIDRs)
+	if err != nil {
+		return fmt.Errorf("could not parse client CIDRs; %w", err)
+	}
+	apictx.acl.Store(acl)
+
+	// Middleware is applied inside out; the last one wrapped is the first to see a request.
+	var handler http.Handler = router
+	handler = apictx.requestTimeoutMiddleware(handler)
+	handler = apictx.slaMiddleware(handler)
+	handler = apictx.roleMiddleware(handler)
+	handler = apictx.bodyLimitMiddleware(handler)
+	handler = apictx.rateLimitMiddleware(handler)
+	handler = apictx.aclMiddleware(handler)
+	handler = apictx.requesterIPMiddleware(handler)
+	handler = apictx.securityHeadersMiddleware(handler)
+	handler = recoveryMiddleware(handler)
+	handler = loggingMiddleware(handler)
+	handler = requestIDMiddleware(handler)
+	handler = streamingMiddleware(handler)
 
 	httpServer := http.Server{
 		Addr:         apictx.config.Server.ListenAddress,
-		Handler:      loggingMiddleware(router),
+		Handler:      handler,
 		WriteTimeout: apictx.config.Server.WriteTimeout,
 		ReadTimeout:  apictx.config.Server.ReadTimeout,
 		IdleTimeout:  apictx.config.Server.IdleTimeout,
 		TLSConfig:    tlsConfig,
 	}
 
+	// We bind the listener ourselves instead of using ListenAndServeTLS so that we know the server is accepting
+	// connections before telling systemd we're ready.
+	listener, err := net.Listen("tcp", httpServer.Addr)
+	if err != nil {
+		return fmt.Errorf("could not listen on address; %w", err)
+	}
+
+	var grpcListener net.Listener
+	if apictx.config.Server.GRPCListenAddress != "" {
+		grpcListener, err = net.Listen("tcp", apictx.config.Server.GRPCListenAddress)
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("could not listen on grpc address; %w", err)
+		}
+	}
+
+	// Plain HTTP requests are redirected to HTTPS. When certificates come from ACME this listener also answers
+	// Let's Encrypt's challenge that proves we own the domain.
+	var redirectListener net.Listener
+	devCerts := apictx.config.Development.UseLocalhostTLS && apictx.config.Server.TLSCertPath == "" &&
+		apictx.acmeManager == nil
+	if apictx.config.Server.RedirectListenAddress != "" && !devCerts {
+		redirectListener, err = net.Listen("tcp", apictx.config.Server.RedirectListenAddress)
+		if err != nil {
+			listener.Close()
+			if grpcListener != nil {
+				grpcListener.Close()
+			}
+			return fmt.Errorf("could not listen on redirect address; %w", err)
+		}
+	}
+
+	go apictx.runDeadLetterQueue(apictx.tasks.ctx)
+
+	if apictx.config.StateExport.Dir != "" {
+		apictx.ScheduleStateExport(apictx.config.StateExport.Interval, apictx.config.StateExport.Dir)
+	}
+
 	// Run our server in a goroutine and listen for signals that indicate graceful shutdown
 	go func() {
-		if err := httpServer.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+		if err := httpServer.ServeTLS(listener, "", ""); err != nil && err != http.ErrServerClosed {
 			log.Fatal().Err(err).Msg("server exited abnormally")
 		}
 	}()
 	log.Info().Str("url", apictx.config.Server.ListenAddress).Msg("started gofer http service")
+	apictx.warnIfAuthDisabled()
+
+	var redirectServer *http.Server
+	if redirectListener != nil {
+		// The address was validated along with the rest of the config.
+		_, httpsPort, _ := net.SplitHostPort(apictx.config.Server.ListenAddress)
+
+		var redirectHandler http.Handler = redirectToHTTPS(httpsPort)
+		if apictx.acmeManager != nil {
+			redirectHandler = apictx.acmeManager.HTTPHandler(redirectHandler)
+		}
+
+		redirectServer = &http.Server{
+			Addr:         apictx.config.Server.RedirectListenAddress,
+			Handler:      redirectHandler,
+			WriteTimeout: apictx.config.Server.WriteTimeout,
+			ReadTimeout:  apictx.config.Server.ReadTimeout,
+			IdleTimeout:  apictx.config.Server.IdleTimeout,
+		}
+
+		go func() {
+			if err := redirectServer.Serve(redirectListener); err != nil && err != http.ErrServerClosed {
+				log.Fatal().Err(err).Msg("redirect server exited abnormally")
+			}
+		}()
+		log.Info().Str("url", apictx.config.Server.RedirectListenAddress).Msg("started http to https redirect service")
+	}
+
+	var grpcServer *grpc.Server
+	if grpcListener != nil {
+		grpcServer = apictx.newGRPCServer(grpc.Creds(credentials.NewTLS(tlsConfig)))
+
+		go func() {
+			if err := grpcServer.Serve(grpcListener); err != nil {
+				log.Fatal().Err(err).Msg("grpc server exited abnormally")
+			}
+		}()
+		log.Info().Str("url", apictx.config.Server.GRPCListenAddress).Msg("started grpc service")
+	}
+
+	// Let systemd know we're up when running as a Type=notify unit. This is a no-op when not run under systemd.
+	_, err = daemon.SdNotify(false, daemon.SdNotifyReady)
+	if err != nil {
+		log.Warn().Err(err).Msg("could not notify systemd of readiness")
+	}
+
+	// SIGHUP reloads the config without dropping connections. It gets its own channel so that a reload can never
+	// be mistaken for a shutdown.
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			err := apictx.reloadConfig()
+			if err != nil {
+				log.Error().Err(err).Msg("could not reload config; keeping current settings")
+				continue
+			}
+
+			log.Info().Msg("reloaded config")
+		}
+	}()
+
+	if watchConfigFile {
+		watchCtx, stopWatching := context.WithCancel(context.Background())
+		defer stopWatching()
+
+		path := config.APIConfigPath("")
+		if path == "" {
+			log.Warn().Msg("no config file found; --watch-config has nothing to watch")
+		} else if err := apictx.watchConfig(watchCtx, path); err != nil {
+			log.Error().Err(err).Msg("could not start config file watcher")
+		} else {
+			log.Info().Str("path", path).Msg("watching config file for changes")
+		}
+	}
 
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGTERM, syscall.SIGINT)
 	<-c
+	signal.Stop(reload)
+
+	_, err = daemon.SdNotify(false, daemon.SdNotifyStopping)
+	if err != nil {
+		log.Warn().Err(err).Msg("could not notify systemd of shutdown")
+	}
 
 	// On ctrl-c we need to clean up not only the connections from the server, but make sure all the currently
 	// running jobs are logged and exited properly.
@@ -77,13 +412,127 @@ func (apictx *APIContext) StartAPIService() {
 	ctx, cancel := context.WithTimeout(context.Background(), apictx.config.Server.ShutdownTimeout) // shutdown gracefully
 	defer cancel()
 
+	if redirectServer != nil {
+		err = redirectServer.Shutdown(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("could not shutdown redirect server in timeout specified")
+		}
+	}
+
+	if grpcServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			log.Error().Msg("could not shutdown grpc server in timeout specified")
+			grpcServer.Stop()
+		}
+	}
+
 	err = httpServer.Shutdown(ctx)
 	if err != nil {
 		log.Error().Err(err).Msg("could not shutdown server in timeout specified")
-		return
+		return nil
 	}
 
 	log.Info().Msg("http server exited gracefully")
+	return nil
+}
+
+// bodyLimitMiddleware rejects requests with a body larger than the configured maximum with a 413. The body is read
+// up front so that an oversized body is refused before any handler starts working on it; handlers get the buffered
+// copy.
+func (apictx *APIContext) bodyLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body == nil || r.Body == http.NoBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		limit := apictx.config.Server.MaxBodyBytes
+		tooLarge := fmt.Sprintf("Request body must not be larger than %d bytes", limit)
+
+		// Don't bother reading a body we already know is too large.
+		if r.ContentLength > limit {
+			writeProblem(w, r, problemTypeBlank, http.StatusRequestEntityTooLarge, tooLarge)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				writeProblem(w, r, problemTypeBlank, http.StatusRequestEntityTooLarge, tooLarge)
+				return
+			}
+
+			writeProblem(w, r, problemTypeBlank, http.StatusBadRequest, "Could not read request body")
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestTimeoutMiddleware cancels the request context once the configured request timeout passes so that handlers
+// stop working on requests the client has likely given up on. Long lived streams are left alone.
+func (apictx *APIContext) requestTimeoutMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isLongLivedPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), apictx.config.Server.RequestTimeout)
+		defer cancel()
+
+		// Logged as the timeout fires rather than once the handler returns, which is what to look for when a
+		// handler doesn't stop.
+		stop := context.AfterFunc(ctx, func() {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				log.Warn().Str("method", r.Method).Stringer("url", r.URL).
+					Dur("timeout", apictx.config.Server.RequestTimeout).Msg("request timed out; cancelling its context")
+			}
+		})
+		defer stop()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// redirectToHTTPS sends the client to the same URL over HTTPS on the given port. The port the request was sent to is
+// dropped from the host, and the HTTPS port is left out when it's the default.
+func redirectToHTTPS(httpsPort string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			// There was no port to strip.
+			host = strings.TrimSuffix(strings.TrimPrefix(r.Host, "["), "]")
+		}
+
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+
+		http.Redirect(w, r, "https://"+host+r.RequestURI, http.StatusMovedPermanently)
+	}
+}
+
+// requestIDMiddleware gives every request an ID, reusing the X-Request-Id header when the client sends one, and
+// echoes it back in the response so that a request can be traced through logs on both sides.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(middleware.RequestIDHeader, middleware.GetReqID(r.Context()))
+		next.ServeHTTP(w, r)
+	}))
 }
 
 // The logging middleware has to be run before the final call to return the request.
@@ -97,7 +546,8 @@ func loggingMiddleware(next http.Handler) http.Handler {
 		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
 		next.ServeHTTP(ww, r)
 
-		log.Debug().Str("method", r.Method).
+		log.Debug().Str("request_id", middleware.GetReqID(r.Context())).
+			Str("method", r.Method).
 			Stringer("url", r.URL).
 			Int("status_code", ww.Status()).
 			Int("response_size_bytes", ww.BytesWritten()).
@@ -106,14 +556,49 @@ func loggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// securityHeadersMiddleware sets headers that tell browsers to lock down how they treat our responses. HSTS is only
+// sent over TLS with real certificates so that development setups aren't pinned to HTTPS.
+func (apictx *APIContext) securityHeadersMiddleware(next http.Handler) http.Handler {
+	devCerts := apictx.config.Development.UseLocalhostTLS && apictx.config.Server.TLSCertPath == ""
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers := w.Header()
+
+		if r.TLS != nil && !devCerts {
+			headers.Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+		}
+		headers.Set("X-Frame-Options", "DENY")
+		headers.Set("X-Content-Type-Options", "nosniff")
+		headers.Set("Referrer-Policy", "no-referrer")
+		if apictx.config.Server.CSPHeader != "" {
+			headers.Set("Content-Security-Policy", apictx.config.Server.CSPHeader)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 // Create a new http router that gets populated by huma lib. Huma helps create an OpenAPI spec and documentation
 // from REST code. We export this function so that we can use it in external scripts to generate the OpenAPI spec
 // for this API in other places.
-func InitRouter(apictx *APIContext) (router *http.ServeMux, apiDescription huma.API) {
-	router = http.NewServeMux()
+func InitRouter(apictx *APIContext) (router *chi.Mux, apiDescription huma.API, err error) {
+	// huma panics when an operation can't be registered, for example because of a duplicate operation ID or path.
+	// Turn that into an error so the caller can fail with a clear message.
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			router, apiDescription = nil, nil
+			err = fmt.Errorf("could not register API routes: %v", recovered)
+		}
+	}()
+
+	router = chi.NewRouter()
 
-	version, _ := parseVersion(appVersion)
-	humaConfig := huma.DefaultConfig("Gofer", version)
+	apiVersion := appVersion
+	version, ok := parseVersion(appVersion)
+	if ok {
+		apiVersion = version.String()
+	}
+	humaConfig := huma.DefaultConfig("Gofer", apiVersion)
 	humaConfig.Info.Description = "Gofer is an opinionated, streamlined automation engine designed for the cloud-native " +
 		"era. It specializes in executing your custom scripts in a containerized environment, making it versatile for " +
 		"both developers and operations teams. Deploy Gofer effortlessly as a single static binary, and " +
@@ -135,11 +620,64 @@ func InitRouter(apictx *APIContext) (router *http.ServeMux, apiDescription huma.
 		},
 	}
 
-	apiDescription = humago.New(router, humaConfig)
+	humaConfig.Transformers = append(humaConfig.Transformers, problemTransformer)
+
+	// huma's default formats are shared between APIs so they're copied rather than changed in place.
+	humaConfig.Formats = maps.Clone(humaConfig.Formats)
+	humaConfig.Formats["application/json"] = apictx.jsonFormat()
+	humaConfig.Formats["json"] = apictx.jsonFormat()
+
+	apiDescription = humachi.New(router, humaConfig)
+	apiDescription.UseMiddleware(prettyPrintMiddleware)
+
+	/* /api/health */
+	apictx.registerDescribeLiveness(apiDescription)
+	apictx.registerDescribeReadiness(apiDescription)
 
 	/* /api/system */
 	apictx.registerDescribeSystemInfo(apiDescription)
 	apictx.registerDescribeSystemSummary(apiDescription)
+	apictx.registerDescribeVersion(apiDescription)
+
+	apictx.registerDescribeStats(apiDescription)
+	apictx.registerListTasks(apiDescription)
+	apictx.registerDescribeDeadLetterQueue(apiDescription)
+	apictx.registerExportInfluxMetrics(apiDescription)
+
+	/* /api/vacation-mode */
+	apictx.registerStartVacationMode(apiDescription)
+	apictx.registerStopVacationMode(apiDescription)
+
+	/* /api/sequences */
+	apictx.registerPlaySequence(apiDescription)
+	apictx.registerDescribeRunningSequence(apiDescription)
+
+	/* /api/plugs */
+	apictx.registerListPlugs(apiDescription)
+	apictx.registerListPlugHistory(apiDescription)
+	apictx.registerExportPlugHistory(apiDescription)
+	apictx.registerStreamPlugEvents(apiDescription)
+	apictx.registerPlugWebSocket(router)
+	apictx.registerDescribePlug(apiDescription)
+	apictx.registerDescribePlugStats(apiDescription)
+	apictx.registerDescribePlugLatency(apiDescription)
+	apictx.registerListPlugErrors(apiDescription)
+	apictx.registerTogglePlug(apiDescription)
+	apictx.registerTurnOnPlug(apiDescription)
+	apictx.registerTurnOffPlug(apiDescription)
+	apictx.registerRestorePlugs(apiDescription)
+	apictx.registerDescribePlugFirmware(apiDescription)
+	apictx.registerDescribePlugNetwork(apiDescription)
+	apictx.registerDescribePlugTime(apiDescription)
+	apictx.registerSyncPlugTime(apiDescription)
+	apictx.registerListPlugDeviceSchedules(apiDescription)
+	apictx.registerCreatePlugDeviceSchedule(apiDescription)
+	apictx.registerUpdatePlugDeviceSchedule(apiDescription)
+	apictx.registerDeletePlugDeviceSchedule(apiDescription)
+	apictx.registerDescribePlugMonthlyEmeter(apiDescription)
+	apictx.registerDeletePlugEmeterStats(apiDescription)
+	apictx.registerSetPlugColor(apiDescription)
+	apictx.registerSetPlugColorTemp(apiDescription)
 
 	/* /api/lights */
 	// apictx.registerCreateToken(apiDescription)
@@ -150,36 +688,86 @@ func InitRouter(apictx *APIContext) (router *http.ServeMux, apiDescription huma.
 	// /* /api/transit */
 	// apictx.registerDescribeTaskExecution(apiDescription)
 
+	/* /api/graphql */
+	apictx.registerGraphQL(router)
+
+	router.Method(http.MethodGet, "/metrics", promhttp.Handler())
+
 	// Set up the frontend paths last since they capture everything that isn't in the API path.
 	if apictx.config.Development.LoadFrontendFilesFromDisk {
 		log.Warn().Msg("Loading frontend files from local disk dir 'public'; Not for use in production.")
-		router.Handle("/", frontend.LocalHandler())
+		router.Handle("/*", frontend.LocalHandler())
 	} else {
-		router.Handle("/", frontend.StaticHandler())
+		router.Handle("/*", frontend.StaticHandler())
 	}
 
 	if apictx.config.Development.GenerateOpenAPISpecFiles {
-		generateOpenAPIFiles(apiDescription)
+		err = generateOpenAPIFiles(apiDescription, version, apictx.config.Development.OpenAPISpecFormat)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not generate OpenAPI spec files: %w", err)
+		}
 	}
 
-	return router, apiDescription
+	return router, apiDescription, nil
 }
 
-// Generates OpenAPI Yaml files that other services can use to generate code for Gofer's API.
-func generateOpenAPIFiles(apiDescription huma.API) {
-	output, err := apiDescription.OpenAPI().YAML()
-	if err != nil {
-		panic(err)
+// Generates OpenAPI files that other services can use to generate code for Gofer's API. The files are written to
+// openapi/v{semver}/ so that specs for several versions can be hosted side by side. The format is one of "yaml",
+// "json" or "both"; some generators only accept JSON. When writing both, openapi/latest is also pointed at this
+// version's directory.
+func generateOpenAPIFiles(apiDescription huma.API, version Version, format string) error {
+	if version.Semver == "" {
+		return fmt.Errorf("could not determine the version to write spec files for from %q", appVersion)
 	}
 
-	file, err := os.Create("openapi.yaml")
+	versionDir := "v" + version.Semver
+	dir := filepath.Join("openapi", versionDir)
+	err := os.MkdirAll(dir, 0o755)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	defer file.Close()
 
-	_, err = file.Write(output)
-	if err != nil {
-		panic(err)
+	for _, specFormat := range []string{"yaml", "json"} {
+		if format != specFormat && format != "both" {
+			continue
+		}
+
+		output, err := renderOpenAPISpec(apiDescription, specFormat)
+		if err != nil {
+			return err
+		}
+
+		err = os.WriteFile(filepath.Join(dir, "openapi."+specFormat), output, 0o644)
+		if err != nil {
+			return err
+		}
+	}
+
+	if format == "both" {
+		latest := filepath.Join("openapi", "latest")
+		err = os.Remove(latest)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		// The link is relative so the directory can be copied elsewhere, for example to a docs CDN, intact.
+		err = os.Symlink(versionDir, latest)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// renderOpenAPISpec returns the OpenAPI spec for the API in the given format, either "yaml" or "json".
+func renderOpenAPISpec(apiDescription huma.API, format string) ([]byte, error) {
+	switch format {
+	case "yaml":
+		return apiDescription.OpenAPI().YAML()
+	case "json":
+		return json.MarshalIndent(apiDescription.OpenAPI(), "", "  ")
+	default:
+		return nil, fmt.Errorf("unknown OpenAPI spec format %q; must be one of yaml or json", format)
 	}
 }
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/clintjedwards/innerhaven/internal/config"
+)
+
+// newTestAPI returns an API for the given plugs and the handler it would serve them through, with the same router
+// and token checks as the real service but without listening on a port.
+func newTestAPI(t *testing.T, conf *config.API, plugs ...*plug) (*APIContext, http.Handler) {
+	t.Helper()
+
+	if conf == nil {
+		conf = config.DefaultAPIConfig()
+	}
+	conf.Development.UseLocalhostTLS = true
+
+	apictx, err := NewAPI(conf, plugs)
+	if err != nil {
+		t.Fatalf("could not create API: %v", err)
+	}
+	t.Cleanup(apictx.cleanup)
+
+	router, _, err := InitRouter(apictx)
+	if err != nil {
+		t.Fatalf("could not initialize router: %v", err)
+	}
+
+	return apictx, apictx.roleMiddleware(router)
+}
+
+func TestStartAPIService(t *testing.T) {
+	version := appVersion
+	appVersion = "1.2.3_abc1234"
+	t.Cleanup(func() { appVersion = version })
+
+	ca := newTestCA(t)
+	certPath, keyPath := ca.issue(t, x509.ExtKeyUsageServerAuth)
+
+	// Grab a free port for the service to listen on.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not find a free port: %v", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	conf := config.DefaultAPIConfig()
+	conf.Server.ListenAddress = address
+	conf.Server.TLSCertPath = certPath
+	conf.Server.TLSKeyPath = keyPath
+
+	apictx, err := NewAPI(conf, nil)
+	if err != nil {
+		t.Fatalf("could not create API: %v", err)
+	}
+
+	// While any channel is registered for SIGTERM the signal no longer kills the test binary.
+	sigterm := make(chan os.Signal, 1)
+	signal.Notify(sigterm, syscall.SIGTERM)
+
+	stopped := make(chan struct{})
+	go func() {
+		if err := apictx.StartAPIService(); err != nil {
+			t.Errorf("could not start API service: %v", err)
+		}
+		close(stopped)
+	}()
+
+	t.Cleanup(func() {
+		defer signal.Stop(sigterm)
+
+		// The service may not be listening for signals yet, so keep sending them until it shuts down.
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		timeout := time.After(10 * time.Second)
+
+		for {
+			err := syscall.Kill(os.Getpid(), syscall.SIGTERM)
+			if err != nil {
+				t.Errorf("could not send SIGTERM: %v", err)
+				return
+			}
+
+			select {
+			case <-stopped:
+				return
+			case <-ticker.C:
+			case <-timeout:
+				t.Error("service did not shut down after SIGTERM")
+				return
+			}
+		}
+	})
+
+	client := &http.Client{
+		Timeout:   time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: ca.pool()}},
+	}
+
+	var resp *http.Response
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err = client.Get("https://" + address + "/api/system/info")
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("could not reach service: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var info struct {
+		Semver string `json:"semver"`
+		Commit string `json:"commit"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&info)
+	if err != nil {
+		t.Fatalf("could not decode system info: %v", err)
+	}
+
+	if info.Semver != "1.2.3" || info.Commit != "abc1234" {
+		t.Errorf("semver, commit = %q, %q; want 1.2.3, abc1234", info.Semver, info.Commit)
+	}
+}
+
+// goldenOpenAPISpec is the committed copy of the API's OpenAPI spec. Run the tests with UPDATE_GOLDEN=1 to rewrite
+// it after an intended change to the API.
+const goldenOpenAPISpec = "testdata/openapi.golden.yaml"
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		name      string
+		host      string
+		httpsPort string
+		want      string
+	}{
+		{name: "default port", host: "example.com", httpsPort: "443", want: "https://example.com/api/plugs?on=true"},
+		{name: "request port", host: "example.com:80", httpsPort: "443", want: "https://example.com/api/plugs?on=true"},
+		{
+			name:      "custom port",
+			host:      "example.com:80",
+			httpsPort: "8080",
+			want:      "https://example.com:8080/api/plugs?on=true",
+		},
+		{name: "ipv6", host: "[::1]:80", httpsPort: "443", want: "https://[::1]/api/plugs?on=true"},
+		{name: "ipv6 custom port", host: "[::1]", httpsPort: "8080", want: "https://[::1]:8080/api/plugs?on=true"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/plugs?on=true", nil)
+			r.Host = tc.host
+
+			w := httptest.NewRecorder()
+			redirectToHTTPS(tc.httpsPort).ServeHTTP(w, r)
+
+			if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != tc.want {
+				t.Errorf("got %d to %q, want %d to %q", w.Code, w.Header().Get("Location"), http.StatusMovedPermanently,
+					tc.want)
+			}
+		})
+	}
+}
+
+func TestGenerateOpenAPISpec(t *testing.T) {
+	// The spec includes the version, which builds may inject.
+	version := appVersion
+	appVersion = "0.0.dev_000000"
+	t.Cleanup(func() { appVersion = version })
+
+	apictx, _ := newTestAPI(t, nil)
+	_, apiDescription, err := InitRouter(apictx)
+	if err != nil {
+		t.Fatalf("could not initialize router: %v", err)
+	}
+
+	spec, err := renderOpenAPISpec(apiDescription, "yaml")
+	if err != nil {
+		t.Fatalf("could not render spec: %v", err)
+	}
+
+	if os.Getenv("UPDATE_GOLDEN") != "" {
+		err = os.WriteFile(goldenOpenAPISpec, spec, 0o644)
+		if err != nil {
+			t.Fatalf("could not update golden file: %v", err)
+		}
+	}
+
+	golden, err := os.ReadFile(goldenOpenAPISpec)
+	if err != nil {
+		t.Fatalf("could not read golden file: %v", err)
+	}
+
+	if !bytes.Equal(spec, golden) {
+		t.Errorf("OpenAPI spec differs from %s; if the change is intended rerun with UPDATE_GOLDEN=1\n%s",
+			goldenOpenAPISpec, lineDiff(string(golden), string(spec)))
+	}
+}
+
+// lineDiff returns the lines that differ between want and got, each prefixed with its line number.
+func lineDiff(want, got string) string {
+	wantLines, gotLines := strings.Split(want, "\n"), strings.Split(got, "\n")
+
+	var diff strings.Builder
+	for i := 0; i < max(len(wantLines), len(gotLines)); i++ {
+		var wantLine, gotLine string
+		if i < len(wantLines) {
+			wantLine = wantLines[i]
+		}
+		if i < len(gotLines) {
+			gotLine = gotLines[i]
+		}
+
+		if wantLine != gotLine {
+			fmt.Fprintf(&diff, "%d:\n- %s\n+ %s\n", i+1, wantLine, gotLine)
+		}
+	}
+
+	return diff.String()
+}
+
+func TestGenerateOpenAPIFilesError(t *testing.T) {
+	apictx, _ := newTestAPI(t, nil)
+	_, apiDescription, err := InitRouter(apictx)
+	if err != nil {
+		t.Fatalf("could not initialize router: %v", err)
+	}
+
+	// Tests may run as root, which permissions don't stop, so the spec directory is blocked by a file instead.
+	dir := t.TempDir()
+	err = os.WriteFile(filepath.Join(dir, "openapi"), nil, 0o644)
+	if err != nil {
+		t.Fatalf("could not create file: %v", err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("could not get working directory: %v", err)
+	}
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatalf("could not change directory: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+
+	err = generateOpenAPIFiles(apiDescription, Version{Semver: "1.2.3", Commit: "abc1234"}, "both")
+	if err == nil {
+		t.Error("generateOpenAPIFiles succeeded writing below a file; want an error")
+	}
+
+	// InitRouter hands the error to its caller rather than starting without the files it was asked for.
+	apictx.config.Development.GenerateOpenAPISpecFiles = true
+	_, _, err = InitRouter(apictx)
+	if err == nil {
+		t.Error("InitRouter succeeded although the spec files could not be written; want an error")
+	}
+}
+
+func TestPtr(t *testing.T) {
+	tests := []struct {
+		name  string
+		value int
+	}{
+		{name: "zero", value: 0},
+		{name: "non-zero", value: 42},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := ptr(tc.value)
+			if got == nil || *got != tc.value {
+				t.Errorf("ptr(%d) = %v, want a pointer to %d", tc.value, got, tc.value)
+			}
+		})
+	}
+}
+
+func TestDeref(t *testing.T) {
+	tests := []struct {
+		name string
+		p    *int
+		def  int
+		want int
+	}{
+		{name: "nil", p: nil, def: 7, want: 7},
+		{name: "nil zero default", p: nil, def: 0, want: 0},
+		{name: "non-nil", p: ptr(42), def: 7, want: 42},
+		{name: "non-nil zero", p: ptr(0), def: 7, want: 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := deref(tc.p, tc.def); got != tc.want {
+				t.Errorf("deref = %d, want %d", got, tc.want)
+			}
+		})
+	}
+
+	if got := deref((*string)(nil), "fallback"); got != "fallback" {
+		t.Errorf("deref of a nil *string = %q, want fallback", got)
+	}
+}
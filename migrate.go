@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// migrateConfig converts a legacy <address>:<key>,<address>:<key> command line mapping into a config file at
+// outPath listing the same plugs, so that per plug settings can be added to them. Each plug created is printed.
+func migrateConfig(legacyMapping, outPath string) error {
+	devices, err := parseMapping(legacyMapping)
+	if err != nil {
+		return err
+	}
+
+	var out strings.Builder
+	out.WriteString("# Generated by kasa-internal --migrate-config\n")
+	out.WriteString("plugs {\n")
+	out.WriteString("  devices = [\n")
+	for _, device := range devices {
+		out.WriteString("    {\n")
+		fmt.Fprintf(&out, "      address     = %s\n", strconv.Quote(device.Address))
+		fmt.Fprintf(&out, "      trigger_key = %d\n", device.TriggerKey)
+		out.WriteString("    },\n")
+	}
+	out.WriteString("  ]\n")
+	out.WriteString("}\n")
+
+	err = writeFileAtomic(outPath, []byte(out.String()))
+	if err != nil {
+		return err
+	}
+
+	for _, device := range devices {
+		fmt.Printf("+ plug %s (trigger key %d)\n", device.Address, device.TriggerKey)
+	}
+	fmt.Printf("wrote %d plugs to %s\n", len(devices), outPath)
+
+	return nil
+}
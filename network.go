@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+// NetworkInfo describes the wireless network a plug is connected to.
+type NetworkInfo struct {
+	SSID        string `json:"ssid"`
+	RSSI        int    `json:"rssi"`
+	IsConnected bool   `json:"is_connected"`
+	MAC         string `json:"mac"`
+	KeyType     int    `json:"key_type"`
+}
+
+// GetNetworkInfo queries the plug for details about its wireless connection.
+func (p *plug) GetNetworkInfo() (NetworkInfo, error) {
+	payload := `{"netif":{"get_stainfo":{}}}`
+	results, err := p.sendCmd(payload)
+	if err != nil {
+		return NetworkInfo{}, err
+	}
+
+	var response struct {
+		Netif struct {
+			StaInfo NetworkInfo `json:"get_stainfo"`
+		} `json:"netif"`
+	}
+	err = json.Unmarshal(results, &response)
+	if err != nil {
+		return NetworkInfo{}, err
+	}
+
+	info := response.Netif.StaInfo
+
+	// Not all firmware reports the MAC address alongside the station info so fall back to the one from sysinfo.
+	if info.MAC == "" {
+		info.MAC = p.MAC
+	}
+
+	return info, nil
+}
@@ -0,0 +1,1384 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/conditional"
+	"github.com/rs/zerolog/log"
+)
+
+// getPlug returns the managed plug with the given IP address.
+func (apictx *APIContext) getPlug(ip string) (*plug, bool) {
+	apictx.plugsMu.RLock()
+	defer apictx.plugsMu.RUnlock()
+
+	for _, plug := range apictx.plugs {
+		if address, _ := plug.addresses(); address == ip {
+			return plug, true
+		}
+	}
+
+	return nil, false
+}
+
+// listPlugs returns a copy of the managed plug list that is safe to iterate without holding plugsMu.
+func (apictx *APIContext) listPlugs() []*plug {
+	apictx.plugsMu.RLock()
+	defer apictx.plugsMu.RUnlock()
+
+	plugs := make([]*plug, len(apictx.plugs))
+	copy(plugs, apictx.plugs)
+
+	return plugs
+}
+
+// allowedPlugs returns the managed plugs the request's token may use. Handlers reporting on every plug use this
+// instead of listPlugs so that a token limited to some plugs learns nothing about the others.
+func (apictx *APIContext) allowedPlugs(ctx context.Context) []*plug {
+	plugs := []*plug{}
+	for _, plug := range apictx.listPlugs() {
+		if apictx.plugAllowed(ctx, plug) {
+			plugs = append(plugs, plug)
+		}
+	}
+
+	return plugs
+}
+
+// plugListLastModified returns the last time any plug changed state.
+func (apictx *APIContext) plugListLastModified() time.Time {
+	apictx.lastModifiedMu.Lock()
+	defer apictx.lastModifiedMu.Unlock()
+
+	return apictx.lastModified
+}
+
+type PlugSummary struct {
+	Name        string `json:"name" example:"Office Lamp" doc:"The name (alias) configured on the plug"`
+	Description string `json:"description" example:"Controls the 3D printer (do not turn off during print)" doc:"A note about the plug from the config file; empty if none was given"`
+	Address     string `json:"address" example:"192.168.1.20" doc:"The IP address or hostname the plug is reached at"`
+	Model       string `json:"model" example:"HS105(US)" doc:"The model reported by the plug"`
+	On          bool   `json:"on" example:"true" doc:"Whether the plug is currently switched on"`
+	Online      bool   `json:"online" example:"true" doc:"Whether the last command sent to the plug succeeded"`
+}
+
+// ListPlugsResponseBody documents the shape of the plug list. The list itself is streamed rather than built from
+// this type; see writePlugList.
+type ListPlugsResponseBody struct {
+	Plugs []PlugSummary `json:"plugs" doc:"All plugs the caller may use"`
+}
+
+// plugListFlushInterval is how many plugs are written between flushes when streaming the plug list.
+const plugListFlushInterval = 50
+
+type (
+	ListPlugsRequest struct {
+		conditional.Params
+	}
+	ListPlugsResponse struct {
+		ETag         string    `header:"ETag"`
+		LastModified time.Time `header:"Last-Modified"`
+		Body         func(ctx huma.Context)
+	}
+)
+
+// writePlugList writes the plug list one plug at a time so that large lists are never held in memory as a whole
+// encoded document. The output is the same JSON object ListPlugsResponseBody describes.
+func writePlugList(w io.Writer, plugs []PlugSummary) error {
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+
+	_, err := io.WriteString(w, `{"plugs":[`)
+	if err != nil {
+		return err
+	}
+
+	for i := range plugs {
+		if i > 0 {
+			_, err = io.WriteString(w, ",")
+			if err != nil {
+				return err
+			}
+		}
+
+		// Encoding through a pointer saves copying each plug onto the heap to pass it as an interface.
+		err = encoder.Encode(&plugs[i])
+		if err != nil {
+			return err
+		}
+
+		if flusher != nil && (i+1)%plugListFlushInterval == 0 {
+			flusher.Flush()
+		}
+	}
+
+	_, err = io.WriteString(w, "]}\n")
+	return err
+}
+
+func (apictx *APIContext) registerListPlugs(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "ListPlugs",
+		Method:      http.MethodGet,
+		Path:        "/api/plugs",
+		Summary:     "List all plugs",
+		Description: "Return a summary of every managed plug. Supports conditional requests through If-None-Match " +
+			"and If-Modified-Since; a 304 with no body is returned when nothing has changed.",
+		Tags:     []string{"Plugs"},
+		Security: bearerAuth,
+		Responses: map[string]*huma.Response{
+			"200": {
+				Description: "OK",
+				Content: map[string]*huma.MediaType{
+					"application/json": {
+						Schema: apiDesc.OpenAPI().Components.Schemas.Schema(
+							reflect.TypeOf(ListPlugsResponseBody{}), true, ""),
+					},
+				},
+			},
+			"304": {
+				Description: "Not Modified",
+			},
+		},
+		// Handler //
+	}, func(ctx context.Context, request *ListPlugsRequest) (*ListPlugsResponse, error) {
+		// Read the modification time first so that a state change racing with this request can only make it look
+		// older than it is, never newer.
+		lastModified := apictx.plugListLastModified()
+
+		plugs := []PlugSummary{}
+		for _, plug := range apictx.allowedPlugs(ctx) {
+			address, _ := plug.addresses()
+			plugs = append(plugs, PlugSummary{
+				Name:        plug.Name,
+				Description: plug.Description,
+				Address:     address,
+				Model:       plug.Model,
+				On:          plug.IsOn(),
+				Online:      plug.isOnline(),
+			})
+		}
+
+		// The ETag is hashed from the same encoding that is streamed, without building the whole document.
+		hash := sha256.New()
+		encoder := json.NewEncoder(hash)
+		for _, plug := range plugs {
+			err := encoder.Encode(plug)
+			if err != nil {
+				return nil, huma.Error500InternalServerError("Could not compute plug list ETag", err)
+			}
+		}
+		etag := hex.EncodeToString(hash.Sum(nil))[:16]
+
+		resp := &ListPlugsResponse{
+			ETag:         `"` + etag + `"`,
+			LastModified: lastModified.UTC(),
+		}
+
+		// If-Modified-Since is ignored when If-None-Match is present (RFC 9110 13.1.3); the ETag is the more precise of
+		// the two since it also changes when a plug goes online or offline.
+		if len(request.IfNoneMatch) > 0 {
+			request.IfModifiedSince = time.Time{}
+		}
+
+		// Last-Modified only has second precision so compare at that precision too.
+		if request.HasConditionalParams() && request.PreconditionFailed(etag, lastModified.Truncate(time.Second)) != nil {
+			resp.Body = func(ctx huma.Context) {
+				ctx.SetStatus(http.StatusNotModified)
+			}
+			return resp, nil
+		}
+
+		resp.Body = func(ctx huma.Context) {
+			ctx.SetHeader("Content-Type", "application/json")
+			ctx.SetStatus(http.StatusOK)
+
+			w := ctx.BodyWriter()
+
+			// Pretty printed output is for reading by hand, so it is encoded as a whole rather than streamed.
+			var err error
+			if apictx.prettyPrint(w) {
+				err = apictx.jsonFormat().Marshal(w, ListPlugsResponseBody{Plugs: plugs})
+			} else {
+				err = writePlugList(w, plugs)
+			}
+			if err != nil {
+				log.Error().Err(err).Msg("could not write plug list")
+			}
+		}
+
+		return resp, nil
+	})
+}
+
+type (
+	DescribePlugRequest struct {
+		IP string `path:"ip" example:"192.168.1.20" doc:"The IP address or hostname of the target plug"`
+	}
+	DescribePlugResponse struct {
+		Body struct {
+			Name         string   `json:"name" example:"Office Lamp" doc:"The name (alias) configured on the plug"`
+			Description  string   `json:"description" example:"Controls the 3D printer (do not turn off during print)" doc:"A note about the plug from the config file; empty if none was given"`
+			Address      string   `json:"address" example:"192.168.1.20" doc:"The IP address or hostname the plug is reached at"`
+			Model        string   `json:"model" example:"HS105(US)" doc:"The model reported by the plug"`
+			DeviceType   string   `json:"device_type" example:"outlet" enum:"outlet,dimmer,strip,bulb,unknown" doc:"The category of device, derived from the model"`
+			Capabilities []string `json:"capabilities" example:"[\"toggle\",\"emeter\"]" doc:"The controls the device supports; one of toggle, emeter, brightness, color, color_temp"`
+			TriggerKey   int      `json:"trigger_key" example:"65535" doc:"The terminal key code that toggles the plug"`
+			SSID         string   `json:"ssid" example:"HomeNetwork" doc:"The wireless network the plug was connected to at startup"`
+			MAC          string   `json:"mac" example:"50:C7:BF:00:00:01" doc:"The MAC address of the plug"`
+			On           bool     `json:"on" example:"true" doc:"Whether the plug is currently switched on"`
+			Online       bool     `json:"online" example:"true" doc:"Whether the last command sent to the plug succeeded"`
+		}
+	}
+)
+
+func (apictx *APIContext) registerDescribePlug(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "DescribePlug",
+		Method:      http.MethodGet,
+		Path:        "/api/plugs/{ip}",
+		Summary:     "Describe a plug",
+		Description: "Return the details and current state of a single plug.",
+		Tags:        []string{"Plugs"},
+		Security:    bearerAuth,
+		// Handler //
+	}, func(ctx context.Context, request *DescribePlugRequest) (*DescribePlugResponse, error) {
+		plug, err := apictx.lookupPlug(ctx, request.IP)
+		if err != nil {
+			return nil, err
+		}
+
+		resp := &DescribePlugResponse{}
+		resp.Body.Name = plug.Name
+		resp.Body.Description = plug.Description
+		resp.Body.Address, _ = plug.addresses()
+		resp.Body.Model = plug.Model
+		resp.Body.DeviceType = plug.DeviceType.String()
+		resp.Body.Capabilities = plug.capabilities()
+		resp.Body.TriggerKey = plug.TriggerKey
+		resp.Body.SSID = plug.SSID
+		resp.Body.MAC = plug.MAC
+		resp.Body.On = plug.IsOn()
+		resp.Body.Online = plug.isOnline()
+
+		return resp, nil
+	})
+}
+
+type (
+	DescribePlugStatsRequest struct {
+		IP string `path:"ip" example:"192.168.1.20" doc:"The IP address or hostname of the target plug"`
+	}
+	DescribePlugStatsResponse struct {
+		Body struct {
+			TotalCommands   uint64  `json:"total_commands" example:"120" doc:"Total amount of commands sent to the plug"`
+			SuccessRate     float64 `json:"success_rate" example:"0.97" doc:"Ratio of commands that completed successfully"`
+			AvgLatencyMS    float64 `json:"avg_latency_ms" example:"45" doc:"Mean round trip latency of successful commands in milliseconds"`
+			LatencyStdDevMS float64 `json:"latency_stddev_ms" example:"12" doc:"Standard deviation of successful command latency in milliseconds"`
+			TotalOnTimeSecs float64 `json:"total_on_time_secs" example:"3600" doc:"Total time the plug has spent on in seconds"`
+			DryRunCount     uint64  `json:"dry_run_count" example:"4" doc:"Amount of dry run commands that were not sent to the plug"`
+		}
+	}
+)
+
+func (apictx *APIContext) registerDescribePlugStats(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "DescribePlugStats",
+		Method:      http.MethodGet,
+		Path:        "/api/plugs/{ip}/stats",
+		Summary:     "Describe command statistics for a plug",
+		Description: "Return success, failure and latency statistics for commands sent to a single plug.",
+		Tags:        []string{"Plugs"},
+		Security:    bearerAuth,
+		// Handler //
+	}, func(ctx context.Context, request *DescribePlugStatsRequest) (*DescribePlugStatsResponse, error) {
+		plug, err := apictx.lookupPlug(ctx, request.IP)
+		if err != nil {
+			return nil, err
+		}
+
+		stats := plug.stats()
+
+		resp := &DescribePlugStatsResponse{}
+		resp.Body.TotalCommands = stats.TotalCommands
+		if stats.TotalCommands > 0 {
+			resp.Body.SuccessRate = float64(stats.SuccessCommands) / float64(stats.TotalCommands)
+		}
+		resp.Body.AvgLatencyMS = float64(stats.LatencyMean) / float64(time.Millisecond)
+		resp.Body.LatencyStdDevMS = float64(stats.LatencyStdDev) / float64(time.Millisecond)
+		resp.Body.TotalOnTimeSecs = stats.TotalOnTime.Seconds()
+		resp.Body.DryRunCount = stats.DryRunCount
+
+		return resp, nil
+	})
+}
+
+type (
+	DescribePlugLatencyRequest struct {
+		IP string `path:"ip" example:"192.168.1.20" doc:"The IP address or hostname of the target plug"`
+	}
+	DescribePlugLatencyResponse struct {
+		Body struct {
+			P50MS       float64 `json:"p50_ms" example:"45" doc:"Median round trip latency in milliseconds"`
+			P95MS       float64 `json:"p95_ms" example:"120" doc:"95th percentile round trip latency in milliseconds"`
+			P99MS       float64 `json:"p99_ms" example:"280" doc:"99th percentile round trip latency in milliseconds"`
+			SampleCount int     `json:"sample_count" example:"1000" doc:"Amount of recent successful commands the percentiles were computed from"`
+		}
+	}
+)
+
+func (apictx *APIContext) registerDescribePlugLatency(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "DescribePlugLatency",
+		Method:      http.MethodGet,
+		Path:        "/api/plugs/{ip}/latency",
+		Summary:     "Describe command latency percentiles for a plug",
+		Description: fmt.Sprintf("Return latency percentiles over the last %d successful commands sent to a plug.", latencyWindowSize),
+		Tags:        []string{"Plugs"},
+		Security:    bearerAuth,
+		// Handler //
+	}, func(ctx context.Context, request *DescribePlugLatencyRequest) (*DescribePlugLatencyResponse, error) {
+		plug, err := apictx.lookupPlug(ctx, request.IP)
+		if err != nil {
+			return nil, err
+		}
+
+		p50, p95, p99, samples := plug.latencyPercentiles()
+
+		resp := &DescribePlugLatencyResponse{}
+		resp.Body.P50MS = float64(p50) / float64(time.Millisecond)
+		resp.Body.P95MS = float64(p95) / float64(time.Millisecond)
+		resp.Body.P99MS = float64(p99) / float64(time.Millisecond)
+		resp.Body.SampleCount = samples
+
+		return resp, nil
+	})
+}
+
+type (
+	ListPlugErrorsRequest struct {
+		IP string `path:"ip" example:"192.168.1.20" doc:"The IP address or hostname of the target plug"`
+	}
+	PlugError struct {
+		Timestamp int64  `json:"timestamp" example:"1712345678000" doc:"Time the command failed in epoch milliseconds"`
+		Command   string `json:"command" example:"{\"system\":{\"get_sysinfo\":{}}}" doc:"JSON payload of the command that failed"`
+		Error     string `json:"error" example:"i/o timeout" doc:"Error returned while sending the command"`
+		Temporary bool   `json:"temporary" example:"true" doc:"Whether the failure looked transient, such as a timeout"`
+	}
+	ListPlugErrorsResponse struct {
+		Body struct {
+			Errors []PlugError `json:"errors" doc:"The most recent failed commands, newest first"`
+		}
+	}
+)
+
+func (apictx *APIContext) registerListPlugErrors(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "ListPlugErrors",
+		Method:      http.MethodGet,
+		Path:        "/api/plugs/{ip}/errors",
+		Summary:     "List recent errors for a plug",
+		Description: fmt.Sprintf("Return the last %d commands sent to a plug that failed, newest first.", plugErrorLogSize),
+		Tags:        []string{"Plugs"},
+		Security:    bearerAuth,
+		// Handler //
+	}, func(ctx context.Context, request *ListPlugErrorsRequest) (*ListPlugErrorsResponse, error) {
+		plug, err := apictx.lookupPlug(ctx, request.IP)
+		if err != nil {
+			return nil, err
+		}
+
+		resp := &ListPlugErrorsResponse{}
+		resp.Body.Errors = []PlugError{}
+		for _, entry := range plug.recentErrors() {
+			resp.Body.Errors = append(resp.Body.Errors, PlugError{
+				Timestamp: entry.Timestamp.UnixMilli(),
+				Command:   entry.Command,
+				Error:     entry.Error,
+				Temporary: entry.Temporary,
+			})
+		}
+
+		return resp, nil
+	})
+}
+
+// acquirePlugCommandSlot blocks until fewer than max_concurrent_plug_commands commands from fan-out operations are in
+// flight, then claims a slot. It returns false without claiming one if the context is done first. Every successful
+// call must be paired with releasePlugCommandSlot once the command has been sent.
+func (apictx *APIContext) acquirePlugCommandSlot(ctx context.Context) bool {
+	select {
+	case apictx.plugCommandSlots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (apictx *APIContext) releasePlugCommandSlot() {
+	<-apictx.plugCommandSlots
+}
+
+// PlugStateResponseBody is returned by the endpoints that switch a plug on or off.
+type PlugStateResponseBody struct {
+	On     bool `json:"on" example:"true" doc:"Whether the plug is now switched on"`
+	DryRun bool `json:"dry_run" example:"false" doc:"Whether the command was only simulated and never sent to the plug"`
+}
+
+// changePlugState switches the plug to the given state, or to the opposite of its current state if toggle is
+// set. When dryRun is set no command is sent; the expected state is returned instead. A request that would switch
+// the plug to the same state as an API command still being sent, or one that finished within the command debounce
+// window, is answered with the plug's state once that command is done rather than sending the command again.
+func (apictx *APIContext) changePlugState(
+	ctx context.Context, plug *plug, on, toggle, dryRun bool,
+) (*PlugStateResponseBody, error) {
+	if dryRun {
+		if toggle {
+			on = !plug.IsOn()
+		}
+
+		atomic.AddUint64(&plug.DryRunCount, 1)
+		log.Debug().Str("plug", plug.Name).Bool("on", on).Bool("toggle", toggle).Msg("dry run; command not sent")
+
+		return &PlugStateResponseBody{On: on, DryRun: true}, nil
+	}
+
+	action := AuditActionToggle
+	if !toggle {
+		action = AuditActionOff
+		if on {
+			action = AuditActionOn
+		}
+	}
+
+	// A toggle is compared by the state it switches the plug to. While an earlier command is in flight the plug
+	// still reports its old state, so a second toggle has the same target and is dropped; a toggle after that
+	// command has finished switches the plug back and is sent.
+	target := on
+	if toggle {
+		target = !plug.IsOn()
+	}
+
+	// settledState waits for a command that is still in flight, so the duplicate gets the state that command left
+	// behind.
+	finish, duplicate := plug.beginAPICommand(target, apictx.config.CommandDebounceWindow)
+	if duplicate {
+		log.Debug().Str("plug", plug.Name).Str("action", action).Msg("duplicate command within debounce window; not sent")
+		return &PlugStateResponseBody{On: plug.settledState()}, nil
+	}
+
+	var err error
+	if toggle {
+		err = plug.toggle(ctx, PriorityHigh)
+	} else {
+		err = plug.setState(ctx, PriorityHigh, on)
+	}
+	finish(err == nil)
+	apictx.recordStateChange(requesterIPFromContext(ctx), plug, action, AuditSourceAPI, err == nil)
+	if err != nil {
+		return nil, plugUnreachableError("Could not change plug state", err)
+	}
+
+	return &PlugStateResponseBody{On: plug.IsOn()}, nil
+}
+
+type (
+	TogglePlugRequest struct {
+		IP     string `path:"ip" example:"192.168.1.20" doc:"The IP address or hostname of the target plug"`
+		DryRun bool   `query:"dry_run" example:"false" doc:"Return the expected result without sending the command"`
+	}
+	TogglePlugResponse struct {
+		Body PlugStateResponseBody
+	}
+)
+
+func (apictx *APIContext) registerTogglePlug(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "TogglePlug",
+		Method:      http.MethodPost,
+		Path:        "/api/plugs/{ip}/toggle",
+		Summary:     "Toggle a plug",
+		Description: "Switch a plug on if it is off or off if it is on.",
+		Tags:        []string{"Plugs"},
+		Security:    bearerAuth,
+		// Handler //
+	}, func(ctx context.Context, request *TogglePlugRequest) (*TogglePlugResponse, error) {
+		err := requireAdmin(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		plug, err := apictx.lookupPlug(ctx, request.IP)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := apictx.changePlugState(ctx, plug, false, true, request.DryRun)
+		if err != nil {
+			return nil, err
+		}
+
+		return &TogglePlugResponse{Body: *body}, nil
+	})
+}
+
+type (
+	TurnOnPlugRequest struct {
+		IP     string `path:"ip" example:"192.168.1.20" doc:"The IP address or hostname of the target plug"`
+		DryRun bool   `query:"dry_run" example:"false" doc:"Return the expected result without sending the command"`
+	}
+	TurnOnPlugResponse struct {
+		Body PlugStateResponseBody
+	}
+)
+
+func (apictx *APIContext) registerTurnOnPlug(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "TurnOnPlug",
+		Method:      http.MethodPost,
+		Path:        "/api/plugs/{ip}/on",
+		Summary:     "Turn a plug on",
+		Description: "Switch a plug on. Plugs that are already on are left on.",
+		Tags:        []string{"Plugs"},
+		Security:    bearerAuth,
+		// Handler //
+	}, func(ctx context.Context, request *TurnOnPlugRequest) (*TurnOnPlugResponse, error) {
+		err := requireAdmin(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		plug, err := apictx.lookupPlug(ctx, request.IP)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := apictx.changePlugState(ctx, plug, true, false, request.DryRun)
+		if err != nil {
+			return nil, err
+		}
+
+		return &TurnOnPlugResponse{Body: *body}, nil
+	})
+}
+
+type (
+	TurnOffPlugRequest struct {
+		IP     string `path:"ip" example:"192.168.1.20" doc:"The IP address or hostname of the target plug"`
+		DryRun bool   `query:"dry_run" example:"false" doc:"Return the expected result without sending the command"`
+	}
+	TurnOffPlugResponse struct {
+		Body PlugStateResponseBody
+	}
+)
+
+func (apictx *APIContext) registerTurnOffPlug(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "TurnOffPlug",
+		Method:      http.MethodPost,
+		Path:        "/api/plugs/{ip}/off",
+		Summary:     "Turn a plug off",
+		Description: "Switch a plug off. Plugs that are already off are left off.",
+		Tags:        []string{"Plugs"},
+		Security:    bearerAuth,
+		// Handler //
+	}, func(ctx context.Context, request *TurnOffPlugRequest) (*TurnOffPlugResponse, error) {
+		err := requireAdmin(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		plug, err := apictx.lookupPlug(ctx, request.IP)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := apictx.changePlugState(ctx, plug, false, false, request.DryRun)
+		if err != nil {
+			return nil, err
+		}
+
+		return &TurnOffPlugResponse{Body: *body}, nil
+	})
+}
+
+// RestorePlugResult reports what restoring a snapshot did to a single plug.
+type RestorePlugResult struct {
+	Address string `json:"address" example:"192.168.1.20" doc:"The address of the plug as given in the snapshot"`
+	Name    string `json:"name" example:"Office Lamp" doc:"The name of the plug; empty if it isn't managed by this service"`
+	On      bool   `json:"on" example:"true" doc:"The state the snapshot asked for"`
+	Changed bool   `json:"changed" example:"true" doc:"Whether the plug was switched, or would be with dry_run set"`
+	Success bool   `json:"success" example:"true" doc:"Whether the plug is now in the requested state"`
+	Error   string `json:"error,omitempty" example:"Plug not found" doc:"Why the plug could not be restored; ex. it isn't managed by this service or the token may not use it"`
+}
+
+type (
+	RestorePlugsRequest struct {
+		DryRun  bool   `query:"dry_run" example:"false" doc:"Report what would change without sending any commands"`
+		RawBody []byte `contentType:"application/json"`
+	}
+	RestorePlugsResponse struct {
+		Body struct {
+			DryRun  bool                `json:"dry_run" example:"false" doc:"Whether the commands were only simulated and never sent"`
+			Results []RestorePlugResult `json:"results" doc:"The outcome for each plug in the snapshot"`
+		}
+	}
+)
+
+func (apictx *APIContext) registerRestorePlugs(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "RestorePlugs",
+		Method:      http.MethodPost,
+		Path:        "/api/plugs/restore",
+		Summary:     "Restore plug states from a snapshot",
+		Description: "Switch every plug in a state snapshot, in the format written by the scheduled state export, " +
+			"to the state recorded for it. Snapshots from older schema versions are migrated first. Plugs already " +
+			"in the recorded state are left alone and a result is returned for every plug in the snapshot.",
+		Tags:     []string{"Plugs"},
+		Security: bearerAuth,
+		// Handler //
+	}, func(ctx context.Context, request *RestorePlugsRequest) (*RestorePlugsResponse, error) {
+		err := requireAdmin(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		doc, _, _, err := parseState(request.RawBody)
+		if err != nil {
+			return nil, invalidInputError(fmt.Sprintf("Could not parse snapshot: %v", err))
+		}
+
+		results := make([]RestorePlugResult, len(doc.Plugs))
+		var wg sync.WaitGroup
+		for i, entry := range doc.Plugs {
+			results[i] = RestorePlugResult{Address: entry.Address, On: entry.On}
+
+			plug, exists := apictx.getPlug(entry.Address)
+			if !exists {
+				results[i].Error = "Plug not found"
+				continue
+			}
+
+			// The snapshot names its plugs in the body, so roleMiddleware can't check them.
+			if !apictx.plugAllowed(ctx, plug) {
+				results[i].Error = "This token may not use this plug"
+				continue
+			}
+			results[i].Name = plug.Name
+
+			if plug.IsOn() == entry.On {
+				results[i].Success = true
+				continue
+			}
+			results[i].Changed = true
+
+			if request.DryRun {
+				results[i].Success = true
+				continue
+			}
+
+			wg.Add(1)
+			go func(result *RestorePlugResult) {
+				defer wg.Done()
+
+				if !apictx.acquirePlugCommandSlot(ctx) {
+					result.Error = ctx.Err().Error()
+					return
+				}
+				err := plug.setState(ctx, PriorityHigh, entry.On)
+				apictx.releasePlugCommandSlot()
+
+				apictx.recordStateChange(requesterIPFromContext(ctx), plug, stateName(entry.On), AuditSourceAPI, err == nil)
+				if err != nil {
+					result.Error = err.Error()
+					return
+				}
+				result.Success = true
+			}(&results[i])
+		}
+		wg.Wait()
+
+		resp := &RestorePlugsResponse{}
+		resp.Body.DryRun = request.DryRun
+		resp.Body.Results = results
+
+		return resp, nil
+	})
+}
+
+type (
+	SetPlugColorRequest struct {
+		IP   string `path:"ip" example:"192.168.1.20" doc:"The IP address or hostname of the target plug"`
+		Body struct {
+			Hue          int `json:"hue" example:"240" doc:"Hue in degrees; clamped to 0-360"`
+			Saturation   int `json:"saturation" example:"100" doc:"Saturation in percent; clamped to 0-100"`
+			TransitionMS int `json:"transition_ms,omitempty" example:"1000" doc:"How long the bulb should take to change in milliseconds; 0-60000, defaults to instant"`
+		}
+	}
+	SetPlugColorResponse struct {
+		Body struct {
+			Hue        int `json:"hue" example:"240" doc:"The hue the bulb was set to"`
+			Saturation int `json:"saturation" example:"100" doc:"The saturation the bulb was set to"`
+		}
+	}
+)
+
+func (apictx *APIContext) registerSetPlugColor(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "SetPlugColor",
+		Method:      http.MethodPost,
+		Path:        "/api/plugs/{ip}/color",
+		Summary:     "Set the color of a bulb",
+		Description: "Set the hue and saturation of a color capable smart bulb.",
+		Tags:        []string{"Plugs"},
+		Security:    bearerAuth,
+		// Handler //
+	}, func(ctx context.Context, request *SetPlugColorRequest) (*SetPlugColorResponse, error) {
+		err := requireAdmin(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		plug, err := apictx.lookupPlug(ctx, request.IP)
+		if err != nil {
+			return nil, err
+		}
+
+		if plug.DeviceType != DeviceTypeBulb || !isColorBulb(plug.Model) {
+			return nil, huma.NewError(http.StatusMethodNotAllowed, "Plug does not support color")
+		}
+
+		err = validateTransitionMS(request.Body.TransitionMS)
+		if err != nil {
+			return nil, invalidInputError(err.Error())
+		}
+
+		err = plug.SetColor(request.Body.Hue, request.Body.Saturation,
+			time.Duration(request.Body.TransitionMS)*time.Millisecond)
+		if err != nil {
+			return nil, plugUnreachableError("Could not set plug color", err)
+		}
+
+		resp := &SetPlugColorResponse{}
+		resp.Body.Hue, resp.Body.Saturation = plug.color()
+
+		return resp, nil
+	})
+}
+
+type (
+	SetPlugColorTempRequest struct {
+		IP   string `path:"ip" example:"192.168.1.20" doc:"The IP address or hostname of the target plug"`
+		Body struct {
+			Kelvin       int `json:"kelvin" example:"4000" doc:"Color temperature in kelvin; must be within the bulb's supported range"`
+			TransitionMS int `json:"transition_ms,omitempty" example:"1000" doc:"How long the bulb should take to change in milliseconds; 0-60000, defaults to instant"`
+		}
+	}
+	SetPlugColorTempResponse struct {
+		Body struct {
+			Kelvin int `json:"kelvin" example:"4000" doc:"The color temperature the bulb was set to"`
+		}
+	}
+)
+
+func (apictx *APIContext) registerSetPlugColorTemp(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "SetPlugColorTemp",
+		Method:      http.MethodPost,
+		Path:        "/api/plugs/{ip}/color-temp",
+		Summary:     "Set the color temperature of a bulb",
+		Description: "Set the white color temperature of a smart bulb in kelvin.",
+		Tags:        []string{"Plugs"},
+		Security:    bearerAuth,
+		// Handler //
+	}, func(ctx context.Context, request *SetPlugColorTempRequest) (*SetPlugColorTempResponse, error) {
+		err := requireAdmin(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		plug, err := apictx.lookupPlug(ctx, request.IP)
+		if err != nil {
+			return nil, err
+		}
+
+		tempRange := plug.ColorTempRange
+		if plug.DeviceType != DeviceTypeBulb || tempRange[1] == 0 {
+			return nil, huma.NewError(http.StatusMethodNotAllowed, "Plug does not support color temperature")
+		}
+
+		if request.Body.Kelvin < tempRange[0] || request.Body.Kelvin > tempRange[1] {
+			return nil, invalidInputError(fmt.Sprintf("Color temperature must be between %dK and %dK",
+				tempRange[0], tempRange[1]))
+		}
+
+		err = validateTransitionMS(request.Body.TransitionMS)
+		if err != nil {
+			return nil, invalidInputError(err.Error())
+		}
+
+		err = plug.SetColorTemp(request.Body.Kelvin, time.Duration(request.Body.TransitionMS)*time.Millisecond)
+		if err != nil {
+			return nil, plugUnreachableError("Could not set plug color temperature", err)
+		}
+
+		resp := &SetPlugColorTempResponse{}
+		resp.Body.Kelvin = plug.colorTemp()
+
+		return resp, nil
+	})
+}
+
+type (
+	DescribePlugFirmwareRequest struct {
+		IP string `path:"ip" example:"192.168.1.20" doc:"The IP address or hostname of the target plug"`
+	}
+	DescribePlugFirmwareResponse struct {
+		Body struct {
+			Current            string `json:"current" example:"1.5.6 Build 191125 Rel.135242" doc:"The firmware version the plug is running"`
+			Latest             string `json:"latest" example:"1.5.8" doc:"The latest known good firmware version for the plug's model; empty if the model is not tracked"`
+			UpgradeRecommended bool   `json:"upgrade_recommended" example:"true" doc:"Whether the plug is running firmware older than the latest known good version"`
+		}
+	}
+)
+
+func (apictx *APIContext) registerDescribePlugFirmware(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "DescribePlugFirmware",
+		Method:      http.MethodGet,
+		Path:        "/api/plugs/{ip}/firmware",
+		Summary:     "Describe a plug's firmware",
+		Description: "Compare the firmware version a plug is running against the latest known good version for its model.",
+		Tags:        []string{"Plugs"},
+		Security:    bearerAuth,
+		// Handler //
+	}, func(ctx context.Context, request *DescribePlugFirmwareRequest) (*DescribePlugFirmwareResponse, error) {
+		plug, err := apictx.lookupPlug(ctx, request.IP)
+		if err != nil {
+			return nil, err
+		}
+
+		suggestion, err := plug.CheckFirmware()
+		if err != nil {
+			return nil, plugUnreachableError("Could not retrieve plug firmware version", err)
+		}
+
+		resp := &DescribePlugFirmwareResponse{}
+		resp.Body.Current = suggestion.Current
+		resp.Body.Latest = suggestion.Latest
+		resp.Body.UpgradeRecommended = suggestion.UpgradeRecommended
+
+		return resp, nil
+	})
+}
+
+type (
+	DescribePlugNetworkRequest struct {
+		IP string `path:"ip" example:"192.168.1.20" doc:"The IP address or hostname of the target plug"`
+	}
+	DescribePlugNetworkResponse struct {
+		Body struct {
+			SSID        string `json:"ssid" example:"HomeNetwork" doc:"The wireless network the plug is connected to"`
+			RSSI        int    `json:"rssi" example:"-55" doc:"The received signal strength in dBm"`
+			IsConnected bool   `json:"is_connected" example:"true" doc:"Whether the plug reports being connected"`
+			MAC         string `json:"mac" example:"50:C7:BF:00:00:01" doc:"The MAC address of the plug"`
+			KeyType     int    `json:"key_type" example:"3" doc:"The wireless security type the plug is using"`
+		}
+	}
+)
+
+func (apictx *APIContext) registerDescribePlugNetwork(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "DescribePlugNetwork",
+		Method:      http.MethodGet,
+		Path:        "/api/plugs/{ip}/network",
+		Summary:     "Describe a plug's network connection",
+		Description: "Return details about the wireless network a plug is connected to.",
+		Tags:        []string{"Plugs"},
+		Security:    bearerAuth,
+		// Handler //
+	}, func(ctx context.Context, request *DescribePlugNetworkRequest) (*DescribePlugNetworkResponse, error) {
+		plug, err := apictx.lookupPlug(ctx, request.IP)
+		if err != nil {
+			return nil, err
+		}
+
+		info, err := plug.GetNetworkInfo()
+		if err != nil {
+			return nil, plugUnreachableError("Could not retrieve plug network info", err)
+		}
+
+		resp := &DescribePlugNetworkResponse{}
+		resp.Body.SSID = info.SSID
+		resp.Body.RSSI = info.RSSI
+		resp.Body.IsConnected = info.IsConnected
+		resp.Body.MAC = info.MAC
+		resp.Body.KeyType = info.KeyType
+
+		return resp, nil
+	})
+}
+
+type (
+	DescribePlugTimeRequest struct {
+		IP string `path:"ip" example:"192.168.1.20" doc:"The IP address or hostname of the target plug"`
+	}
+	DescribePlugTimeResponse struct {
+		Body struct {
+			Time      time.Time `json:"time" example:"2024-01-02T15:04:05Z" doc:"The current time according to the plug's clock"`
+			DriftSecs float64   `json:"drift_secs" example:"-12" doc:"How far the plug's clock is ahead (positive) or behind (negative) the server's"`
+		}
+	}
+)
+
+func (apictx *APIContext) registerDescribePlugTime(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "DescribePlugTime",
+		Method:      http.MethodGet,
+		Path:        "/api/plugs/{ip}/time",
+		Summary:     "Describe a plug's clock",
+		Description: "Return the current time according to the plug and how far it has drifted from the server's clock.",
+		Tags:        []string{"Plugs"},
+		Security:    bearerAuth,
+		// Handler //
+	}, func(ctx context.Context, request *DescribePlugTimeRequest) (*DescribePlugTimeResponse, error) {
+		plug, err := apictx.lookupPlug(ctx, request.IP)
+		if err != nil {
+			return nil, err
+		}
+
+		deviceTime, err := plug.GetDeviceTime()
+		if err != nil {
+			return nil, plugUnreachableError("Could not retrieve plug time", err)
+		}
+
+		resp := &DescribePlugTimeResponse{}
+		resp.Body.Time = deviceTime
+		resp.Body.DriftSecs = time.Until(deviceTime).Seconds()
+
+		return resp, nil
+	})
+}
+
+type (
+	SyncPlugTimeRequest struct {
+		IP string `path:"ip" example:"192.168.1.20" doc:"The IP address or hostname of the target plug"`
+	}
+	SyncPlugTimeResponse struct{}
+)
+
+func (apictx *APIContext) registerSyncPlugTime(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "SyncPlugTime",
+		Method:      http.MethodPost,
+		Path:        "/api/plugs/{ip}/time/sync",
+		Summary:     "Synchronise a plug's clock",
+		Description: "Set the plug's clock to the server's current time in UTC.",
+		Tags:        []string{"Plugs"},
+		Security:    bearerAuth,
+		// Handler //
+	}, func(ctx context.Context, request *SyncPlugTimeRequest) (*SyncPlugTimeResponse, error) {
+		err := requireAdmin(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		plug, err := apictx.lookupPlug(ctx, request.IP)
+		if err != nil {
+			return nil, err
+		}
+
+		err = plug.SyncTime()
+		if err != nil {
+			return nil, plugUnreachableError("Could not sync plug time", err)
+		}
+
+		return &SyncPlugTimeResponse{}, nil
+	})
+}
+
+// PlugDeviceSchedule is the API representation of a schedule rule stored on a plug.
+type PlugDeviceSchedule struct {
+	ID        string `json:"id" example:"C5D6A8F12B9A4DB2B1D6E2C4F0A1B3C4" doc:"The unique identifier the plug assigned the rule"`
+	Name      string `json:"name" example:"turn on at 07:30" doc:"The name of the rule"`
+	Enabled   bool   `json:"enabled" example:"true" doc:"Whether the rule is active"`
+	Weekdays  []int  `json:"weekdays" example:"[1,2,3,4,5]" doc:"The days the rule runs on; 0 is Sunday"`
+	StartTime string `json:"start_time" example:"07:30" doc:"The time of day the start action is performed"`
+	EndTime   string `json:"end_time,omitempty" example:"08:30" doc:"The time of day the opposite action is performed, if any"`
+	Action    string `json:"action" example:"on" enum:"on,off" doc:"The state the plug is set to at the start time"`
+}
+
+func fromDeviceScheduleRule(rule DeviceScheduleRule) PlugDeviceSchedule {
+	schedule := PlugDeviceSchedule{
+		ID:        rule.ID,
+		Name:      rule.Name,
+		Enabled:   int2bool(rule.Enable),
+		Weekdays:  []int{},
+		StartTime: fmt.Sprintf("%02d:%02d", rule.StartMin/60, rule.StartMin%60),
+		Action:    stateName(int2bool(rule.StartAction)),
+	}
+
+	for day, enabled := range rule.WDay {
+		if enabled == 1 {
+			schedule.Weekdays = append(schedule.Weekdays, day)
+		}
+	}
+
+	if rule.EndOpt != -1 {
+		schedule.EndTime = fmt.Sprintf("%02d:%02d", rule.EndMin/60, rule.EndMin%60)
+	}
+
+	return schedule
+}
+
+type (
+	ListPlugDeviceSchedulesRequest struct {
+		IP string `path:"ip" example:"192.168.1.20" doc:"The IP address or hostname of the target plug"`
+	}
+	ListPlugDeviceSchedulesResponse struct {
+		Body struct {
+			Schedules []PlugDeviceSchedule `json:"schedules" doc:"The schedule rules stored on the plug"`
+		}
+	}
+)
+
+func (apictx *APIContext) registerListPlugDeviceSchedules(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "ListPlugDeviceSchedules",
+		Method:      http.MethodGet,
+		Path:        "/api/plugs/{ip}/device-schedules",
+		Summary:     "List schedule rules stored on a plug",
+		Description: "Return the schedule rules stored on the plug itself. These run on the device even when this " +
+			"service is offline.",
+		Tags:     []string{"Plugs"},
+		Security: bearerAuth,
+		// Handler //
+	}, func(ctx context.Context, request *ListPlugDeviceSchedulesRequest) (*ListPlugDeviceSchedulesResponse, error) {
+		plug, err := apictx.lookupPlug(ctx, request.IP)
+		if err != nil {
+			return nil, err
+		}
+
+		rules, err := plug.ListDeviceScheduleRules()
+		if err != nil {
+			return nil, plugUnreachableError("Could not retrieve plug schedule rules", err)
+		}
+
+		resp := &ListPlugDeviceSchedulesResponse{}
+		resp.Body.Schedules = []PlugDeviceSchedule{}
+		for _, rule := range rules {
+			resp.Body.Schedules = append(resp.Body.Schedules, fromDeviceScheduleRule(rule))
+		}
+
+		return resp, nil
+	})
+}
+
+type (
+	CreatePlugDeviceScheduleRequest struct {
+		IP   string `path:"ip" example:"192.168.1.20" doc:"The IP address or hostname of the target plug"`
+		Body struct {
+			Weekdays  []int  `json:"weekdays" example:"[1,2,3,4,5]" minItems:"1" doc:"The days the rule runs on; 0 is Sunday"`
+			StartTime string `json:"start_time" example:"07:30" pattern:"^([01][0-9]|2[0-3]):[0-5][0-9]$" doc:"The time of day (HH:MM) to perform the action"`
+			EndTime   string `json:"end_time,omitempty" example:"08:30" pattern:"^([01][0-9]|2[0-3]):[0-5][0-9]$" doc:"An optional time of day (HH:MM) to perform the opposite action"`
+			Action    string `json:"action" example:"on" enum:"on,off" doc:"The state to set the plug to at the start time"`
+		}
+	}
+	CreatePlugDeviceScheduleResponse struct{}
+)
+
+func (apictx *APIContext) registerCreatePlugDeviceSchedule(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID:   "CreatePlugDeviceSchedule",
+		Method:        http.MethodPost,
+		Path:          "/api/plugs/{ip}/device-schedules",
+		Summary:       "Create a schedule rule on a plug",
+		Description:   "Store a weekly repeating schedule rule on the plug itself.",
+		Tags:          []string{"Plugs"},
+		Security:      bearerAuth,
+		DefaultStatus: http.StatusCreated,
+		// Handler //
+	}, func(ctx context.Context, request *CreatePlugDeviceScheduleRequest) (*CreatePlugDeviceScheduleResponse, error) {
+		err := requireAdmin(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		plug, err := apictx.lookupPlug(ctx, request.IP)
+		if err != nil {
+			return nil, err
+		}
+
+		startTime, endTime, action, err := parseDeviceSchedule(request.Body.Weekdays, request.Body.StartTime,
+			request.Body.EndTime, request.Body.Action)
+		if err != nil {
+			return nil, err
+		}
+
+		err = plug.CreateDeviceScheduleRule(request.Body.Weekdays, startTime, endTime, action)
+		if err != nil {
+			return nil, plugUnreachableError("Could not create plug schedule rule", err)
+		}
+
+		return &CreatePlugDeviceScheduleResponse{}, nil
+	})
+}
+
+// parseDeviceSchedule checks the fields of a device schedule request body and converts them into the arguments
+// CreateDeviceScheduleRule and EditDeviceScheduleRule take.
+func parseDeviceSchedule(weekdays []int, start, end, state string) (startTime, endTime time.Time, action int,
+	err error,
+) {
+	for _, day := range weekdays {
+		if day < 0 || day > 6 {
+			return time.Time{}, time.Time{}, 0, invalidInputError("Weekdays must be between 0 (Sunday) and 6 (Saturday)")
+		}
+	}
+
+	startTime, err = time.Parse("15:04", start)
+	if err != nil {
+		return time.Time{}, time.Time{}, 0, invalidInputError("Invalid start time", err)
+	}
+
+	if end != "" {
+		endTime, err = time.Parse("15:04", end)
+		if err != nil {
+			return time.Time{}, time.Time{}, 0, invalidInputError("Invalid end time", err)
+		}
+	}
+
+	if state == "on" {
+		action = 1
+	}
+
+	return startTime, endTime, action, nil
+}
+
+type (
+	UpdatePlugDeviceScheduleRequest struct {
+		IP   string `path:"ip" example:"192.168.1.20" doc:"The IP address or hostname of the target plug"`
+		ID   string `path:"id" example:"C5D6A8F12B9A4DB2B1D6E2C4F0A1B3C4" doc:"The identifier of the schedule rule"`
+		Body struct {
+			Weekdays  []int  `json:"weekdays" example:"[1,2,3,4,5]" minItems:"1" doc:"The days the rule runs on; 0 is Sunday"`
+			StartTime string `json:"start_time" example:"07:30" pattern:"^([01][0-9]|2[0-3]):[0-5][0-9]$" doc:"The time of day (HH:MM) to perform the action"`
+			EndTime   string `json:"end_time,omitempty" example:"08:30" pattern:"^([01][0-9]|2[0-3]):[0-5][0-9]$" doc:"An optional time of day (HH:MM) to perform the opposite action"`
+			Action    string `json:"action" example:"on" enum:"on,off" doc:"The state to set the plug to at the start time"`
+		}
+	}
+	UpdatePlugDeviceScheduleResponse struct{}
+)
+
+func (apictx *APIContext) registerUpdatePlugDeviceSchedule(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "UpdatePlugDeviceSchedule",
+		Method:      http.MethodPut,
+		Path:        "/api/plugs/{ip}/device-schedules/{id}",
+		Summary:     "Update a schedule rule on a plug",
+		Description: "Replace a schedule rule stored on the plug itself with a weekly repeating rule.",
+		Tags:        []string{"Plugs"},
+		Security:    bearerAuth,
+		// Handler //
+	}, func(ctx context.Context, request *UpdatePlugDeviceScheduleRequest) (*UpdatePlugDeviceScheduleResponse, error) {
+		err := requireAdmin(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		plug, err := apictx.lookupPlug(ctx, request.IP)
+		if err != nil {
+			return nil, err
+		}
+
+		startTime, endTime, action, err := parseDeviceSchedule(request.Body.Weekdays, request.Body.StartTime,
+			request.Body.EndTime, request.Body.Action)
+		if err != nil {
+			return nil, err
+		}
+
+		err = plug.EditDeviceScheduleRule(request.ID, request.Body.Weekdays, startTime, endTime, action)
+		if err != nil {
+			return nil, plugUnreachableError("Could not update plug schedule rule", err)
+		}
+
+		return &UpdatePlugDeviceScheduleResponse{}, nil
+	})
+}
+
+type (
+	DeletePlugDeviceScheduleRequest struct {
+		IP string `path:"ip" example:"192.168.1.20" doc:"The IP address or hostname of the target plug"`
+		ID string `path:"id" example:"C5D6A8F12B9A4DB2B1D6E2C4F0A1B3C4" doc:"The identifier of the schedule rule"`
+	}
+	DeletePlugDeviceScheduleResponse struct{}
+)
+
+func (apictx *APIContext) registerDeletePlugDeviceSchedule(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "DeletePlugDeviceSchedule",
+		Method:      http.MethodDelete,
+		Path:        "/api/plugs/{ip}/device-schedules/{id}",
+		Summary:     "Delete a schedule rule from a plug",
+		Description: "Remove a schedule rule stored on the plug itself.",
+		Tags:        []string{"Plugs"},
+		Security:    bearerAuth,
+		// Handler //
+	}, func(ctx context.Context, request *DeletePlugDeviceScheduleRequest) (*DeletePlugDeviceScheduleResponse, error) {
+		err := requireAdmin(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		plug, err := apictx.lookupPlug(ctx, request.IP)
+		if err != nil {
+			return nil, err
+		}
+
+		err = plug.DeleteDeviceScheduleRule(request.ID)
+		if err != nil {
+			return nil, plugUnreachableError("Could not delete plug schedule rule", err)
+		}
+
+		return &DeletePlugDeviceScheduleResponse{}, nil
+	})
+}
+
+// PlugMonthlyEnergy is the energy a plug used over a single month.
+type PlugMonthlyEnergy struct {
+	Month    int     `json:"month" example:"1" doc:"The month of the year, starting at 1 for January"`
+	EnergyWH float64 `json:"energy_wh" example:"12345" doc:"Energy used during the month in watt hours"`
+}
+
+type (
+	DescribePlugMonthlyEmeterRequest struct {
+		IP   string `path:"ip" example:"192.168.1.20" doc:"The IP address or hostname of the target plug"`
+		Year int    `query:"year" example:"2024" doc:"The year to return monthly usage for; defaults to the current year"`
+	}
+	DescribePlugMonthlyEmeterResponse struct {
+		Body struct {
+			Year   int                 `json:"year" example:"2024" doc:"The year the usage covers"`
+			Months []PlugMonthlyEnergy `json:"months" doc:"Energy usage for each month the plug has recorded data"`
+		}
+	}
+)
+
+func (apictx *APIContext) registerDescribePlugMonthlyEmeter(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "DescribePlugMonthlyEmeter",
+		Method:      http.MethodGet,
+		Path:        "/api/plugs/{ip}/emeter/monthly",
+		Summary:     "Describe monthly energy usage for a plug",
+		Description: "Return the energy used per month over a year for plugs with energy monitoring.",
+		Tags:        []string{"Plugs"},
+		Security:    bearerAuth,
+		// Handler //
+	}, func(ctx context.Context, request *DescribePlugMonthlyEmeterRequest) (*DescribePlugMonthlyEmeterResponse, error) {
+		plug, err := apictx.lookupPlug(ctx, request.IP)
+		if err != nil {
+			return nil, err
+		}
+
+		if !hasEmeter(plug.Model) {
+			return nil, huma.NewError(http.StatusMethodNotAllowed, "Plug does not support energy monitoring")
+		}
+
+		year := request.Year
+		if year == 0 {
+			year = time.Now().Year()
+		}
+
+		entries, err := plug.GetMonthlyEmeterStats(year)
+		if err != nil {
+			return nil, plugUnreachableError("Could not retrieve plug energy usage", err)
+		}
+
+		resp := &DescribePlugMonthlyEmeterResponse{}
+		resp.Body.Year = year
+		resp.Body.Months = []PlugMonthlyEnergy{}
+		for _, entry := range entries {
+			resp.Body.Months = append(resp.Body.Months, PlugMonthlyEnergy{
+				Month:    entry.Month,
+				EnergyWH: entry.EnergyWH,
+			})
+		}
+
+		return resp, nil
+	})
+}
+
+type (
+	DeletePlugEmeterStatsRequest struct {
+		IP string `path:"ip" example:"192.168.1.20" doc:"The IP address or hostname of the target plug"`
+	}
+	DeletePlugEmeterStatsResponse struct{}
+)
+
+func (apictx *APIContext) registerDeletePlugEmeterStats(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "DeletePlugEmeterStats",
+		Method:      http.MethodDelete,
+		Path:        "/api/plugs/{ip}/emeter/stats",
+		Summary:     "Erase energy usage history for a plug",
+		Description: "Clear all energy usage history recorded by a plug. Useful to start fresh accounting after " +
+			"replacing a device.",
+		Tags:     []string{"Plugs"},
+		Security: bearerAuth,
+		// Handler //
+	}, func(ctx context.Context, request *DeletePlugEmeterStatsRequest) (*DeletePlugEmeterStatsResponse, error) {
+		err := requireAdmin(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		plug, err := apictx.lookupPlug(ctx, request.IP)
+		if err != nil {
+			return nil, err
+		}
+
+		if !hasEmeter(plug.Model) {
+			return nil, huma.NewError(http.StatusMethodNotAllowed, "Plug does not support energy monitoring")
+		}
+
+		err = plug.EraseEmeterStats()
+		apictx.audit.Load().LogToggle(requesterIPFromContext(ctx), plug, AuditActionEraseEmeter, AuditSourceAPI, err == nil)
+		if err != nil {
+			return nil, plugUnreachableError("Could not erase plug energy usage", err)
+		}
+
+		log.Info().Str("plug", plug.Name).Str("ip", request.IP).Time("erased_at", time.Now()).
+			Msg("erased plug energy usage history")
+
+		return &DeletePlugEmeterStatsResponse{}, nil
+	})
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/clintjedwards/innerhaven/internal/config"
+)
+
+// TestPlugRegistryConcurrentAccess is meant to be run with -race. Plugs are never added to or removed from the
+// registry while the service runs; the only writes are failovers swapping a plug's addresses.
+func TestPlugRegistryConcurrentAccess(t *testing.T) {
+	plugs := processPlugConfig([]config.Plug{
+		{Address: "192.0.2.1", BackupAddress: "192.0.2.101"},
+		{Address: "192.0.2.2", BackupAddress: "192.0.2.102"},
+	})
+	apictx, handler := newTestAPI(t, nil, plugs...)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(4)
+
+		go func() {
+			defer wg.Done()
+			apictx.getPlug("192.0.2.1")
+			apictx.getPlug("192.0.2.102")
+		}()
+		go func() {
+			defer wg.Done()
+			for _, plug := range apictx.listPlugs() {
+				plug.addresses()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/plugs", nil))
+			if w.Code != http.StatusOK {
+				t.Errorf("listing plugs: status = %d; body: %s", w.Code, w.Body)
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			plugs[i%len(plugs)].swapAddresses()
+		}(i)
+	}
+	wg.Wait()
+
+	// Every plug was swapped an even number of times, so each is back on its primary address.
+	for _, address := range []string{"192.0.2.1", "192.0.2.2"} {
+		if _, exists := apictx.getPlug(address); !exists {
+			t.Errorf("plug %s not found after failovers", address)
+		}
+	}
+}
+
+// testPlugSummaries returns summaries for count made up plugs.
+func testPlugSummaries(count int) []PlugSummary {
+	plugs := make([]PlugSummary, 0, count)
+	for i := 0; i < count; i++ {
+		plugs = append(plugs, PlugSummary{
+			Name:        fmt.Sprintf("Plug %d", i),
+			Description: "A plug in a large deployment",
+			Address:     fmt.Sprintf("10.0.%d.%d", i/256, i%256),
+			Model:       "HS105(US)",
+			On:          i%2 == 0,
+			Online:      true,
+		})
+	}
+	return plugs
+}
+
+func TestWritePlugList(t *testing.T) {
+	plugs := testPlugSummaries(3)
+
+	var buf bytes.Buffer
+	err := writePlugList(&buf, plugs)
+	if err != nil {
+		t.Fatalf("could not write plug list: %v", err)
+	}
+
+	var got ListPlugsResponseBody
+	err = json.Unmarshal(buf.Bytes(), &got)
+	if err != nil {
+		t.Fatalf("streamed plug list is not valid JSON: %v\n%s", err, buf.String())
+	}
+
+	want, _ := json.Marshal(ListPlugsResponseBody{Plugs: plugs})
+	gotJSON, _ := json.Marshal(got)
+	if !bytes.Equal(gotJSON, want) {
+		t.Errorf("streamed plug list = %s, want %s", gotJSON, want)
+	}
+}
+
+// BenchmarkPlugList compares the allocations of marshaling the whole plug list with streaming it one plug at a time.
+func BenchmarkPlugList(b *testing.B) {
+	plugs := testPlugSummaries(500)
+
+	b.Run("marshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			out, err := json.Marshal(ListPlugsResponseBody{Plugs: plugs})
+			if err != nil {
+				b.Fatal(err)
+			}
+			_, _ = io.Discard.Write(out)
+		}
+	})
+
+	b.Run("stream", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			err := writePlugList(io.Discard, plugs)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestUpdatePlugDeviceSchedule(t *testing.T) {
+	fake := newFakePlug(t)
+	p := fake.plug("127.0.0.1")
+	_, handler := newTestAPI(t, nil, p)
+
+	body := `{"weekdays":[1,5],"start_time":"07:30","end_time":"08:15","action":"off"}`
+	r := httptest.NewRequest(http.MethodPut, "/api/plugs/127.0.0.1/device-schedules/ABC123", bytes.NewBufferString(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code >= 300 {
+		t.Fatalf("status = %d, want success\n%s", w.Code, w.Body)
+	}
+
+	commands := fake.commands()
+	if len(commands) != 1 {
+		t.Fatalf("fake plug received %q, want one command", commands)
+	}
+
+	var sent struct {
+		Schedule struct {
+			EditRule *DeviceScheduleRule `json:"edit_rule"`
+		} `json:"schedule"`
+	}
+	err := json.Unmarshal([]byte(commands[0]), &sent)
+	if err != nil || sent.Schedule.EditRule == nil {
+		t.Fatalf("command %s is not a schedule.edit_rule; %v", commands[0], err)
+	}
+
+	rule := *sent.Schedule.EditRule
+	want := DeviceScheduleRule{
+		ID:          "ABC123",
+		Name:        "turn off at 07:30",
+		Enable:      1,
+		WDay:        []int{0, 1, 0, 0, 0, 1, 0},
+		Repeat:      1,
+		StartMin:    7*60 + 30,
+		StartAction: 0,
+		EndMin:      8*60 + 15,
+		EndAction:   1,
+	}
+	if !reflect.DeepEqual(rule, want) {
+		t.Errorf("rule = %+v, want %+v", rule, want)
+	}
+}
+
+func TestBeginAPICommand(t *testing.T) {
+	const window = time.Hour
+
+	p := newPlug("192.0.2.1", 0)
+
+	finishOn, duplicate := p.beginAPICommand(true, window)
+	if duplicate {
+		t.Fatal("first command reported as a duplicate")
+	}
+	if _, duplicate := p.beginAPICommand(true, window); !duplicate {
+		t.Error("command for the state a command in flight switches to was not reported as a duplicate")
+	}
+
+	finishOff, duplicate := p.beginAPICommand(false, window)
+	if duplicate {
+		t.Fatal("command for a different state reported as a duplicate")
+	}
+
+	// The first command finishing must not end the newer one.
+	finishOn(true)
+	if _, duplicate := p.beginAPICommand(false, window); !duplicate {
+		t.Error("command for the state a command in flight switches to was not reported as a duplicate")
+	}
+
+	finishOff(true)
+	if _, duplicate := p.beginAPICommand(false, window); !duplicate {
+		t.Error("command repeating one that just finished was not reported as a duplicate")
+	}
+
+	// A failed command is forgotten.
+	finish, _ := p.beginAPICommand(true, window)
+	finish(false)
+	if _, duplicate := p.beginAPICommand(true, window); duplicate {
+		t.Error("retry of a failed command reported as a duplicate")
+	}
+
+	if _, duplicate := p.beginAPICommand(true, 0); duplicate {
+		t.Error("command reported as a duplicate with debouncing turned off")
+	}
+}
+
+func TestDuplicateToggle(t *testing.T) {
+	fake := newFakePlug(t)
+	p := fake.plug("127.0.0.1")
+	conf := config.DefaultAPIConfig()
+	conf.CommandDebounceWindow = time.Hour
+	apictx, _ := newTestAPI(t, conf, p)
+
+	// Hold the plug's command lock so that the first toggle is still in flight when the second arrives.
+	p.cmdLock.lock(context.Background(), PriorityHigh)
+
+	results := make(chan bool, 2)
+	toggle := func() {
+		body, err := apictx.changePlugState(context.Background(), p, false, true, false)
+		if err != nil {
+			t.Errorf("toggle failed: %v", err)
+			results <- false
+			return
+		}
+		results <- body.On
+	}
+
+	go toggle()
+	waitForQueuedCommands(t, p, 1)
+	go toggle()
+
+	// The duplicate waits for the first toggle to settle; give it the chance to be sent as well if it were going
+	// to be.
+	time.Sleep(50 * time.Millisecond)
+	p.cmdLock.unlock()
+
+	for i := 0; i < 2; i++ {
+		if on := <-results; !on {
+			t.Errorf("toggle %d answered off, want on", i+1)
+		}
+	}
+	if commands := fake.commands(); len(commands) != 1 {
+		t.Fatalf("fake plug received %q, want one command for both toggles", commands)
+	}
+
+	// Once the first toggle is done, another one switches the plug back rather than repeating it.
+	body, err := apictx.changePlugState(context.Background(), p, false, true, false)
+	if err != nil {
+		t.Fatalf("toggle failed: %v", err)
+	}
+	if commands := fake.commands(); body.On || len(commands) != 1 {
+		t.Errorf("toggle after the first finished: on = %v with commands %q, want it sent and off", body.On, commands)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// prettyWriter marks a response body writer whose JSON should be indented because the request asked for it with
+// ?pretty=true.
+type prettyWriter struct {
+	io.Writer
+}
+
+// Flush passes flushes through so that streaming responses keep working when pretty printing is asked for.
+func (w prettyWriter) Flush() {
+	if flusher, ok := w.Writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// humaContext lets huma.Context be embedded without its field name clashing with its Context method.
+type humaContext = huma.Context
+
+// prettyContext hands operations a body writer marked for pretty printing.
+type prettyContext struct {
+	humaContext
+}
+
+func (c prettyContext) BodyWriter() io.Writer {
+	return prettyWriter{c.humaContext.BodyWriter()}
+}
+
+// prettyPrintMiddleware marks the response for pretty printing when the request has ?pretty=true.
+func prettyPrintMiddleware(ctx huma.Context, next func(huma.Context)) {
+	if ctx.Query("pretty") == "true" {
+		ctx = prettyContext{ctx}
+	}
+
+	next(ctx)
+}
+
+// prettyPrint reports whether JSON written to w should be indented.
+func (apictx *APIContext) prettyPrint(w io.Writer) bool {
+	_, requested := w.(prettyWriter)
+	return requested || apictx.config.Response.PrettyPrint
+}
+
+// jsonFormat marshals JSON response bodies, indenting them when response.pretty_print is set or the request asked
+// for it.
+func (apictx *APIContext) jsonFormat() huma.Format {
+	return huma.Format{
+		Marshal: func(w io.Writer, v any) error {
+			encoder := json.NewEncoder(w)
+			if apictx.prettyPrint(w) {
+				encoder.SetIndent("", "  ")
+			}
+
+			return encoder.Encode(v)
+		},
+		Unmarshal: json.Unmarshal,
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+)
+
+// commandPriority orders commands waiting for their turn at the same plug. Higher priorities go first; commands of
+// the same priority go in the order they started waiting.
+type commandPriority int
+
+const (
+	// PriorityLow is for background work like health probes and state confirmation.
+	PriorityLow commandPriority = iota
+	// PriorityNormal is for automated changes like rules and for anything that doesn't say otherwise.
+	PriorityNormal
+	// PriorityHigh is for changes a person asked for through the keyboard or the API.
+	PriorityHigh
+)
+
+// commandLock serializes the commands sent to a plug. Unlike a sync.Mutex, when it is released it is handed to the
+// highest priority waiter so that user initiated commands never sit behind a storm of background polling.
+type commandLock struct {
+	mu      sync.Mutex
+	held    bool
+	seq     uint64
+	waiting commandWaiters
+}
+
+type commandWaiter struct {
+	priority commandPriority
+	seq      uint64
+	ready    chan struct{}
+	// index is the waiter's position in the heap, or -1 once it has been handed the lock.
+	index int
+}
+
+// lock blocks until the caller holds the lock. If ctx is done first the caller stops waiting and gets ctx's error
+// instead, without holding the lock.
+func (l *commandLock) lock(ctx context.Context, priority commandPriority) error {
+	l.mu.Lock()
+	if !l.held {
+		l.held = true
+		l.mu.Unlock()
+		return nil
+	}
+
+	l.seq++
+	waiter := &commandWaiter{priority: priority, seq: l.seq, ready: make(chan struct{})}
+	heap.Push(&l.waiting, waiter)
+	l.mu.Unlock()
+
+	select {
+	case <-waiter.ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// The lock may have been handed over just as ctx was done; if so it is passed on.
+	if waiter.index < 0 {
+		l.handOffLocked()
+	} else {
+		heap.Remove(&l.waiting, waiter.index)
+	}
+
+	return ctx.Err()
+}
+
+// unlock hands the lock straight to the highest priority waiter, if there is one.
+func (l *commandLock) unlock() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.handOffLocked()
+}
+
+// handOffLocked gives the held lock to the highest priority waiter or releases it if nobody is waiting. The caller
+// must hold mu.
+func (l *commandLock) handOffLocked() {
+	if l.waiting.Len() == 0 {
+		l.held = false
+		return
+	}
+
+	close(heap.Pop(&l.waiting).(*commandWaiter).ready)
+}
+
+// commandWaiters is a heap of waiters with the highest priority, then the longest waiting, on top.
+type commandWaiters []*commandWaiter
+
+func (w commandWaiters) Len() int { return len(w) }
+
+func (w commandWaiters) Less(i, j int) bool {
+	if w[i].priority != w[j].priority {
+		return w[i].priority > w[j].priority
+	}
+	return w[i].seq < w[j].seq
+}
+
+func (w commandWaiters) Swap(i, j int) {
+	w[i], w[j] = w[j], w[i]
+	w[i].index = i
+	w[j].index = j
+}
+
+func (w *commandWaiters) Push(x any) {
+	waiter := x.(*commandWaiter)
+	waiter.index = len(*w)
+	*w = append(*w, waiter)
+}
+
+func (w *commandWaiters) Pop() any {
+	old := *w
+	waiter := old[len(old)-1]
+	old[len(old)-1] = nil
+	waiter.index = -1
+	*w = old[:len(old)-1]
+	return waiter
+}
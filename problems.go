@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// Error responses are problem details (RFC 7807) served as application/problem+json. The type field holds one of
+// the URIs below so that clients can tell classes of errors apart without parsing the human readable detail.
+const (
+	ProblemTypePlugNotFound      = "urn:innerhaven:problem:plug-not-found"
+	ProblemTypePlugUnreachable   = "urn:innerhaven:problem:plug-unreachable"
+	ProblemTypeInvalidInput      = "urn:innerhaven:problem:invalid-input"
+	ProblemTypeRateLimitExceeded = "urn:innerhaven:problem:rate-limit-exceeded"
+)
+
+// problemTypeBlank is the type of errors that don't fall into one of our classes; the status code alone describes
+// them.
+const problemTypeBlank = "about:blank"
+
+// withProblemType sets the problem type of an error built by one of huma's error constructors.
+func withProblemType(err huma.StatusError, problemType string) huma.StatusError {
+	var problem *huma.ErrorModel
+	if errors.As(err, &problem) {
+		problem.Type = problemType
+	}
+	return err
+}
+
+// plugNotFoundError is returned when a request names a plug that isn't configured.
+func plugNotFoundError(msg string) huma.StatusError {
+	return withProblemType(huma.Error404NotFound(msg), ProblemTypePlugNotFound)
+}
+
+// plugUnreachableError is returned when a command could not be delivered to a plug or the plug answered with an
+// error.
+func plugUnreachableError(msg string, errs ...error) huma.StatusError {
+	return withProblemType(huma.Error502BadGateway(msg, errs...), ProblemTypePlugUnreachable)
+}
+
+// invalidInputError is returned when a request is well formed but its values don't make sense together.
+func invalidInputError(msg string, errs ...error) huma.StatusError {
+	return withProblemType(huma.Error400BadRequest(msg, errs...), ProblemTypeInvalidInput)
+}
+
+// problemTransformer fills in the type and instance of every error returned by a huma handler. huma already
+// encodes its errors as problem details; it just has no way of knowing our type URIs or the request path.
+func problemTransformer(ctx huma.Context, _ string, v any) (any, error) {
+	problem, ok := v.(*huma.ErrorModel)
+	if !ok {
+		return v, nil
+	}
+
+	if problem.Type == "" {
+		problem.Type = problemTypeBlank
+
+		// Errors our handlers raise are typed where they're created, so an untyped error with details attached
+		// is huma rejecting a request that didn't parse or validate.
+		if len(problem.Errors) > 0 {
+			problem.Type = ProblemTypeInvalidInput
+		}
+	}
+	if problem.Instance == "" {
+		problem.Instance = ctx.URL().Path
+	}
+
+	return problem, nil
+}
+
+// writeProblem writes a problem details response for errors raised outside of huma, such as in middleware.
+func writeProblem(w http.ResponseWriter, r *http.Request, problemType string, status int, detail string) {
+	problem := huma.ErrorModel{
+		Type:     problemType,
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(problem)
+}
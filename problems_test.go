@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/clintjedwards/innerhaven/internal/config"
+)
+
+// serveProblem sends the request through the handler and decodes the problem it answers with, failing the test
+// unless the response is application/problem+json with every required field set.
+func serveProblem(t *testing.T, handler http.Handler, r *http.Request) map[string]any {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	contentType := w.Header().Get("Content-Type")
+	if contentType != "application/problem+json" {
+		t.Fatalf("Content-Type = %q, want application/problem+json; body: %s", contentType, w.Body)
+	}
+
+	problem := map[string]any{}
+	err := json.Unmarshal(w.Body.Bytes(), &problem)
+	if err != nil {
+		t.Fatalf("could not decode problem: %v", err)
+	}
+
+	for _, field := range []string{"type", "title", "status", "detail", "instance"} {
+		if _, ok := problem[field]; !ok {
+			t.Errorf("problem is missing %q: %s", field, w.Body)
+		}
+	}
+	if status := int(problem["status"].(float64)); status != w.Code {
+		t.Errorf("problem status = %d, response status = %d", status, w.Code)
+	}
+	if problem["instance"] != r.URL.Path {
+		t.Errorf("problem instance = %v, want %q", problem["instance"], r.URL.Path)
+	}
+
+	return problem
+}
+
+func TestProblemTypes(t *testing.T) {
+	// Nothing listens on port 1, so commands to this plug fail straight away.
+	unreachable := processPlugConfig([]config.Plug{{Address: "127.0.0.1", Port: 1}})
+
+	_, handler := newTestAPI(t, nil, unreachable...)
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		status   int
+		wantType string
+	}{
+		{
+			name:     "unknown plug",
+			method:   http.MethodGet,
+			path:     "/api/plugs/192.0.2.1",
+			status:   http.StatusNotFound,
+			wantType: ProblemTypePlugNotFound,
+		},
+		{
+			name:     "unreachable plug",
+			method:   http.MethodPost,
+			path:     "/api/plugs/127.0.0.1/on",
+			status:   http.StatusBadGateway,
+			wantType: ProblemTypePlugUnreachable,
+		},
+		{
+			name:   "inconsistent bounds",
+			method: http.MethodPost,
+			path:   "/api/vacation-mode/start",
+			body: `{"plug_ips": ["127.0.0.1"], "min_on_secs": 10, "max_on_secs": 5, "min_off_secs": 1, ` +
+				`"max_off_secs": 1}`,
+			status:   http.StatusBadRequest,
+			wantType: ProblemTypeInvalidInput,
+		},
+		{
+			name:     "failed validation",
+			method:   http.MethodPost,
+			path:     "/api/sequences/play",
+			body:     `{"events": []}`,
+			status:   http.StatusUnprocessableEntity,
+			wantType: ProblemTypeInvalidInput,
+		},
+		{
+			name:     "unsupported feature",
+			method:   http.MethodPost,
+			path:     "/api/plugs/127.0.0.1/color",
+			body:     `{"hue": 0, "saturation": 0}`,
+			status:   http.StatusMethodNotAllowed,
+			wantType: problemTypeBlank,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			r.Header.Set("Content-Type", "application/json")
+
+			problem := serveProblem(t, handler, r)
+
+			if status := int(problem["status"].(float64)); status != tc.status {
+				t.Errorf("status = %d, want %d", status, tc.status)
+			}
+			if problem["type"] != tc.wantType {
+				t.Errorf("type = %v, want %q", problem["type"], tc.wantType)
+			}
+		})
+	}
+}
+
+func TestWriteProblem(t *testing.T) {
+	conf := config.DefaultAPIConfig()
+	conf.AdminToken = "admin"
+
+	_, handler := newTestAPI(t, conf)
+
+	r := httptest.NewRequest(http.MethodGet, "/api/plugs", nil)
+	problem := serveProblem(t, handler, r)
+
+	if problem["type"] != problemTypeBlank {
+		t.Errorf("type = %v, want %q", problem["type"], problemTypeBlank)
+	}
+	if problem["title"] != http.StatusText(http.StatusUnauthorized) {
+		t.Errorf("title = %v, want %q", problem["title"], http.StatusText(http.StatusUnauthorized))
+	}
+}
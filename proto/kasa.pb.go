@@ -0,0 +1,608 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.33.0
+// 	protoc        (unknown)
+// source: kasa.proto
+
+package proto
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Plug struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Name    string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Address string `protobuf:"bytes,2,opt,name=address,proto3" json:"address,omitempty"`
+	Model   string `protobuf:"bytes,3,opt,name=model,proto3" json:"model,omitempty"`
+	On      bool   `protobuf:"varint,4,opt,name=on,proto3" json:"on,omitempty"`
+	Online  bool   `protobuf:"varint,5,opt,name=online,proto3" json:"online,omitempty"`
+}
+
+func (x *Plug) Reset() {
+	*x = Plug{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kasa_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Plug) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Plug) ProtoMessage() {}
+
+func (x *Plug) ProtoReflect() protoreflect.Message {
+	mi := &file_kasa_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Plug.ProtoReflect.Descriptor instead.
+func (*Plug) Descriptor() ([]byte, []int) {
+	return file_kasa_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Plug) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Plug) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+func (x *Plug) GetModel() string {
+	if x != nil {
+		return x.Model
+	}
+	return ""
+}
+
+func (x *Plug) GetOn() bool {
+	if x != nil {
+		return x.On
+	}
+	return false
+}
+
+func (x *Plug) GetOnline() bool {
+	if x != nil {
+		return x.Online
+	}
+	return false
+}
+
+type ListPlugsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *ListPlugsRequest) Reset() {
+	*x = ListPlugsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kasa_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ListPlugsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListPlugsRequest) ProtoMessage() {}
+
+func (x *ListPlugsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kasa_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListPlugsRequest.ProtoReflect.Descriptor instead.
+func (*ListPlugsRequest) Descriptor() ([]byte, []int) {
+	return file_kasa_proto_rawDescGZIP(), []int{1}
+}
+
+type ListPlugsResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Plugs []*Plug `protobuf:"bytes,1,rep,name=plugs,proto3" json:"plugs,omitempty"`
+}
+
+func (x *ListPlugsResponse) Reset() {
+	*x = ListPlugsResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kasa_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ListPlugsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListPlugsResponse) ProtoMessage() {}
+
+func (x *ListPlugsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kasa_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListPlugsResponse.ProtoReflect.Descriptor instead.
+func (*ListPlugsResponse) Descriptor() ([]byte, []int) {
+	return file_kasa_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ListPlugsResponse) GetPlugs() []*Plug {
+	if x != nil {
+		return x.Plugs
+	}
+	return nil
+}
+
+type TogglePlugRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// The IP address or hostname of the target plug.
+	Ip string `protobuf:"bytes,1,opt,name=ip,proto3" json:"ip,omitempty"`
+}
+
+func (x *TogglePlugRequest) Reset() {
+	*x = TogglePlugRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kasa_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *TogglePlugRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TogglePlugRequest) ProtoMessage() {}
+
+func (x *TogglePlugRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kasa_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TogglePlugRequest.ProtoReflect.Descriptor instead.
+func (*TogglePlugRequest) Descriptor() ([]byte, []int) {
+	return file_kasa_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *TogglePlugRequest) GetIp() string {
+	if x != nil {
+		return x.Ip
+	}
+	return ""
+}
+
+type TogglePlugResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Whether the plug is now switched on.
+	On bool `protobuf:"varint,1,opt,name=on,proto3" json:"on,omitempty"`
+}
+
+func (x *TogglePlugResponse) Reset() {
+	*x = TogglePlugResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kasa_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *TogglePlugResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TogglePlugResponse) ProtoMessage() {}
+
+func (x *TogglePlugResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kasa_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TogglePlugResponse.ProtoReflect.Descriptor instead.
+func (*TogglePlugResponse) Descriptor() ([]byte, []int) {
+	return file_kasa_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *TogglePlugResponse) GetOn() bool {
+	if x != nil {
+		return x.On
+	}
+	return false
+}
+
+type StreamStateRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *StreamStateRequest) Reset() {
+	*x = StreamStateRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kasa_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StreamStateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamStateRequest) ProtoMessage() {}
+
+func (x *StreamStateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kasa_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamStateRequest.ProtoReflect.Descriptor instead.
+func (*StreamStateRequest) Descriptor() ([]byte, []int) {
+	return file_kasa_proto_rawDescGZIP(), []int{5}
+}
+
+type PlugStateEvent struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Increases by one with every event.
+	Seq uint64 `protobuf:"varint,1,opt,name=seq,proto3" json:"seq,omitempty"`
+	// Time of the state change in epoch milliseconds.
+	Time     int64  `protobuf:"varint,2,opt,name=time,proto3" json:"time,omitempty"`
+	PlugName string `protobuf:"bytes,3,opt,name=plug_name,json=plugName,proto3" json:"plug_name,omitempty"`
+	PlugIp   string `protobuf:"bytes,4,opt,name=plug_ip,json=plugIp,proto3" json:"plug_ip,omitempty"`
+	// The state the plug was left in.
+	On bool `protobuf:"varint,5,opt,name=on,proto3" json:"on,omitempty"`
+	// What caused the state change; one of api, keyboard, rule or webhook.
+	Source string `protobuf:"bytes,6,opt,name=source,proto3" json:"source,omitempty"`
+}
+
+func (x *PlugStateEvent) Reset() {
+	*x = PlugStateEvent{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kasa_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *PlugStateEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PlugStateEvent) ProtoMessage() {}
+
+func (x *PlugStateEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_kasa_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PlugStateEvent.ProtoReflect.Descriptor instead.
+func (*PlugStateEvent) Descriptor() ([]byte, []int) {
+	return file_kasa_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *PlugStateEvent) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *PlugStateEvent) GetTime() int64 {
+	if x != nil {
+		return x.Time
+	}
+	return 0
+}
+
+func (x *PlugStateEvent) GetPlugName() string {
+	if x != nil {
+		return x.PlugName
+	}
+	return ""
+}
+
+func (x *PlugStateEvent) GetPlugIp() string {
+	if x != nil {
+		return x.PlugIp
+	}
+	return ""
+}
+
+func (x *PlugStateEvent) GetOn() bool {
+	if x != nil {
+		return x.On
+	}
+	return false
+}
+
+func (x *PlugStateEvent) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+var File_kasa_proto protoreflect.FileDescriptor
+
+var file_kasa_proto_rawDesc = []byte{
+	0x0a, 0x0a, 0x6b, 0x61, 0x73, 0x61, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x04, 0x6b, 0x61,
+	0x73, 0x61, 0x22, 0x72, 0x0a, 0x04, 0x50, 0x6c, 0x75, 0x67, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61,
+	0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x18,
+	0x0a, 0x07, 0x61, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x07, 0x61, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x12, 0x14, 0x0a, 0x05, 0x6d, 0x6f, 0x64, 0x65,
+	0x6c, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x6d, 0x6f, 0x64, 0x65, 0x6c, 0x12, 0x0e,
+	0x0a, 0x02, 0x6f, 0x6e, 0x18, 0x04, 0x20, 0x01, 0x28, 0x08, 0x52, 0x02, 0x6f, 0x6e, 0x12, 0x16,
+	0x0a, 0x06, 0x6f, 0x6e, 0x6c, 0x69, 0x6e, 0x65, 0x18, 0x05, 0x20, 0x01, 0x28, 0x08, 0x52, 0x06,
+	0x6f, 0x6e, 0x6c, 0x69, 0x6e, 0x65, 0x22, 0x12, 0x0a, 0x10, 0x4c, 0x69, 0x73, 0x74, 0x50, 0x6c,
+	0x75, 0x67, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x22, 0x35, 0x0a, 0x11, 0x4c, 0x69,
+	0x73, 0x74, 0x50, 0x6c, 0x75, 0x67, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12,
+	0x20, 0x0a, 0x05, 0x70, 0x6c, 0x75, 0x67, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0a,
+	0x2e, 0x6b, 0x61, 0x73, 0x61, 0x2e, 0x50, 0x6c, 0x75, 0x67, 0x52, 0x05, 0x70, 0x6c, 0x75, 0x67,
+	0x73, 0x22, 0x23, 0x0a, 0x11, 0x54, 0x6f, 0x67, 0x67, 0x6c, 0x65, 0x50, 0x6c, 0x75, 0x67, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x70, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x02, 0x69, 0x70, 0x22, 0x24, 0x0a, 0x12, 0x54, 0x6f, 0x67, 0x67, 0x6c, 0x65,
+	0x50, 0x6c, 0x75, 0x67, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x0e, 0x0a, 0x02,
+	0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x02, 0x6f, 0x6e, 0x22, 0x14, 0x0a, 0x12,
+	0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x53, 0x74, 0x61, 0x74, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x22, 0x94, 0x01, 0x0a, 0x0e, 0x50, 0x6c, 0x75, 0x67, 0x53, 0x74, 0x61, 0x74, 0x65,
+	0x45, 0x76, 0x65, 0x6e, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x73, 0x65, 0x71, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x04, 0x52, 0x03, 0x73, 0x65, 0x71, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x12, 0x1b, 0x0a, 0x09, 0x70,
+	0x6c, 0x75, 0x67, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08,
+	0x70, 0x6c, 0x75, 0x67, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x17, 0x0a, 0x07, 0x70, 0x6c, 0x75, 0x67,
+	0x5f, 0x69, 0x70, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x70, 0x6c, 0x75, 0x67, 0x49,
+	0x70, 0x12, 0x0e, 0x0a, 0x02, 0x6f, 0x6e, 0x18, 0x05, 0x20, 0x01, 0x28, 0x08, 0x52, 0x02, 0x6f,
+	0x6e, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x18, 0x06, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x32, 0xcd, 0x01, 0x0a, 0x0b, 0x4b, 0x61,
+	0x73, 0x61, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x3c, 0x0a, 0x09, 0x4c, 0x69, 0x73,
+	0x74, 0x50, 0x6c, 0x75, 0x67, 0x73, 0x12, 0x16, 0x2e, 0x6b, 0x61, 0x73, 0x61, 0x2e, 0x4c, 0x69,
+	0x73, 0x74, 0x50, 0x6c, 0x75, 0x67, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x17,
+	0x2e, 0x6b, 0x61, 0x73, 0x61, 0x2e, 0x4c, 0x69, 0x73, 0x74, 0x50, 0x6c, 0x75, 0x67, 0x73, 0x52,
+	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x3f, 0x0a, 0x0a, 0x54, 0x6f, 0x67, 0x67, 0x6c,
+	0x65, 0x50, 0x6c, 0x75, 0x67, 0x12, 0x17, 0x2e, 0x6b, 0x61, 0x73, 0x61, 0x2e, 0x54, 0x6f, 0x67,
+	0x67, 0x6c, 0x65, 0x50, 0x6c, 0x75, 0x67, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x18,
+	0x2e, 0x6b, 0x61, 0x73, 0x61, 0x2e, 0x54, 0x6f, 0x67, 0x67, 0x6c, 0x65, 0x50, 0x6c, 0x75, 0x67,
+	0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x3f, 0x0a, 0x0b, 0x53, 0x74, 0x72, 0x65,
+	0x61, 0x6d, 0x53, 0x74, 0x61, 0x74, 0x65, 0x12, 0x18, 0x2e, 0x6b, 0x61, 0x73, 0x61, 0x2e, 0x53,
+	0x74, 0x72, 0x65, 0x61, 0x6d, 0x53, 0x74, 0x61, 0x74, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x1a, 0x14, 0x2e, 0x6b, 0x61, 0x73, 0x61, 0x2e, 0x50, 0x6c, 0x75, 0x67, 0x53, 0x74, 0x61,
+	0x74, 0x65, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x30, 0x01, 0x42, 0x2b, 0x5a, 0x29, 0x67, 0x69, 0x74,
+	0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x63, 0x6c, 0x69, 0x6e, 0x74, 0x6a, 0x65, 0x64,
+	0x77, 0x61, 0x72, 0x64, 0x73, 0x2f, 0x69, 0x6e, 0x6e, 0x65, 0x72, 0x68, 0x61, 0x76, 0x65, 0x6e,
+	0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_kasa_proto_rawDescOnce sync.Once
+	file_kasa_proto_rawDescData = file_kasa_proto_rawDesc
+)
+
+func file_kasa_proto_rawDescGZIP() []byte {
+	file_kasa_proto_rawDescOnce.Do(func() {
+		file_kasa_proto_rawDescData = protoimpl.X.CompressGZIP(file_kasa_proto_rawDescData)
+	})
+	return file_kasa_proto_rawDescData
+}
+
+var file_kasa_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_kasa_proto_goTypes = []interface{}{
+	(*Plug)(nil),               // 0: kasa.Plug
+	(*ListPlugsRequest)(nil),   // 1: kasa.ListPlugsRequest
+	(*ListPlugsResponse)(nil),  // 2: kasa.ListPlugsResponse
+	(*TogglePlugRequest)(nil),  // 3: kasa.TogglePlugRequest
+	(*TogglePlugResponse)(nil), // 4: kasa.TogglePlugResponse
+	(*StreamStateRequest)(nil), // 5: kasa.StreamStateRequest
+	(*PlugStateEvent)(nil),     // 6: kasa.PlugStateEvent
+}
+var file_kasa_proto_depIdxs = []int32{
+	0, // 0: kasa.ListPlugsResponse.plugs:type_name -> kasa.Plug
+	1, // 1: kasa.KasaService.ListPlugs:input_type -> kasa.ListPlugsRequest
+	3, // 2: kasa.KasaService.TogglePlug:input_type -> kasa.TogglePlugRequest
+	5, // 3: kasa.KasaService.StreamState:input_type -> kasa.StreamStateRequest
+	2, // 4: kasa.KasaService.ListPlugs:output_type -> kasa.ListPlugsResponse
+	4, // 5: kasa.KasaService.TogglePlug:output_type -> kasa.TogglePlugResponse
+	6, // 6: kasa.KasaService.StreamState:output_type -> kasa.PlugStateEvent
+	4, // [4:7] is the sub-list for method output_type
+	1, // [1:4] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_kasa_proto_init() }
+func file_kasa_proto_init() {
+	if File_kasa_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_kasa_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Plug); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kasa_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ListPlugsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kasa_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ListPlugsResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kasa_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*TogglePlugRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kasa_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*TogglePlugResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kasa_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StreamStateRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kasa_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*PlugStateEvent); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_kasa_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_kasa_proto_goTypes,
+		DependencyIndexes: file_kasa_proto_depIdxs,
+		MessageInfos:      file_kasa_proto_msgTypes,
+	}.Build()
+	File_kasa_proto = out.File
+	file_kasa_proto_rawDesc = nil
+	file_kasa_proto_goTypes = nil
+	file_kasa_proto_depIdxs = nil
+}
@@ -0,0 +1,217 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: kasa.proto
+
+package proto
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	KasaService_ListPlugs_FullMethodName   = "/kasa.KasaService/ListPlugs"
+	KasaService_TogglePlug_FullMethodName  = "/kasa.KasaService/TogglePlug"
+	KasaService_StreamState_FullMethodName = "/kasa.KasaService/StreamState"
+)
+
+// KasaServiceClient is the client API for KasaService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type KasaServiceClient interface {
+	// ListPlugs returns all managed plugs and their current state.
+	ListPlugs(ctx context.Context, in *ListPlugsRequest, opts ...grpc.CallOption) (*ListPlugsResponse, error)
+	// TogglePlug switches a plug on if it is off or off if it is on.
+	TogglePlug(ctx context.Context, in *TogglePlugRequest, opts ...grpc.CallOption) (*TogglePlugResponse, error)
+	// StreamState sends an event every time a plug changes state until the client disconnects.
+	StreamState(ctx context.Context, in *StreamStateRequest, opts ...grpc.CallOption) (KasaService_StreamStateClient, error)
+}
+
+type kasaServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewKasaServiceClient(cc grpc.ClientConnInterface) KasaServiceClient {
+	return &kasaServiceClient{cc}
+}
+
+func (c *kasaServiceClient) ListPlugs(ctx context.Context, in *ListPlugsRequest, opts ...grpc.CallOption) (*ListPlugsResponse, error) {
+	out := new(ListPlugsResponse)
+	err := c.cc.Invoke(ctx, KasaService_ListPlugs_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kasaServiceClient) TogglePlug(ctx context.Context, in *TogglePlugRequest, opts ...grpc.CallOption) (*TogglePlugResponse, error) {
+	out := new(TogglePlugResponse)
+	err := c.cc.Invoke(ctx, KasaService_TogglePlug_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kasaServiceClient) StreamState(ctx context.Context, in *StreamStateRequest, opts ...grpc.CallOption) (KasaService_StreamStateClient, error) {
+	stream, err := c.cc.NewStream(ctx, &KasaService_ServiceDesc.Streams[0], KasaService_StreamState_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &kasaServiceStreamStateClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type KasaService_StreamStateClient interface {
+	Recv() (*PlugStateEvent, error)
+	grpc.ClientStream
+}
+
+type kasaServiceStreamStateClient struct {
+	grpc.ClientStream
+}
+
+func (x *kasaServiceStreamStateClient) Recv() (*PlugStateEvent, error) {
+	m := new(PlugStateEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// KasaServiceServer is the server API for KasaService service.
+// All implementations must embed UnimplementedKasaServiceServer
+// for forward compatibility
+type KasaServiceServer interface {
+	// ListPlugs returns all managed plugs and their current state.
+	ListPlugs(context.Context, *ListPlugsRequest) (*ListPlugsResponse, error)
+	// TogglePlug switches a plug on if it is off or off if it is on.
+	TogglePlug(context.Context, *TogglePlugRequest) (*TogglePlugResponse, error)
+	// StreamState sends an event every time a plug changes state until the client disconnects.
+	StreamState(*StreamStateRequest, KasaService_StreamStateServer) error
+	mustEmbedUnimplementedKasaServiceServer()
+}
+
+// UnimplementedKasaServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedKasaServiceServer struct {
+}
+
+func (UnimplementedKasaServiceServer) ListPlugs(context.Context, *ListPlugsRequest) (*ListPlugsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListPlugs not implemented")
+}
+func (UnimplementedKasaServiceServer) TogglePlug(context.Context, *TogglePlugRequest) (*TogglePlugResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TogglePlug not implemented")
+}
+func (UnimplementedKasaServiceServer) StreamState(*StreamStateRequest, KasaService_StreamStateServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamState not implemented")
+}
+func (UnimplementedKasaServiceServer) mustEmbedUnimplementedKasaServiceServer() {}
+
+// UnsafeKasaServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to KasaServiceServer will
+// result in compilation errors.
+type UnsafeKasaServiceServer interface {
+	mustEmbedUnimplementedKasaServiceServer()
+}
+
+func RegisterKasaServiceServer(s grpc.ServiceRegistrar, srv KasaServiceServer) {
+	s.RegisterService(&KasaService_ServiceDesc, srv)
+}
+
+func _KasaService_ListPlugs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListPlugsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KasaServiceServer).ListPlugs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KasaService_ListPlugs_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KasaServiceServer).ListPlugs(ctx, req.(*ListPlugsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KasaService_TogglePlug_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TogglePlugRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KasaServiceServer).TogglePlug(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KasaService_TogglePlug_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KasaServiceServer).TogglePlug(ctx, req.(*TogglePlugRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KasaService_StreamState_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamStateRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(KasaServiceServer).StreamState(m, &kasaServiceStreamStateServer{stream})
+}
+
+type KasaService_StreamStateServer interface {
+	Send(*PlugStateEvent) error
+	grpc.ServerStream
+}
+
+type kasaServiceStreamStateServer struct {
+	grpc.ServerStream
+}
+
+func (x *kasaServiceStreamStateServer) Send(m *PlugStateEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// KasaService_ServiceDesc is the grpc.ServiceDesc for KasaService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var KasaService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "kasa.KasaService",
+	HandlerType: (*KasaServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListPlugs",
+			Handler:    _KasaService_ListPlugs_Handler,
+		},
+		{
+			MethodName: "TogglePlug",
+			Handler:    _KasaService_TogglePlug_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamState",
+			Handler:       _KasaService_StreamState_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "kasa.proto",
+}
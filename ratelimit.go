@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/clintjedwards/innerhaven/internal/config"
+	"golang.org/x/time/rate"
+)
+
+// rateLimiter limits requests using token buckets; one shared by all clients and one per remote IP.
+type rateLimiter struct {
+	config *config.RateLimit
+
+	// global is nil when the global limit is disabled.
+	global *rate.Limiter
+
+	// clients maps a remote IP to its *clientLimiter.
+	clients sync.Map
+}
+
+type clientLimiter struct {
+	limiter *rate.Limiter
+
+	// lastSeen is the time (in unix nanoseconds) of the client's most recent request. Accessed atomically.
+	lastSeen int64
+}
+
+func newRateLimiter(config *config.RateLimit) *rateLimiter {
+	limiter := &rateLimiter{
+		config: config,
+	}
+
+	if config.GlobalRPS > 0 {
+		limiter.global = rate.NewLimiter(rate.Limit(config.GlobalRPS), config.GlobalBurst)
+	}
+
+	return limiter
+}
+
+// clientLimiter returns the limiter for the given remote IP, creating it if this is the client's first request.
+func (rl *rateLimiter) clientLimiter(ip string) *rate.Limiter {
+	now := time.Now().UnixNano()
+
+	if entry, exists := rl.clients.Load(ip); exists {
+		client := entry.(*clientLimiter)
+		atomic.StoreInt64(&client.lastSeen, now)
+		return client.limiter
+	}
+
+	entry, _ := rl.clients.LoadOrStore(ip, &clientLimiter{
+		limiter:  rate.NewLimiter(rate.Limit(rl.config.PerClientRPS), rl.config.PerClientBurst),
+		lastSeen: now,
+	})
+	client := entry.(*clientLimiter)
+	atomic.StoreInt64(&client.lastSeen, now)
+
+	return client.limiter
+}
+
+// evictIdleClients forgets clients that haven't made a request within the configured idle timeout so that the
+// client map doesn't grow without bound. It is run periodically as a background task.
+func (rl *rateLimiter) evictIdleClients(_ context.Context) error {
+	cutoff := time.Now().Add(-rl.config.ClientIdleTimeout).UnixNano()
+	rl.clients.Range(func(key, value any) bool {
+		if atomic.LoadInt64(&value.(*clientLimiter).lastSeen) < cutoff {
+			rl.clients.Delete(key)
+		}
+		return true
+	})
+
+	return nil
+}
+
+// reserve takes a token from the given limiter. If none is available it returns false along with how long the
+// caller should wait before trying again; the token is handed back so rejected requests don't count against the
+// client.
+func reserve(limiter *rate.Limiter) (bool, time.Duration) {
+	reservation := limiter.Reserve()
+	if !reservation.OK() {
+		return false, time.Second
+	}
+
+	delay := reservation.Delay()
+	if delay == 0 {
+		return true, 0
+	}
+
+	reservation.Cancel()
+	return false, delay
+}
+
+// setRateLimiter replaces the rate limiter in use, along with the task that evicts its idle clients. Clients start
+// over with a full bucket under the new limiter. The caller must hold reloadMu.
+func (apictx *APIContext) setRateLimiter(config *config.RateLimit) {
+	limiter := newRateLimiter(config)
+	apictx.limiter.Store(limiter)
+
+	if apictx.evictTask != nil {
+		apictx.evictTask.Stop()
+		apictx.evictTask = nil
+	}
+
+	if config.ClientIdleTimeout > 0 {
+		apictx.evictTask = apictx.tasks.Register("evict_idle_rate_limit_clients", config.ClientIdleTimeout,
+			limiter.evictIdleClients)
+	}
+}
+
+// allow takes a token for a request from the given client IP. If either the global or the per client limit is
+// exhausted it returns false along with how long the client should wait before trying again.
+func (rl *rateLimiter) allow(ip string) (bool, time.Duration) {
+	if rl.config.PerClientRPS > 0 {
+		if ok, retryAfter := reserve(rl.clientLimiter(ip)); !ok {
+			return false, retryAfter
+		}
+	}
+
+	if rl.global != nil {
+		if ok, retryAfter := reserve(rl.global); !ok {
+			return false, retryAfter
+		}
+	}
+
+	return true, 0
+}
+
+// rateLimitMiddleware rejects requests with a 429 once either the global or the per client limit of the current
+// rate limiter is exhausted.
+func (apictx *APIContext) rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Behind a trusted proxy every request comes from the proxy's address, so clients are told apart by the
+		// address requesterIPMiddleware found for them instead.
+		if ok, retryAfter := apictx.limiter.Load().allow(requesterIPFromContext(r.Context())); !ok {
+			tooManyRequests(w, r, retryAfter)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func tooManyRequests(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	writeProblem(w, r, ProblemTypeRateLimitExceeded, http.StatusTooManyRequests,
+		"Too many requests; retry after the time given in Retry-After")
+}
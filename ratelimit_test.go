@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/clintjedwards/innerhaven/internal/config"
+)
+
+func TestRateLimitPerForwardedClient(t *testing.T) {
+	conf := config.DefaultAPIConfig()
+	conf.TrustedProxies = []string{"192.0.2.1"}
+	conf.RateLimit.PerClientRPS = 0.001
+	conf.RateLimit.PerClientBurst = 1
+
+	apictx, _ := newTestAPI(t, conf)
+	apictx.reloadMu.Lock()
+	apictx.setRateLimiter(conf.RateLimit)
+	apictx.reloadMu.Unlock()
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+	handler := apictx.requesterIPMiddleware(apictx.rateLimitMiddleware(ok))
+
+	// httptest requests come from 192.0.2.1, the trusted proxy, so only the forwarded address tells clients apart.
+	tests := []struct {
+		client string
+		want   int
+	}{
+		{client: "198.51.100.1", want: http.StatusOK},
+		{client: "198.51.100.1", want: http.StatusTooManyRequests},
+		{client: "198.51.100.2", want: http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/plugs", nil)
+		r.Header.Set("X-Forwarded-For", tc.client)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != tc.want {
+			t.Errorf("request from %s: status = %d, want %d", tc.client, w.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"runtime/debug"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog/log"
+)
+
+var panicCount = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "panic_count",
+	Help: "The total number of panics recovered from while serving requests.",
+})
+
+// recoveryMiddleware keeps a panicking handler from taking down the whole server. The panic and its stack trace are
+// logged and the client receives a 500.
+func recoveryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+
+			// Let net/http handle an intentional abort the way it normally would.
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			panicCount.Inc()
+			sentry.CurrentHub().RecoverWithContext(r.Context(), recovered)
+			log.Error().Interface("panic", recovered).Str("method", r.Method).Stringer("url", r.URL).
+				Bytes("stack", debug.Stack()).Msg("recovered from panic while serving request")
+
+			writeProblem(w, r, problemTypeBlank, http.StatusInternalServerError, "An unexpected error occurred")
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/clintjedwards/innerhaven/internal/config"
+	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog/log"
+)
+
+// watchConfigFile is set by the --watch-config flag. When true the service reloads its config whenever the config
+// file changes, as though it had been sent a SIGHUP.
+var watchConfigFile bool
+
+// configWatchDebounce is how long the config file has to go without changing before it is reloaded, so that an
+// editor writing the file in several steps causes a single reload.
+const configWatchDebounce = 200 * time.Millisecond
+
+var configReloads = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "config_reloads_total",
+	Help: "The total number of times the config was successfully reloaded without a restart.",
+})
+
+// reloadConfig re-reads the config and applies the settings that can change while the service is running: the
+// client CIDR lists, the rate limits and the audit log path. Everything else only takes effect on restart. Nothing
+// is applied unless the whole config is valid.
+func (apictx *APIContext) reloadConfig() error {
+	conf, err := config.InitAPIConfig("", true, false)
+	if err != nil {
+		return err
+	}
+
+	err = conf.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+
+	acl, err := newCIDRACL(conf.Server.AllowedCIDRs, conf.Server.BlockedCIDRs)
+	if err != nil {
+		return err
+	}
+
+	apictx.reloadMu.Lock()
+	defer apictx.reloadMu.Unlock()
+
+	if conf.AuditLogPath != apictx.auditLogPath {
+		audit, err := NewAuditLogger(conf.AuditLogPath)
+		if err != nil {
+			return err
+		}
+
+		err = apictx.audit.Swap(audit).Close()
+		if err != nil {
+			log.Error().Err(err).Str("path", apictx.auditLogPath).Msg("could not close previous audit log")
+		}
+
+		apictx.auditLogPath = conf.AuditLogPath
+	}
+
+	apictx.acl.Store(acl)
+	apictx.setRateLimiter(conf.RateLimit)
+
+	warnRestartRequired(apictx.config, conf)
+	configReloads.Inc()
+
+	return nil
+}
+
+// warnRestartRequired logs each setting that differs from the one the service was started with but can't be
+// changed without a restart.
+func warnRestartRequired(running, reloaded *config.API) {
+	settings := []struct {
+		name             string
+		running, updated string
+	}{
+		{"server.listen_address", running.Server.ListenAddress, reloaded.Server.ListenAddress},
+		{"server.grpc_listen_address", running.Server.GRPCListenAddress, reloaded.Server.GRPCListenAddress},
+		{"server.redirect_listen_address", running.Server.RedirectListenAddress, reloaded.Server.RedirectListenAddress},
+		{"server.tls_cert_path", running.Server.TLSCertPath, reloaded.Server.TLSCertPath},
+		{"server.tls_key_path", running.Server.TLSKeyPath, reloaded.Server.TLSKeyPath},
+		{"server.client_ca_cert_path", running.Server.ClientCACertPath, reloaded.Server.ClientCACertPath},
+		{"server.acme_domain", running.Server.ACMEDomain, reloaded.Server.ACMEDomain},
+	}
+
+	for _, setting := range settings {
+		if setting.running != setting.updated {
+			log.Warn().Str("setting", setting.name).Str("running", setting.running).Str("configured", setting.updated).
+				Msg("setting changed but only takes effect after a restart")
+		}
+	}
+}
+
+// watchConfig reloads the config each time the file at path is written or replaced until the context is cancelled.
+// The directory is watched rather than the file itself because many editors save by renaming a new file over the
+// old one, which would otherwise end the watch.
+func (apictx *APIContext) watchConfig(ctx context.Context, path string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	path = filepath.Clean(path)
+	err = watcher.Add(filepath.Dir(path))
+	if err != nil {
+		watcher.Close()
+		return fmt.Errorf("could not watch config file %q; %w", path, err)
+	}
+
+	go func() {
+		defer watcher.Close()
+
+		reload := time.NewTimer(configWatchDebounce)
+		reload.Stop()
+		defer reload.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+
+				if filepath.Clean(event.Name) != path || !event.Has(fsnotify.Write|fsnotify.Rename|fsnotify.Create) {
+					continue
+				}
+
+				reload.Reset(configWatchDebounce)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+
+				log.Error().Err(err).Str("path", path).Msg("error watching config file")
+			case <-reload.C:
+				apictx.reloadConfigFile(path)
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (apictx *APIContext) reloadConfigFile(path string) {
+	var modTime time.Time
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime()
+	}
+
+	err := apictx.reloadConfig()
+	if err != nil {
+		log.Error().Err(err).Str("path", path).Msg("could not reload config; keeping current settings")
+		return
+	}
+
+	log.Info().Str("path", path).Time("modified", modTime).Msg("reloaded config after file changed")
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// DeviceScheduleRule is a schedule rule stored and executed on the plug itself, independent of this service.
+type DeviceScheduleRule struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+
+	// Enable is 1 if the rule is active.
+	Enable int `json:"enable"`
+
+	// WDay marks which days the rule runs on, starting with Sunday; 1 means the rule runs that day.
+	WDay []int `json:"wday"`
+
+	// Repeat is 1 if the rule runs every week rather than once.
+	Repeat int `json:"repeat"`
+
+	// StartOpt is 0 when StartMin is a time of day, EndOpt is -1 when the rule has no end action.
+	StartOpt int `json:"stime_opt"`
+	StartMin int `json:"smin"`
+	EndOpt   int `json:"etime_opt"`
+	EndMin   int `json:"emin"`
+
+	// StartAction and EndAction are the relay state to set (1 on, 0 off) at the start and end times; -1 means none.
+	StartAction int `json:"sact"`
+	EndAction   int `json:"eact"`
+}
+
+// CreateDeviceScheduleRule adds a weekly repeating rule to the plug that sets the relay to action (1 on, 0 off) at
+// startTime on each of the given weekdays (0 is Sunday). If endTime is non-zero the opposite action is performed
+// then. Only the hour and minute of the times are used.
+func (p *plug) CreateDeviceScheduleRule(weekdays []int, startTime, endTime time.Time, action int) error {
+	rule, err := newDeviceScheduleRule(weekdays, startTime, endTime, action)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(map[string]any{
+		"schedule": map[string]any{
+			"add_rule": rule,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = p.sendCmd(string(payload))
+	return err
+}
+
+// EditDeviceScheduleRule replaces the schedule rule with the given ID with one built from the same arguments
+// CreateDeviceScheduleRule takes.
+func (p *plug) EditDeviceScheduleRule(ruleID string, weekdays []int, startTime, endTime time.Time, action int) error {
+	rule, err := newDeviceScheduleRule(weekdays, startTime, endTime, action)
+	if err != nil {
+		return err
+	}
+	rule.ID = ruleID
+
+	payload, err := json.Marshal(map[string]any{
+		"schedule": map[string]any{
+			"edit_rule": rule,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = p.sendCmd(string(payload))
+	return err
+}
+
+// newDeviceScheduleRule builds the weekly repeating rule described by CreateDeviceScheduleRule.
+func newDeviceScheduleRule(weekdays []int, startTime, endTime time.Time, action int) (DeviceScheduleRule, error) {
+	wday := make([]int, 7)
+	for _, day := range weekdays {
+		if day < 0 || day > 6 {
+			return DeviceScheduleRule{}, fmt.Errorf("weekday %d out of range; must be 0 (Sunday) through 6 (Saturday)",
+				day)
+		}
+		wday[day] = 1
+	}
+
+	rule := DeviceScheduleRule{
+		Name:        fmt.Sprintf("turn %s at %s", stateName(int2bool(action)), startTime.Format("15:04")),
+		Enable:      1,
+		WDay:        wday,
+		Repeat:      1,
+		StartOpt:    0,
+		StartMin:    startTime.Hour()*60 + startTime.Minute(),
+		StartAction: action,
+		EndOpt:      -1,
+		EndAction:   -1,
+	}
+
+	if !endTime.IsZero() {
+		rule.EndOpt = 0
+		rule.EndMin = endTime.Hour()*60 + endTime.Minute()
+		rule.EndAction = 1 - action
+	}
+
+	return rule, nil
+}
+
+// ListDeviceScheduleRules returns the schedule rules stored on the plug.
+func (p *plug) ListDeviceScheduleRules() ([]DeviceScheduleRule, error) {
+	payload := `{"schedule":{"get_rules":{}}}`
+	results, err := p.sendCmd(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Schedule struct {
+			GetRules struct {
+				RuleList []DeviceScheduleRule `json:"rule_list"`
+			} `json:"get_rules"`
+		} `json:"schedule"`
+	}
+	err = json.Unmarshal(results, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	return response.Schedule.GetRules.RuleList, nil
+}
+
+// DeleteDeviceScheduleRule removes the schedule rule with the given ID from the plug.
+func (p *plug) DeleteDeviceScheduleRule(ruleID string) error {
+	payload, err := json.Marshal(map[string]any{
+		"schedule": map[string]any{
+			"delete_rule": map[string]string{
+				"id": ruleID,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = p.sendCmd(string(payload))
+	return err
+}
+
+// stateName returns the human readable name of a relay state.
+func stateName(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"syscall"
+
+	"github.com/clintjedwards/innerhaven/internal/config"
+	"github.com/getsentry/sentry-go"
+)
+
+// initSentry sets up error reporting to Sentry. Reporting stays disabled, and every capture a no-op, when no DSN is
+// configured.
+func initSentry(conf *config.Sentry) error {
+	if conf.DSN == "" {
+		return nil
+	}
+
+	return sentry.Init(sentry.ClientOptions{
+		Dsn:         conf.DSN,
+		Environment: conf.Environment,
+		Release:     appVersion,
+	})
+}
+
+// isTransientError reports whether a plug error is the kind that usually goes away on its own when retried, like
+// the plug briefly dropping off the network.
+func isTransientError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// reportPlugError sends an error talking to a plug to Sentry tagged with the plug it came from. Transient errors are
+// skipped since plugs dropping off the network now and then is expected.
+func reportPlugError(p *plug, address string, err error) {
+	if isTransientError(err) {
+		return
+	}
+
+	sentry.WithScope(func(scope *sentry.Scope) {
+		scope.SetTag("plug_name", p.Name)
+		scope.SetTag("plug_ip", address)
+		sentry.CaptureException(err)
+	})
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// SequenceEvent is a single step in a scripted sequence of plug commands.
+type SequenceEvent struct {
+	IP         string
+	State      bool
+	DelayAfter time.Duration
+}
+
+// sequenceProgress tracks where the currently playing sequence is. Guarded by APIContext.sequenceMu.
+type sequenceProgress struct {
+	running    bool
+	loop       bool
+	eventCount int
+	eventIndex int
+	loopCount  int
+	startedAt  time.Time
+}
+
+// ErrSequenceRunning is returned when a sequence is started while another is still playing.
+var ErrSequenceRunning = errors.New("a sequence is already playing")
+
+// PlaySequence runs the given events in order, turning each plug on or off and then waiting for the event's
+// DelayAfter. When loop is true the sequence starts over after the last event until the context is cancelled.
+// Only one sequence can play at a time.
+func (apictx *APIContext) PlaySequence(ctx context.Context, events []SequenceEvent, loop bool) error {
+	plugs := make([]*plug, 0, len(events))
+	for _, event := range events {
+		plug, exists := apictx.getPlug(event.IP)
+		if !exists {
+			return fmt.Errorf("plug %q not found", event.IP)
+		}
+		plugs = append(plugs, plug)
+	}
+
+	ctx, err := apictx.claimSequence(ctx, len(events), loop)
+	if err != nil {
+		return err
+	}
+
+	return apictx.playSequence(ctx, plugs, events, loop)
+}
+
+// claimSequence marks a sequence as playing and returns the context it should play under, which stopSequence
+// cancels. It returns ErrSequenceRunning if another sequence is still playing. Every successful claim must be
+// followed by playSequence, which gives it up again.
+func (apictx *APIContext) claimSequence(ctx context.Context, eventCount int, loop bool) (context.Context, error) {
+	apictx.sequenceMu.Lock()
+	defer apictx.sequenceMu.Unlock()
+
+	if apictx.sequence.running {
+		return nil, ErrSequenceRunning
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	apictx.sequence = sequenceProgress{
+		running:    true,
+		loop:       loop,
+		eventCount: eventCount,
+		startedAt:  time.Now(),
+	}
+	apictx.sequenceCancel = cancel
+
+	return ctx, nil
+}
+
+// playSequence plays a sequence claimed with claimSequence; plugs[i] is the plug events[i] switches.
+func (apictx *APIContext) playSequence(ctx context.Context, plugs []*plug, events []SequenceEvent, loop bool) error {
+	defer func() {
+		apictx.sequenceMu.Lock()
+		apictx.sequence.running = false
+		apictx.sequenceCancel()
+		apictx.sequenceCancel = nil
+		apictx.sequenceMu.Unlock()
+	}()
+
+	for {
+		for i, event := range events {
+			apictx.sequenceMu.Lock()
+			apictx.sequence.eventIndex = i
+			apictx.sequenceMu.Unlock()
+
+			// setState goes through turnOn/turnOff but also keeps the plug's tracked state and on-time accurate.
+			err := plugs[i].setState(ctx, PriorityNormal, event.State)
+			if err != nil && ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err != nil {
+				log.Error().Err(err).Str("plug", event.IP).Msg("sequence could not change plug state")
+			}
+			apictx.recordStateChange("", plugs[i], stateName(event.State), AuditSourceRule, err == nil)
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(event.DelayAfter):
+			}
+		}
+
+		if !loop {
+			return nil
+		}
+
+		apictx.sequenceMu.Lock()
+		apictx.sequence.loopCount++
+		apictx.sequenceMu.Unlock()
+	}
+}
+
+// stopSequence cancels the currently playing sequence, if any.
+func (apictx *APIContext) stopSequence() {
+	apictx.sequenceMu.Lock()
+	defer apictx.sequenceMu.Unlock()
+
+	if apictx.sequenceCancel != nil {
+		apictx.sequenceCancel()
+	}
+}
+
+type PlaySequenceEvent struct {
+	IP           string `json:"ip" example:"192.168.1.20" doc:"The IP address or hostname of the target plug"`
+	On           bool   `json:"on" example:"true" doc:"Whether the plug should be turned on or off"`
+	DelayAfterMS int    `json:"delay_after_ms,omitempty" example:"1000" minimum:"0" doc:"How long to wait after this event before running the next one"`
+}
+
+type (
+	PlaySequenceRequest struct {
+		Body struct {
+			Events []PlaySequenceEvent `json:"events" minItems:"1" doc:"The events to play in order"`
+			Loop   bool                `json:"loop,omitempty" example:"false" doc:"Repeat the sequence until it is stopped"`
+		}
+	}
+	PlaySequenceResponse struct{}
+)
+
+func (apictx *APIContext) registerPlaySequence(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "PlaySequence",
+		Method:      http.MethodPost,
+		Path:        "/api/sequences/play",
+		Summary:     "Play a sequence of plug commands",
+		Description: "Turn plugs on or off in the given order, waiting after each event for its delay. The sequence " +
+			"plays in the background; only one sequence can play at a time.",
+		Tags:     []string{"Sequences"},
+		Security: bearerAuth,
+		// Handler //
+	}, func(ctx context.Context, request *PlaySequenceRequest) (*PlaySequenceResponse, error) {
+		err := requireAdmin(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		events := make([]SequenceEvent, 0, len(request.Body.Events))
+		plugs := make([]*plug, 0, len(request.Body.Events))
+		for _, event := range request.Body.Events {
+			plug, exists := apictx.getPlug(event.IP)
+			if !exists {
+				return nil, plugNotFoundError(fmt.Sprintf("Plug %q not found", event.IP))
+			}
+
+			// The plugs are named in the body, so roleMiddleware can't check them.
+			if !apictx.plugAllowed(ctx, plug) {
+				return nil, huma.Error403Forbidden(fmt.Sprintf("This token may not use plug %q", event.IP))
+			}
+
+			plugs = append(plugs, plug)
+			events = append(events, SequenceEvent{
+				IP:         event.IP,
+				State:      event.On,
+				DelayAfter: time.Duration(event.DelayAfterMS) * time.Millisecond,
+			})
+		}
+
+		// The sequence is claimed before answering so that of two requests racing each other only one is told it
+		// started.
+		sequenceCtx, err := apictx.claimSequence(context.Background(), len(events), request.Body.Loop)
+		if err != nil {
+			return nil, huma.Error409Conflict("A sequence is already playing")
+		}
+
+		go func() {
+			err := apictx.playSequence(sequenceCtx, plugs, events, request.Body.Loop)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				log.Error().Err(err).Msg("sequence exited abnormally")
+			}
+		}()
+
+		return &PlaySequenceResponse{}, nil
+	})
+}
+
+type (
+	DescribeRunningSequenceRequest  struct{}
+	DescribeRunningSequenceResponse struct {
+		Body struct {
+			Running    bool  `json:"running" example:"true" doc:"Whether a sequence is currently playing"`
+			Loop       bool  `json:"loop" example:"false" doc:"Whether the sequence repeats until stopped"`
+			EventCount int   `json:"event_count" example:"4" doc:"The amount of events in the sequence"`
+			EventIndex int   `json:"event_index" example:"2" doc:"The index of the event currently playing"`
+			LoopCount  int   `json:"loop_count" example:"0" doc:"The amount of times the sequence has fully repeated"`
+			StartedAt  int64 `json:"started_at,omitempty" example:"1712433802634" doc:"Time the sequence started in epoch milliseconds"`
+		}
+	}
+)
+
+func (apictx *APIContext) registerDescribeRunningSequence(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "DescribeRunningSequence",
+		Method:      http.MethodGet,
+		Path:        "/api/sequences/running",
+		Summary:     "Describe the running sequence",
+		Description: "Show the progress of the currently playing sequence.",
+		Tags:        []string{"Sequences"},
+		Security:    bearerAuth,
+		// Handler //
+	}, func(_ context.Context, _ *DescribeRunningSequenceRequest) (*DescribeRunningSequenceResponse, error) {
+		apictx.sequenceMu.Lock()
+		progress := apictx.sequence
+		apictx.sequenceMu.Unlock()
+
+		resp := &DescribeRunningSequenceResponse{}
+		resp.Body.Running = progress.running
+		resp.Body.Loop = progress.loop
+		resp.Body.EventCount = progress.eventCount
+		resp.Body.EventIndex = progress.eventIndex
+		resp.Body.LoopCount = progress.loopCount
+		if !progress.startedAt.IsZero() {
+			resp.Body.StartedAt = progress.startedAt.UnixMilli()
+		}
+
+		return resp, nil
+	})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog/log"
+)
+
+var slaViolations = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "kasa_sla_violations_total",
+	Help: "The total number of requests that took longer than the configured SLA threshold to serve.",
+}, []string{"path"})
+
+// slaMiddleware warns about and counts requests that take longer than the configured SLA threshold, which usually
+// means a plug is slow to respond. Long lived streams are not measured.
+func (apictx *APIContext) slaMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		threshold := apictx.config.Server.SLAThreshold
+		if threshold <= 0 || isLongLivedPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		elapsed := time.Since(start)
+
+		if elapsed <= threshold {
+			return
+		}
+
+		slaViolations.WithLabelValues(apictx.routeLabel(r.URL.Path)).Inc()
+		log.Warn().Int64("elapsed_ms", elapsed.Milliseconds()).Str("method", r.Method).Stringer("url", r.URL).
+			Msg("request exceeded SLA threshold")
+	})
+}
+
+// plugFromPath returns the plug targeted by a /api/plugs/{ip}/... route.
+func (apictx *APIContext) plugFromPath(path string) (*plug, bool) {
+	rest, found := strings.CutPrefix(path, "/api/plugs/")
+	if !found {
+		return nil, false
+	}
+
+	ip, _, _ := strings.Cut(rest, "/")
+	return apictx.getPlug(ip)
+}
+
+// routeLabel returns the path with the plug address replaced by a placeholder so that metrics have one series per
+// route rather than one per plug.
+func (apictx *APIContext) routeLabel(path string) string {
+	plug, exists := apictx.plugFromPath(path)
+	if !exists {
+		return path
+	}
+
+	address, _ := plug.addresses()
+	return strings.Replace(path, "/api/plugs/"+address, "/api/plugs/{ip}", 1)
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// stateLockContentionThreshold is how long waiting on a state file lock can take before it is logged as
+// contention with another instance.
+const stateLockContentionThreshold = 500 * time.Millisecond
+
+// stateFileTimeFormat names state export files so that sorting them by name also sorts them by age.
+const stateFileTimeFormat = "20060102T150405Z"
+
+// stateMigrations upgrade a state document by one schema version; stateMigrations[n] turns a version n document into
+// a version n+1 document. Changing the shape of stateDocument means bumping the version by appending a migration
+// here so that older exports can still be loaded.
+var stateMigrations = []func(raw []byte) ([]byte, error){
+	migrateV0toV1,
+}
+
+// currentStateSchemaVersion is the schema version SaveState writes.
+var currentStateSchemaVersion = len(stateMigrations)
+
+// stateDocument is a point in time snapshot of the managed plugs, as written by SaveState.
+type stateDocument struct {
+	SchemaVersion int              `json:"schema_version"`
+	SavedAt       time.Time        `json:"saved_at"`
+	Plugs         []plugStateEntry `json:"plugs"`
+}
+
+type plugStateEntry struct {
+	Name          string `json:"name"`
+	Address       string `json:"address"`
+	BackupAddress string `json:"backup_address,omitempty"`
+	Model         string `json:"model"`
+	DeviceID      string `json:"device_id"`
+	On            bool   `json:"on"`
+}
+
+// SaveState writes a snapshot of every plug to path.
+func (apictx *APIContext) SaveState(path string) error {
+	doc := stateDocument{
+		SchemaVersion: currentStateSchemaVersion,
+		SavedAt:       time.Now().UTC(),
+		Plugs:         []plugStateEntry{},
+	}
+
+	for _, plug := range apictx.listPlugs() {
+		address, backupAddress := plug.addresses()
+		doc.Plugs = append(doc.Plugs, plugStateEntry{
+			Name:          plug.Name,
+			Address:       address,
+			BackupAddress: backupAddress,
+			Model:         plug.Model,
+			DeviceID:      plug.DeviceID,
+			On:            plug.IsOn(),
+		})
+	}
+
+	contents, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	lock, err := lockStateFile(path, true)
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+
+	return writeFileAtomic(path, contents)
+}
+
+// lockStateFile locks the state file at path so that instances sharing it don't read a half written file or
+// overwrite each other. Readers take a shared lock and writers an exclusive one. The lock is held on a separate
+// path.lock file since saving replaces the state file itself.
+func lockStateFile(path string, exclusive bool) (*fileLock, error) {
+	start := time.Now()
+	lock, err := lockFile(path+".lock", exclusive)
+	if err != nil {
+		return nil, err
+	}
+
+	if waited := time.Since(start); waited > stateLockContentionThreshold {
+		log.Warn().Str("path", path).Dur("waited", waited).Bool("exclusive", exclusive).
+			Msg("waited a long time for the state file lock; another instance may be holding it")
+	}
+
+	return lock, nil
+}
+
+// saveSharedState is a PostToggleHook that writes the plug state to shared_state_path so other instances sharing
+// the file can pick it up.
+func (apictx *APIContext) saveSharedState(_ ToggleEvent) {
+	path := apictx.config.SharedStatePath
+
+	go func() {
+		err := apictx.SaveState(path)
+		if err != nil {
+			log.Error().Err(err).Str("path", path).Msg("could not save shared state")
+		}
+	}()
+}
+
+// writeFileAtomic writes contents to a temporary file and renames it into place so that a crash halfway through
+// never leaves a truncated file behind.
+func writeFileAtomic(path string, contents []byte) error {
+	tmpPath := path + ".tmp"
+	err := os.WriteFile(tmpPath, contents, 0o640)
+	if err != nil {
+		return fmt.Errorf("could not write %q; %w", tmpPath, err)
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		return fmt.Errorf("could not move %q into place; %w", path, err)
+	}
+
+	return nil
+}
+
+// LoadState reads a snapshot written by SaveState. Snapshots from an older schema version are migrated to the current
+// one and the migrated document is written back to path so the migration only happens once.
+func LoadState(path string) (*stateDocument, error) {
+	lock, err := lockStateFile(path, false)
+	if err != nil {
+		return nil, err
+	}
+	original, err := os.ReadFile(path)
+	lock.unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	doc, raw, fromVersion, err := parseState(original)
+	if err != nil {
+		return nil, fmt.Errorf("could not load state file %q; %w", path, err)
+	}
+
+	if fromVersion < currentStateSchemaVersion {
+		log.Warn().Str("path", path).Int("from_version", fromVersion).Int("to_version", currentStateSchemaVersion).
+			Msg("migrated state file to current schema version")
+
+		err = writeMigratedState(path, original, raw)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return doc, nil
+}
+
+// writeMigratedState replaces the state file at path with its migrated contents, unless another instance has
+// saved over it since it was read.
+func writeMigratedState(path string, original, migrated []byte) error {
+	lock, err := lockStateFile(path, true)
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+
+	current, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(current, original) {
+		return nil
+	}
+
+	return writeFileAtomic(path, migrated)
+}
+
+// parseState migrates a raw state document to the current schema version and decodes it. It returns the migrated
+// document along with the version it started at.
+func parseState(raw []byte) (doc *stateDocument, migrated []byte, fromVersion int, err error) {
+	var header struct {
+		SchemaVersion int `json:"schema_version"`
+	}
+	err = json.Unmarshal(raw, &header)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	fromVersion = header.SchemaVersion
+	if fromVersion < 0 {
+		return nil, nil, 0, fmt.Errorf("schema version %d is not a valid version", fromVersion)
+	}
+	if fromVersion > currentStateSchemaVersion {
+		return nil, nil, 0, fmt.Errorf("schema version %d is newer than the supported version %d", fromVersion,
+			currentStateSchemaVersion)
+	}
+
+	for version := fromVersion; version < currentStateSchemaVersion; version++ {
+		raw, err = stateMigrations[version](raw)
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("could not migrate from schema version %d; %w", version, err)
+		}
+	}
+
+	doc = &stateDocument{}
+	err = json.Unmarshal(raw, doc)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	return doc, raw, fromVersion, nil
+}
+
+// migrateV0toV1 stamps snapshots written before state files were versioned with version 1. Their layout is otherwise
+// the same.
+func migrateV0toV1(raw []byte) ([]byte, error) {
+	var doc map[string]any
+	err := json.Unmarshal(raw, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	doc["schema_version"] = 1
+
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// ScheduleStateExport saves the plug state to a new file in baseDir every interval, keeping only the most recent
+// files as configured by state_export.keep_last. Older snapshots stick around so that there is still something to
+// restore from if the latest one turns out to be bad.
+func (apictx *APIContext) ScheduleStateExport(interval time.Duration, baseDir string) *TaskHandle {
+	keepLast := apictx.config.StateExport.KeepLast
+
+	return apictx.tasks.Register("export_state", interval, func(_ context.Context) error {
+		err := os.MkdirAll(baseDir, 0o750)
+		if err != nil {
+			return fmt.Errorf("could not create state export directory %q; %w", baseDir, err)
+		}
+
+		path := filepath.Join(baseDir, fmt.Sprintf("state_%s.json", time.Now().UTC().Format(stateFileTimeFormat)))
+		err = apictx.SaveState(path)
+		if err != nil {
+			log.Error().Err(err).Str("path", path).Msg("could not export state")
+			return err
+		}
+
+		err = pruneStateFiles(baseDir, keepLast)
+		if err != nil {
+			log.Error().Err(err).Str("dir", baseDir).Msg("could not remove old state exports")
+			return err
+		}
+
+		return nil
+	})
+}
+
+// stateFiles returns the names of the state export files in dir from oldest to newest.
+func stateFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, "state_") && strings.HasSuffix(name, ".json") {
+			files = append(files, name)
+		}
+	}
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// loadLatestState loads the newest state export in dir. It returns nil without an error if there isn't one yet.
+func loadLatestState(dir string) (*stateDocument, error) {
+	files, err := stateFiles(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	return LoadState(filepath.Join(dir, files[len(files)-1]))
+}
+
+// reconcilePersistedState compares the persisted state of each plug against the state the plug itself reported in
+// getSystemInfo. Plugs may have been switched by hand while the service was down, so the live state always wins; a
+// mismatch is only logged. Plugs that couldn't be reached have no live state to compare against and are skipped.
+func reconcilePersistedState(doc *stateDocument, plugs ...*plug) {
+	if doc == nil {
+		return
+	}
+
+	persisted := map[string]plugStateEntry{}
+	for _, entry := range doc.Plugs {
+		if entry.DeviceID != "" {
+			persisted[entry.DeviceID] = entry
+		}
+	}
+
+	for _, plug := range plugs {
+		if plug.DeviceID == "" {
+			continue
+		}
+
+		entry, exists := persisted[plug.DeviceID]
+		if !exists {
+			continue
+		}
+
+		if live := plug.IsOn(); live != entry.On {
+			log.Warn().Str("plug", plug.Name).Msgf("plug %s: persisted state=%s, live state=%s; using live state",
+				plug.Name, stateName(entry.On), stateName(live))
+		}
+	}
+}
+
+// pruneStateFiles deletes all but the newest keepLast state export files in dir.
+func pruneStateFiles(dir string, keepLast int) error {
+	files, err := stateFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	if len(files) <= keepLast {
+		return nil
+	}
+
+	for _, name := range files[:len(files)-keepLast] {
+		err := os.Remove(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseStateSchemaVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		version int
+		wantErr bool
+	}{
+		{name: "oldest", version: 0},
+		{name: "current", version: currentStateSchemaVersion},
+		{name: "negative", version: -1, wantErr: true},
+		{name: "newer than supported", version: currentStateSchemaVersion + 1, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			raw := fmt.Sprintf(`{"schema_version": %d, "plugs": []}`, tc.version)
+
+			doc, _, fromVersion, err := parseState([]byte(raw))
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseState() error = %v, want error %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			if fromVersion != tc.version {
+				t.Errorf("from version = %d, want %d", fromVersion, tc.version)
+			}
+			if doc.SchemaVersion != currentStateSchemaVersion {
+				t.Errorf("schema version = %d, want %d", doc.SchemaVersion, currentStateSchemaVersion)
+			}
+		})
+	}
+}
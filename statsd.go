@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metricsSink receives plug metrics when a StatsD address is configured. It is nil, and every metric dropped,
+// otherwise.
+var metricsSink *StatsDSink
+
+// StatsDSink sends metrics to a StatsD server over UDP. Tags are sent in the DogStatsD format. A nil sink is valid
+// and drops everything, so callers don't have to check whether StatsD is enabled.
+type StatsDSink struct {
+	conn   *net.UDPConn
+	prefix string
+}
+
+// NewStatsDSink connects to the StatsD server at the given address. Every metric name is prefixed with prefix. It
+// returns a nil sink if address is empty.
+func NewStatsDSink(address, prefix string) (*StatsDSink, error) {
+	if address == "" {
+		return nil, nil
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialUDP("udp", nil, udpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StatsDSink{
+		conn:   conn,
+		prefix: prefix,
+	}, nil
+}
+
+// Increment adds one to a counter.
+func (s *StatsDSink) Increment(metric string, tags map[string]string) {
+	s.send(metric, "1", "c", tags)
+}
+
+// Gauge records the current value of something.
+func (s *StatsDSink) Gauge(metric string, value float64, tags map[string]string) {
+	s.send(metric, strconv.FormatFloat(value, 'f', -1, 64), "g", tags)
+}
+
+// Timing records how long something took in milliseconds.
+func (s *StatsDSink) Timing(metric string, d time.Duration, tags map[string]string) {
+	s.send(metric, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64), "ms", tags)
+}
+
+func (s *StatsDSink) send(metric, value, metricType string, tags map[string]string) {
+	if s == nil {
+		return
+	}
+
+	var line strings.Builder
+	line.WriteString(s.prefix + metric + ":" + value + "|" + metricType)
+
+	if len(tags) > 0 {
+		keys := make([]string, 0, len(tags))
+		for key := range tags {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		// Commas and pipes separate tags and fields so they can't appear inside a tag.
+		replacer := strings.NewReplacer(",", "_", "|", "_")
+		for i, key := range keys {
+			if i == 0 {
+				line.WriteString("|#")
+			} else {
+				line.WriteString(",")
+			}
+			line.WriteString(replacer.Replace(key) + ":" + replacer.Replace(tags[key]))
+		}
+	}
+
+	// Metrics are best effort; a dropped packet isn't worth failing or slowing down a plug command over.
+	_, _ = s.conn.Write([]byte(line.String()))
+}
+
+// Close closes the connection to the StatsD server.
+func (s *StatsDSink) Close() error {
+	if s == nil {
+		return nil
+	}
+
+	return s.conn.Close()
+}
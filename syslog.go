@@ -0,0 +1,54 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"fmt"
+	"log/syslog"
+)
+
+// SyslogSink writes plug state changes to syslog so they reach host level log aggregators like journald or
+// rsyslog alongside the rest of the system's logs. A nil sink is valid and drops everything.
+type SyslogSink struct {
+	writer *syslog.Writer
+}
+
+// NewSyslogSink connects to syslog. An address of "local" uses the local system logger; anything else is treated
+// as a host:port to send to over UDP. It returns a nil sink if address is empty.
+func NewSyslogSink(address string) (*SyslogSink, error) {
+	if address == "" {
+		return nil, nil
+	}
+
+	var writer *syslog.Writer
+	var err error
+	if address == "local" {
+		writer, err = syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, "kasa-internal")
+	} else {
+		writer, err = syslog.Dial("udp", address, syslog.LOG_INFO|syslog.LOG_DAEMON, "kasa-internal")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &SyslogSink{writer: writer}, nil
+}
+
+// LogToggle writes a single state change. It is registered as a PostToggleHook.
+func (s *SyslogSink) LogToggle(event ToggleEvent) {
+	if s == nil {
+		return
+	}
+
+	_ = s.writer.Info(fmt.Sprintf("plug %s (%s) turned %s by %s", event.PlugName, event.PlugIP,
+		stateName(event.State), event.Source))
+}
+
+// Close closes the connection to syslog.
+func (s *SyslogSink) Close() error {
+	if s == nil {
+		return nil
+	}
+
+	return s.writer.Close()
+}
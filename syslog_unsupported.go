@@ -0,0 +1,24 @@
+//go:build windows || plan9
+
+package main
+
+import "errors"
+
+// SyslogSink is unavailable on platforms without syslog; a nil sink drops everything.
+type SyslogSink struct{}
+
+// NewSyslogSink returns a nil sink if address is empty and an error otherwise, since syslog isn't supported on this
+// platform.
+func NewSyslogSink(address string) (*SyslogSink, error) {
+	if address == "" {
+		return nil, nil
+	}
+
+	return nil, errors.New("syslog is not supported on this platform")
+}
+
+func (s *SyslogSink) LogToggle(_ ToggleEvent) {}
+
+func (s *SyslogSink) Close() error {
+	return nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// TaskManager runs background tasks on an interval and stops them all on shutdown, so that periodic work doesn't
+// need its own goroutine and ticker bookkeeping.
+type TaskManager struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu    sync.Mutex
+	tasks []*TaskHandle
+}
+
+// TaskHandle controls a single task registered with the TaskManager.
+type TaskHandle struct {
+	manager  *TaskManager
+	name     string
+	interval time.Duration
+	cancel   context.CancelFunc
+
+	mu      sync.Mutex
+	lastRun time.Time
+	lastErr error
+}
+
+func newTaskManager() *TaskManager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &TaskManager{
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Register starts calling fn every interval until the task is stopped or the manager shuts down. The first call
+// happens one interval after registering. The context passed to fn is cancelled when the task is stopped.
+func (tm *TaskManager) Register(name string, interval time.Duration, fn func(ctx context.Context) error) *TaskHandle {
+	ctx, cancel := context.WithCancel(tm.ctx)
+	handle := &TaskHandle{
+		manager:  tm,
+		name:     name,
+		interval: interval,
+		cancel:   cancel,
+	}
+
+	tm.mu.Lock()
+	tm.tasks = append(tm.tasks, handle)
+	tm.mu.Unlock()
+
+	tm.wg.Add(1)
+	go func() {
+		defer tm.wg.Done()
+		defer tm.remove(handle)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			err := fn(ctx)
+
+			handle.mu.Lock()
+			handle.lastRun = time.Now()
+			handle.lastErr = err
+			handle.mu.Unlock()
+		}
+	}()
+
+	return handle
+}
+
+func (tm *TaskManager) remove(handle *TaskHandle) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	for i, task := range tm.tasks {
+		if task == handle {
+			tm.tasks = append(tm.tasks[:i], tm.tasks[i+1:]...)
+			return
+		}
+	}
+}
+
+// list returns the tasks that are currently running.
+func (tm *TaskManager) list() []*TaskHandle {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	tasks := make([]*TaskHandle, len(tm.tasks))
+	copy(tasks, tm.tasks)
+
+	return tasks
+}
+
+// stop cancels every task and waits for any that are mid-run to return.
+func (tm *TaskManager) stop() {
+	tm.cancel()
+	tm.wg.Wait()
+}
+
+// Stop cancels the task. A run that is in progress sees its context cancelled; no further runs are started.
+func (h *TaskHandle) Stop() {
+	h.cancel()
+	h.manager.remove(h)
+}
+
+// LastError returns the error from the most recent run, or nil if it succeeded or the task hasn't run yet.
+func (h *TaskHandle) LastError() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.lastErr
+}
+
+// LastRun returns when the task last finished running, or the zero time if it hasn't run yet.
+func (h *TaskHandle) LastRun() time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.lastRun
+}
+
+type TaskSummary struct {
+	Name         string  `json:"name" example:"evict_idle_rate_limit_clients" doc:"The name of the task"`
+	IntervalSecs float64 `json:"interval_secs" example:"600" doc:"How often the task runs in seconds"`
+	LastRun      int64   `json:"last_run" example:"1712433802634" doc:"When the task last ran in epoch milliseconds; 0 if it hasn't yet"`
+	LastError    string  `json:"last_error,omitempty" example:"connection refused" doc:"The error from the most recent run, if it failed"`
+}
+
+type (
+	ListTasksRequest  struct{}
+	ListTasksResponse struct {
+		Body struct {
+			Tasks []TaskSummary `json:"tasks" doc:"The background tasks that are currently running"`
+		}
+	}
+)
+
+func (apictx *APIContext) registerListTasks(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "ListTasks",
+		Method:      http.MethodGet,
+		Path:        "/api/tasks",
+		Summary:     "List background tasks",
+		Description: "Return the background tasks that are currently running along with when they last ran and " +
+			"whether that run failed.",
+		Tags:     []string{"System"},
+		Security: bearerAuth,
+		// Handler //
+	}, func(_ context.Context, _ *ListTasksRequest) (*ListTasksResponse, error) {
+		resp := &ListTasksResponse{}
+		resp.Body.Tasks = []TaskSummary{}
+
+		for _, task := range apictx.tasks.list() {
+			summary := TaskSummary{
+				Name:         task.name,
+				IntervalSecs: task.interval.Seconds(),
+			}
+			if lastRun := task.LastRun(); !lastRun.IsZero() {
+				summary.LastRun = lastRun.UnixMilli()
+			}
+			if err := task.LastError(); err != nil {
+				summary.LastError = err.Error()
+			}
+
+			resp.Body.Tasks = append(resp.Body.Tasks, summary)
+		}
+
+		return resp, nil
+	})
+}
@@ -2,8 +2,14 @@ package main
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/acme/autocert"
 
 	_ "embed"
 )
@@ -19,35 +25,138 @@ var devtlscert []byte
 var devtlskey []byte
 
 // generateTLSConfig returns TLS config object necessary for HTTPS loaded from files. If server is in devmode and
-// no cert is provided it instead loads certificates from embedded files for ease of development.
-func (api *APIContext) generateTLSConfig(certPath, keyPath string) (*tls.Config, error) {
-	var serverCert tls.Certificate
-	var err error
+// no cert is provided it instead loads certificates from embedded files for ease of development. If an ACME domain
+// is configured certificates are instead managed automatically through Let's Encrypt.
+//
+// If clientCACertPath is not empty clients are required to present a certificate signed by that CA.
+func (api *APIContext) generateTLSConfig(certPath, keyPath, clientCACertPath string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ClientAuth: tls.NoClientCert,
+	}
 
-	if api.config.Development.UseLocalhostTLS && certPath == "" {
-		serverCert, err = tls.X509KeyPair(devtlscert, devtlskey)
+	if api.config.Server.ACMEDomain != "" {
+		api.acmeManager = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(api.config.Server.ACMEDomain),
+			Cache:      autocert.DirCache(api.config.Server.ACMECacheDir),
+		}
+
+		tlsConfig.GetCertificate = api.acmeManager.GetCertificate
+	} else if api.config.Development.UseLocalhostTLS && certPath == "" {
+		serverCert, err := tls.X509KeyPair(devtlscert, devtlskey)
 		if err != nil {
 			return nil, err
 		}
+
+		tlsConfig.Certificates = []tls.Certificate{serverCert}
 	} else {
 		if certPath == "" || keyPath == "" {
 			return nil, fmt.Errorf("TLS cert and key cannot be empty")
 		}
 
-		serverCert, err = tls.LoadX509KeyPair(certPath, keyPath)
+		// Certs from disk are reloaded when they change so that renewals take effect without a restart.
+		reloader, err := newCertReloader(certPath, keyPath)
 		if err != nil {
 			return nil, err
 		}
+
+		tlsConfig.GetCertificate = reloader.GetCertificate
 	}
 
-	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{serverCert},
-		ClientAuth:   tls.NoClientCert,
+	if clientCACertPath != "" {
+		clientCACert, err := os.ReadFile(clientCACertPath)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(clientCACert) {
+			return nil, fmt.Errorf("could not parse client CA cert %q", clientCACertPath)
+		}
+
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		tlsConfig.ClientCAs = pool
 	}
 
 	return tlsConfig, nil
 }
 
+// certReloader serves a certificate loaded from disk and reloads it whenever the cert or key file is modified.
+type certReloader struct {
+	certPath string
+	keyPath  string
+
+	mu          sync.Mutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+// newCertReloader creates a certReloader, loading the certificate once up front so that a bad cert is caught at
+// startup instead of on the first connection.
+func newCertReloader(certPath, keyPath string) (*certReloader, error) {
+	reloader := &certReloader{
+		certPath: certPath,
+		keyPath:  keyPath,
+	}
+
+	_, err := reloader.GetCertificate(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+// GetCertificate returns the current certificate, first reloading it if either file has changed since it was last
+// loaded. If the reload fails, for example because a renewal is halfway through replacing the files, the previous
+// certificate is kept.
+func (r *certReloader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	certModTime, keyModTime, err := r.modTimes()
+	if err == nil && r.cert != nil && certModTime.Equal(r.certModTime) && keyModTime.Equal(r.keyModTime) {
+		return r.cert, nil
+	}
+
+	if err == nil {
+		var cert tls.Certificate
+		cert, err = tls.LoadX509KeyPair(r.certPath, r.keyPath)
+		if err == nil {
+			if r.cert != nil {
+				log.Info().Str("cert", r.certPath).Msg("reloaded TLS certificate")
+			}
+
+			r.cert = &cert
+			r.certModTime = certModTime
+			r.keyModTime = keyModTime
+			return r.cert, nil
+		}
+	}
+
+	if r.cert == nil {
+		return nil, err
+	}
+
+	log.Warn().Err(err).Str("cert", r.certPath).Msg("could not reload TLS certificate; continuing with previous certificate")
+	return r.cert, nil
+}
+
+func (r *certReloader) modTimes() (certModTime, keyModTime time.Time, err error) {
+	certInfo, err := os.Stat(r.certPath)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	keyInfo, err := os.Stat(r.keyPath)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
+
 // getTLSFiles returns certificates suppled from file paths. If server is in devmode and no cert is provided
 // it instead loads certificates from embedded files for ease of development.
 func (api *APIContext) getTLSFromFile(certPath, keyPath string) (cert, key []byte, err error) {
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	stdlog "log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA is a certificate authority that issues certificates for tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+
+	// certPath is where the CA's certificate was written in PEM format.
+	certPath string
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate CA key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "innerhaven test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("could not create CA cert: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("could not parse CA cert: %v", err)
+	}
+
+	certPath := filepath.Join(t.TempDir(), "ca.crt")
+	writePEM(t, certPath, "CERTIFICATE", der)
+
+	return &testCA{cert: cert, key: key, certPath: certPath}
+}
+
+// issue creates a certificate signed by the CA for localhost and 127.0.0.1 and writes it and its key to a temporary
+// directory, returning their paths.
+func (ca *testCA) issue(t *testing.T, usage x509.ExtKeyUsage) (certPath, keyPath string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("could not create cert: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("could not encode key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certPath = filepath.Join(dir, "tls.crt")
+	keyPath = filepath.Join(dir, "tls.key")
+	writePEM(t, certPath, "CERTIFICATE", der)
+	writePEM(t, keyPath, "EC PRIVATE KEY", keyDER)
+
+	return certPath, keyPath
+}
+
+// pool returns a cert pool trusting only the CA.
+func (ca *testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+
+	err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatalf("could not write %s: %v", path, err)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert, serverKey := ca.issue(t, x509.ExtKeyUsageServerAuth)
+
+	apictx, _ := newTestAPI(t, nil)
+	tlsConfig, err := apictx.generateTLSConfig(serverCert, serverKey, ca.certPath)
+	if err != nil {
+		t.Fatalf("could not generate TLS config: %v", err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	srv.TLS = tlsConfig
+	srv.Config.ErrorLog = stdlog.New(io.Discard, "", 0)
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	clientCertPath, clientKeyPath := ca.issue(t, x509.ExtKeyUsageClientAuth)
+	clientCert, err := tls.LoadX509KeyPair(clientCertPath, clientKeyPath)
+	if err != nil {
+		t.Fatalf("could not load client cert: %v", err)
+	}
+
+	otherCertPath, otherKeyPath := newTestCA(t).issue(t, x509.ExtKeyUsageClientAuth)
+	otherCert, err := tls.LoadX509KeyPair(otherCertPath, otherKeyPath)
+	if err != nil {
+		t.Fatalf("could not load client cert: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		certs    []tls.Certificate
+		accepted bool
+	}{
+		{name: "no client cert"},
+		{name: "cert from another CA", certs: []tls.Certificate{otherCert}},
+		{name: "cert from the client CA", certs: []tls.Certificate{clientCert}, accepted: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &http.Client{Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					RootCAs: ca.pool(),
+					// Sending a server name makes the server pick its certificate through GetCertificate.
+					ServerName:   "localhost",
+					Certificates: tc.certs,
+				},
+			}}
+
+			resp, err := client.Get(srv.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+
+			if accepted := err == nil; accepted != tc.accepted {
+				t.Errorf("accepted = %v, want %v (err: %v)", accepted, tc.accepted, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	term "github.com/nsf/termbox-go"
+)
+
+// tuiHeaderRows is the amount of rows drawn above the first plug row in the status table.
+const tuiHeaderRows = 1
+
+// tuiFlashDuration is how long a clicked row stays highlighted before the table is redrawn.
+const tuiFlashDuration = 200 * time.Millisecond
+
+// redrawUI renders the plug status table sized to the current terminal dimensions. It is called whenever the
+// state of a plug or the size of the terminal changes.
+func redrawUI(plugs []*plug) {
+	_ = term.Clear(term.ColorDefault, term.ColorDefault)
+
+	width, height := term.Size()
+
+	drawLine(0, width, fmt.Sprintf("%-8s %-6s %s", "KEY", "STATE", "PLUG"), term.ColorDefault|term.AttrBold, term.ColorDefault)
+
+	for i, plug := range plugs {
+		y := i + tuiHeaderRows
+		if y >= height {
+			break
+		}
+
+		drawPlugRow(y, width, plug, false)
+	}
+
+	_ = term.Flush()
+}
+
+// plugAtRow returns the plug drawn on terminal row y of the status table.
+func plugAtRow(plugs []*plug, y int) (*plug, bool) {
+	index := y - tuiHeaderRows
+	if index < 0 || index >= len(plugs) {
+		return nil, false
+	}
+
+	return plugs[index], true
+}
+
+// flashRow briefly draws the row at y with inverted colors to acknowledge a click.
+func flashRow(y int, plug *plug) {
+	width, _ := term.Size()
+	drawPlugRow(y, width, plug, true)
+	_ = term.Flush()
+	time.Sleep(tuiFlashDuration)
+}
+
+func drawPlugRow(y, width int, plug *plug, inverted bool) {
+	state, color := "off", term.ColorRed
+	if plug.IsOn() {
+		state, color = "on", term.ColorGreen
+	}
+
+	name := plug.Name
+	if name == "" {
+		name, _ = plug.addresses()
+	}
+
+	fg, bg := color, term.ColorDefault
+	if inverted {
+		fg |= term.AttrReverse
+	}
+
+	drawLine(y, width, fmt.Sprintf("%-8d %-6s %s", plug.TriggerKey, state, name), fg, bg)
+}
+
+// drawLine writes text on row y, truncating it to fit within width.
+func drawLine(y, width int, text string, fg, bg term.Attribute) {
+	x := 0
+	for _, r := range text {
+		if x >= width {
+			return
+		}
+		term.SetCell(x, y, r, fg, bg)
+		x++
+	}
+}
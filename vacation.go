@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// AwayModeBounds controls how long a plug stays on and off while in away mode. Each period is picked at random
+// between the given bounds so the pattern doesn't look automated from the outside.
+type AwayModeBounds struct {
+	MinOn  time.Duration
+	MaxOn  time.Duration
+	MinOff time.Duration
+	MaxOff time.Duration
+}
+
+// randomDuration returns a random duration in [min, max].
+func randomDuration(min, max time.Duration) time.Duration {
+	if max <= min {
+		return min
+	}
+	return min + rand.N(max-min+1)
+}
+
+// runAwayMode alternates the plug between on and off with random durations until the context is cancelled.
+func (apictx *APIContext) runAwayMode(ctx context.Context, p *plug, bounds AwayModeBounds) {
+	on := true
+
+	for {
+		if !apictx.acquirePlugCommandSlot(ctx) {
+			return
+		}
+		err := p.setState(ctx, PriorityNormal, on)
+		apictx.releasePlugCommandSlot()
+		if err != nil && ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Str("plug", p.Name).Msg("away mode could not change plug state")
+		}
+		apictx.recordStateChange("", p, stateName(on), AuditSourceRule, err == nil)
+
+		wait := randomDuration(bounds.MinOff, bounds.MaxOff)
+		if on {
+			wait = randomDuration(bounds.MinOn, bounds.MaxOn)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		on = !on
+	}
+}
+
+// startAwayMode puts the given plugs into away mode. It returns false without starting anything if any of the
+// plugs are already in away mode. Each plug must only be listed once.
+func (apictx *APIContext) startAwayMode(plugs []*plug, bounds AwayModeBounds) bool {
+	apictx.awayModesMu.Lock()
+	defer apictx.awayModesMu.Unlock()
+
+	for _, plug := range plugs {
+		if _, exists := apictx.awayModes[plug]; exists {
+			return false
+		}
+	}
+
+	for _, plug := range plugs {
+		ctx, cancel := context.WithCancel(context.Background())
+		apictx.awayModes[plug] = cancel
+		go apictx.runAwayMode(ctx, plug, bounds)
+	}
+
+	return true
+}
+
+// stopAwayMode takes every plug out of away mode, leaving them in whatever state they are currently in.
+// It returns the amount of plugs that were stopped.
+func (apictx *APIContext) stopAwayMode() int {
+	apictx.awayModesMu.Lock()
+	defer apictx.awayModesMu.Unlock()
+
+	stopped := len(apictx.awayModes)
+	for plug, cancel := range apictx.awayModes {
+		cancel()
+		delete(apictx.awayModes, plug)
+	}
+
+	return stopped
+}
+
+type (
+	StartVacationModeRequest struct {
+		Body struct {
+			PlugIPs    []string `json:"plug_ips" example:"[\"192.168.1.20\"]" minItems:"1" doc:"The IP addresses or hostnames of the plugs to cycle"`
+			MinOnSecs  int      `json:"min_on_secs" example:"600" minimum:"1" doc:"The shortest time a plug stays on"`
+			MaxOnSecs  int      `json:"max_on_secs" example:"1800" minimum:"1" doc:"The longest time a plug stays on"`
+			MinOffSecs int      `json:"min_off_secs" example:"300" minimum:"1" doc:"The shortest time a plug stays off"`
+			MaxOffSecs int      `json:"max_off_secs" example:"900" minimum:"1" doc:"The longest time a plug stays off"`
+		}
+	}
+	StartVacationModeResponse struct{}
+)
+
+func (apictx *APIContext) registerStartVacationMode(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "StartVacationMode",
+		Method:      http.MethodPost,
+		Path:        "/api/vacation-mode/start",
+		Summary:     "Start vacation mode",
+		Description: "Cycle the given plugs on and off for random durations within the given bounds so that the " +
+			"house looks occupied. Vacation mode runs until it is stopped.",
+		Tags:     []string{"Vacation Mode"},
+		Security: bearerAuth,
+		// Handler //
+	}, func(ctx context.Context, request *StartVacationModeRequest) (*StartVacationModeResponse, error) {
+		err := requireAdmin(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		body := request.Body
+
+		if body.MinOnSecs > body.MaxOnSecs {
+			return nil, invalidInputError("min_on_secs must be less than or equal to max_on_secs")
+		}
+
+		if body.MinOffSecs > body.MaxOffSecs {
+			return nil, invalidInputError("min_off_secs must be less than or equal to max_off_secs")
+		}
+
+		plugs := []*plug{}
+		seen := map[*plug]bool{}
+		for _, ip := range body.PlugIPs {
+			plug, exists := apictx.getPlug(ip)
+			if !exists {
+				return nil, plugNotFoundError(fmt.Sprintf("Plug %q not found", ip))
+			}
+
+			// The plugs are named in the body, so roleMiddleware can't check them.
+			if !apictx.plugAllowed(ctx, plug) {
+				return nil, huma.Error403Forbidden(fmt.Sprintf("This token may not use plug %q", ip))
+			}
+
+			// A plug listed twice, possibly under its backup address, would get two away mode loops and only the
+			// last could ever be stopped.
+			if seen[plug] {
+				return nil, invalidInputError(fmt.Sprintf("Plug %q is listed more than once", ip))
+			}
+			seen[plug] = true
+
+			plugs = append(plugs, plug)
+		}
+
+		started := apictx.startAwayMode(plugs, AwayModeBounds{
+			MinOn:  time.Duration(body.MinOnSecs) * time.Second,
+			MaxOn:  time.Duration(body.MaxOnSecs) * time.Second,
+			MinOff: time.Duration(body.MinOffSecs) * time.Second,
+			MaxOff: time.Duration(body.MaxOffSecs) * time.Second,
+		})
+		if !started {
+			return nil, huma.Error409Conflict("Vacation mode is already running for one or more of the requested plugs")
+		}
+
+		return &StartVacationModeResponse{}, nil
+	})
+}
+
+type (
+	StopVacationModeRequest  struct{}
+	StopVacationModeResponse struct{}
+)
+
+func (apictx *APIContext) registerStopVacationMode(apiDesc huma.API) {
+	// Description //
+	huma.Register(apiDesc, huma.Operation{
+		OperationID: "StopVacationMode",
+		Method:      http.MethodDelete,
+		Path:        "/api/vacation-mode",
+		Summary:     "Stop vacation mode",
+		Description: "Stop cycling all plugs in vacation mode. Plugs are left in whatever state they are currently in.",
+		Tags:        []string{"Vacation Mode"},
+		Security:    bearerAuth,
+		// Handler //
+	}, func(ctx context.Context, _ *StopVacationModeRequest) (*StopVacationModeResponse, error) {
+		err := requireAdmin(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		apictx.stopAwayMode()
+		return &StopVacationModeResponse{}, nil
+	})
+}
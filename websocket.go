@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// plugWebSocketPath is the path clients connect to for WebSocket plug control.
+	plugWebSocketPath = "/api/ws"
+
+	// How long a client has to answer a ping before it is considered gone.
+	wsPongWait = 60 * time.Second
+
+	// How often clients are pinged. Must be less than wsPongWait.
+	wsPingInterval = wsPongWait * 9 / 10
+
+	// How long a single write to the client may take.
+	wsWriteWait = 10 * time.Second
+
+	// The largest control message accepted from a client.
+	wsMaxMessageSize = 1024
+)
+
+// wsControlMessage is sent by clients to change a plug's state.
+type wsControlMessage struct {
+	Action string `json:"action"`
+	IP     string `json:"ip"`
+}
+
+// wsEventMessage is sent to clients whenever a plug changes state or one of their control messages fails.
+type wsEventMessage struct {
+	Event     string `json:"event"`
+	IP        string `json:"ip"`
+	Name      string `json:"name,omitempty"`
+	On        bool   `json:"on"`
+	Timestamp string `json:"timestamp"`
+	Error     string `json:"error,omitempty"`
+}
+
+var wsUpgrader = websocket.Upgrader{}
+
+var (
+	errPlugNotFound  = errors.New("plug not found")
+	errPlugForbidden = errors.New("token is not allowed to use this plug")
+	errUnknownAction = errors.New("unknown action; must be one of toggle, on or off")
+)
+
+// registerPlugWebSocket adds the WebSocket endpoint to the router. It's registered outside of huma since the
+// connection has to be hijacked from the underlying http.ResponseWriter.
+func (apictx *APIContext) registerPlugWebSocket(router chi.Router) {
+	router.Get(plugWebSocketPath, apictx.handlePlugWebSocket)
+}
+
+// handlePlugWebSocket lets clients toggle plugs with {"action":"toggle"|"on"|"off","ip":"..."} messages and
+// sends them a state_change event whenever any plug changes state.
+func (apictx *APIContext) handlePlugWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written an error response to the client.
+		log.Debug().Err(err).Msg("could not upgrade websocket connection")
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	_, events, unsubscribe := apictx.history.subscribe(0, false)
+	defer unsubscribe()
+
+	// Replies to individual control messages; the writer below is the only goroutine allowed to write.
+	replies := make(chan wsEventMessage, 16)
+
+	go func() {
+		// Closing the connection unblocks the reader below once we can no longer write.
+		defer conn.Close()
+		defer cancel()
+
+		ticker := time.NewTicker(wsPingInterval)
+		defer ticker.Stop()
+
+		for {
+			var message wsEventMessage
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				_ = conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+				if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+					return
+				}
+				continue
+			case reply := <-replies:
+				message = reply
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+
+				if !apictx.plugAllowed(ctx, event.plug) {
+					continue
+				}
+
+				message = wsEventMessage{
+					Event:     "state_change",
+					IP:        event.PlugIP,
+					Name:      event.PlugName,
+					On:        event.State,
+					Timestamp: event.Time.UTC().Format(time.RFC3339),
+				}
+			}
+
+			_ = conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteJSON(message); err != nil {
+				return
+			}
+		}
+	}()
+
+	conn.SetReadLimit(wsMaxMessageSize)
+	_ = conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		var message wsControlMessage
+		err := conn.ReadJSON(&message)
+		if err != nil {
+			return
+		}
+
+		// Commands can take seconds against a slow plug so they run alongside reading the next message.
+		go func(message wsControlMessage) {
+			if !apictx.acquirePlugCommandSlot(ctx) {
+				return
+			}
+			err := apictx.handleWebSocketControl(ctx, message)
+			apictx.releasePlugCommandSlot()
+			if err == nil {
+				return
+			}
+
+			select {
+			case replies <- wsEventMessage{
+				Event:     "error",
+				IP:        message.IP,
+				Timestamp: time.Now().UTC().Format(time.RFC3339),
+				Error:     err.Error(),
+			}:
+			case <-ctx.Done():
+			}
+		}(message)
+	}
+}
+
+// handleWebSocketControl carries out a single control message with the same rules as the REST endpoints.
+func (apictx *APIContext) handleWebSocketControl(ctx context.Context, message wsControlMessage) error {
+	err := requireAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	plug, exists := apictx.getPlug(message.IP)
+	if !exists {
+		return errPlugNotFound
+	}
+
+	if !apictx.plugAllowed(ctx, plug) {
+		return errPlugForbidden
+	}
+
+	switch message.Action {
+	case AuditActionToggle:
+		_, err = apictx.changePlugState(ctx, plug, false, true, false)
+	case AuditActionOn:
+		_, err = apictx.changePlugState(ctx, plug, true, false, false)
+	case AuditActionOff:
+		_, err = apictx.changePlugState(ctx, plug, false, false, false)
+	default:
+		return errUnknownAction
+	}
+
+	return err
+}